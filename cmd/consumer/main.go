@@ -14,6 +14,7 @@ import (
 	"github.com/vahtykov/go-replicator-service/internal/database"
 	"github.com/vahtykov/go-replicator-service/internal/kafka"
 	"github.com/vahtykov/go-replicator-service/internal/logger"
+	"github.com/vahtykov/go-replicator-service/internal/retention"
 )
 
 var (
@@ -46,7 +47,7 @@ func main() {
 
 	// Подключаемся к PostgreSQL
 	// ВАЖНО: Устанавливаем application_name для защиты от петли репликации
-	db, err := database.Connect(database.Config{
+	dbConfig := database.Config{
 		Host:            cfg.Database.Host,
 		Port:            cfg.Database.Port,
 		Database:        cfg.Database.Database,
@@ -58,7 +59,8 @@ func main() {
 		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
 		LogQueries:      cfg.Database.LogQueries,
 		ApplicationName: cfg.Database.ApplicationName, // ← Критично!
-	}, log)
+	}
+	db, err := database.Connect(dbConfig, log)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to connect to database")
 	}
@@ -67,6 +69,26 @@ func main() {
 		Str("application_name", cfg.Database.ApplicationName).
 		Msg("Database connection established with application_name")
 
+	// Очистка processed_events по расписанию (один экземпляр на контур)
+	var cleaner *retention.Cleaner
+	if cfg.Retention.Enabled {
+		lockName := fmt.Sprintf("replicator_retention:%s:%s:processed_events", cfg.Service.Contour, cfg.Database.Database)
+		cleaner, err = retention.New(db, database.NewLeaderLock(dbConfig, lockName, cfg.Retention.Identity, log), retention.Config{
+			Schedule:   cfg.Retention.Schedule,
+			Window:     retention.Window{Start: cfg.Retention.Window.Start, End: cfg.Retention.Window.End},
+			BatchSize:  cfg.Retention.BatchSize,
+			BatchPause: cfg.Retention.BatchPause,
+			Jobs: []retention.Job{{
+				Table:  "processed_events",
+				Column: "processed_at",
+				MaxAge: cfg.Retention.MaxAge,
+			}},
+		}, log)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create retention")
+		}
+	}
+
 	// Создаем Kafka consumer
 	kafkaConsumer, err := kafka.NewConsumer(kafka.ConsumerConfig{
 		Brokers:           cfg.Kafka.Brokers,
@@ -80,19 +102,88 @@ func main() {
 		SessionTimeoutMs:  cfg.Kafka.SessionTimeoutMs,
 		MaxPollIntervalMs: cfg.Kafka.MaxPollIntervalMs,
 		Topics:            cfg.Kafka.Topics,
+		TopicPattern:              cfg.Kafka.TopicPattern,
+		MetadataRefreshIntervalMs: cfg.Kafka.MetadataRefreshIntervalMs,
 	}, log)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create Kafka consumer")
 	}
 	defer kafkaConsumer.Close()
 
+	// Создаем DLQ (producer для DLQ топика + таблица replication_dlq)
+	var dlq *consumer.DeadLetterQueue
+	if cfg.DLQ.Enabled {
+		dlqProducer, err := kafka.NewProducer(kafka.ProducerConfig{
+			Brokers:           cfg.Kafka.Brokers,
+			SSLEnabled:        cfg.Kafka.SSLEnabled,
+			SSLCACert:         cfg.Kafka.SSLCACert,
+			SSLClientCert:     cfg.Kafka.SSLClientCert,
+			SSLClientKey:      cfg.Kafka.SSLClientKey,
+			Acks:              "all",
+			Compression:       "none",
+			MaxInFlight:       5,
+			BatchSize:         16384,
+			LingerMs:          0,
+			EnableIdempotence: true,
+		}, log)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create DLQ Kafka producer")
+		}
+		defer dlqProducer.Close()
+
+		dlq = consumer.NewDeadLetterQueue(db, dlqProducer, cfg.DLQ.Topic, cfg.Service.Contour, log)
+	}
+
+	// Политики конфликтов отдельных таблиц и SQL resolver для стратегии custom
+	tableConflicts := make(map[string]consumer.ConflictPolicy)
+	resolvers := consumer.NewResolverRegistry()
+	for _, table := range cfg.Replication.ConflictResolutions() {
+		tableConflicts[table.Name] = consumer.ConflictPolicy{
+			Strategy: table.ConflictResolution,
+			Column:   table.ConflictColumn,
+			TieBreak: table.TieBreak,
+			Fallback: table.MergeFallback,
+		}
+
+		if table.Resolver == nil {
+			continue
+		}
+		var resolver *consumer.SQLResolver
+		if table.Resolver.Function != "" {
+			resolver, err = consumer.NewSQLFunctionResolver(table.Resolver.Function)
+		} else {
+			resolver, err = consumer.NewSQLExpressionResolver(table.Resolver.Expression)
+		}
+		if err != nil {
+			log.Fatal().Err(err).Str("table", table.Name).Msg("Invalid conflict resolver")
+		}
+		resolvers.Register(table.Name, resolver)
+	}
+
 	// Создаем Consumer
-	cons := consumer.New(db, kafkaConsumer, consumer.Config{
+	cons := consumer.New(db, kafkaConsumer, dlq, consumer.Config{
 		MyContour:          cfg.Service.Contour,
 		Database:           cfg.Database.Database,
 		BatchSize:          cfg.Processing.BatchSize,
+		BatchMaxWait:       cfg.Processing.BatchMaxWait,
 		EventTimeout:       cfg.Processing.EventTimeout,
+		LockTimeout:        cfg.Processing.LockTimeout,
 		ConflictResolution: cfg.Processing.ConflictResolution,
+		TableConflicts:     tableConflicts,
+		Resolvers:          resolvers,
+		SoftDeletes:        cfg.Replication.SoftDeleteColumns(),
+		ApplyMode:          cfg.Processing.ApplyMode,
+		Tables:             cfg.Replication.TableNames(),
+		UnknownColumns:     cfg.Replication.UnknownColumns,
+		Retry:              cfg.Processing.Retry.Policy(),
+		RedriveInterval:    cfg.DLQ.RedriveInterval,
+		TombstoneRetention:       cfg.Replication.Tombstones.Retention,
+		TombstoneCleanupInterval: cfg.Replication.Tombstones.CleanupInterval,
+		MaxWorkers:         cfg.Processing.MaxWorkers,
+		PartitionQueueSize: cfg.Processing.PartitionQueueSize,
+		TransactionTimeout: cfg.Processing.TransactionTimeout,
+		DependencyTimeout:  cfg.Processing.Dependencies.Timeout,
+		MaxPendingEvents:   cfg.Processing.Dependencies.MaxPending,
 	}, log)
 
 	// Контекст с graceful shutdown
@@ -111,6 +202,15 @@ func main() {
 		}
 	}()
 
+	// Запускаем очистку по расписанию
+	if cleaner != nil {
+		go func() {
+			if err := cleaner.Start(ctx); err != nil && err != context.Canceled {
+				errChan <- err
+			}
+		}()
+	}
+
 	// Ждем сигнал остановки или ошибку
 	select {
 	case sig := <-sigChan:
@@ -125,13 +225,29 @@ func main() {
 		time.Sleep(2 * time.Second)
 		
 		// Выводим метрики
-		processed, skipped, failed := cons.GetMetrics()
+		processed, skipped, failed, deadLettered, timedOut, incompleteTx := cons.GetMetrics()
+		pending, pendingParents, parentTimeouts := cons.DependencyMetrics()
 		log.Info().
 			Int64("processed", processed).
 			Int64("skipped", skipped).
 			Int64("failed", failed).
+			Int64("dead_lettered", deadLettered).
+			Int64("timed_out", timedOut).
+			Int64("incomplete_transactions", incompleteTx).
+			Int("pending_events", pending).
+			Int("pending_parents", pendingParents).
+			Int64("parent_timeouts", parentTimeouts).
 			Msg("Consumer metrics")
 		
+		if cleaner != nil {
+			for table, removed := range cleaner.Removed() {
+				log.Info().
+					Str("table", table).
+					Int64("removed", removed).
+					Msg("Retention metrics")
+			}
+		}
+		
 		log.Info().Msg("ReplicatorConsumer stopped gracefully")
 		
 	case err := <-errChan:
@@ -14,6 +14,7 @@ import (
 	"github.com/vahtykov/go-replicator-service/internal/kafka"
 	"github.com/vahtykov/go-replicator-service/internal/logger"
 	"github.com/vahtykov/go-replicator-service/internal/publisher"
+	"github.com/vahtykov/go-replicator-service/internal/retention"
 )
 
 var (
@@ -45,7 +46,7 @@ func main() {
 		Msg("Starting ReplicatorPublisher")
 
 	// Подключаемся к PostgreSQL
-	db, err := database.Connect(database.Config{
+	dbConfig := database.Config{
 		Host:            cfg.Database.Host,
 		Port:            cfg.Database.Port,
 		Database:        cfg.Database.Database,
@@ -56,11 +57,65 @@ func main() {
 		MaxIdleConns:    cfg.Database.MaxIdleConns,
 		ConnMaxLifetime: cfg.Database.ConnMaxLifetime,
 		LogQueries:      cfg.Database.LogQueries,
-	}, log)
+	}
+	db, err := database.Connect(dbConfig, log)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to connect to database")
 	}
 
+	// LISTEN-соединение для пробуждения по NOTIFY
+	var listener *database.Listener
+	if cfg.Service.ListenNotify {
+		listener = database.NewListener(dbConfig, publisher.NotifyChannel, log)
+	}
+
+	// Блокировка выбора ведущего: один активный publisher на контур и БД
+	var leaderLock *database.LeaderLock
+	if cfg.Service.LeaderElection.Enabled {
+		lockName := fmt.Sprintf("replicator_publisher:%s:%s", cfg.Service.Contour, cfg.Database.Database)
+		leaderLock = database.NewLeaderLock(dbConfig, lockName, cfg.Service.LeaderElection.Identity, log)
+	}
+
+	// Очистка опубликованных записей replication_queue и карантина replication_queue_failed
+	// по расписанию (один экземпляр на контур)
+	var cleaner *retention.Cleaner
+	if cfg.Retention.Enabled {
+		lockName := fmt.Sprintf("replicator_retention:%s:%s:replication_queue", cfg.Service.Contour, cfg.Database.Database)
+		cleaner, err = retention.New(db, database.NewLeaderLock(dbConfig, lockName, cfg.Retention.Identity, log), retention.Config{
+			Schedule:   cfg.Retention.Schedule,
+			Window:     retention.Window{Start: cfg.Retention.Window.Start, End: cfg.Retention.Window.End},
+			BatchSize:  cfg.Retention.BatchSize,
+			BatchPause: cfg.Retention.BatchPause,
+			Jobs: []retention.Job{
+				{
+					Table:    "replication_queue",
+					Function: "cleanup_replication_queue_batch", // Правила отбора - в sql/01_create_tables.sql
+					MaxAge:   cfg.Retention.MaxAge,
+				},
+				{
+					Table:  "replication_queue_failed",
+					Column: "failed_at",
+					MaxAge: cfg.Retention.FailedMaxAge,
+				},
+			},
+		}, log)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create retention")
+		}
+	}
+
+	// Публикация по шардам ключей
+	var sharding *publisher.ShardingConfig
+	if cfg.Service.Sharding.Enabled {
+		sharding = &publisher.ShardingConfig{
+			Count:    cfg.Service.Sharding.Count,
+			Shards:   cfg.Service.Sharding.Shards,
+			Lease:    cfg.Service.Sharding.Mode == "lease",
+			Identity: cfg.Service.Sharding.Identity,
+			LeaseTTL: cfg.Service.Sharding.LeaseTTL,
+		}
+	}
+
 	// Создаем Kafka producer
 	kafkaProducer, err := kafka.NewProducer(kafka.ProducerConfig{
 		Brokers:       cfg.Kafka.Brokers,
@@ -73,6 +128,7 @@ func main() {
 		MaxInFlight:   cfg.Kafka.MaxInFlight,
 		BatchSize:     cfg.Kafka.BatchSize,
 		LingerMs:      cfg.Kafka.LingerMs,
+		EnableIdempotence: cfg.Kafka.EnableIdempotence,
 	}, log)
 	if err != nil {
 		log.Fatal().Err(err).Msg("Failed to create Kafka producer")
@@ -85,6 +141,22 @@ func main() {
 		Database:     cfg.Database.Database,
 		PollInterval: cfg.Service.PollInterval,
 		BatchSize:    cfg.Service.BatchSize,
+		PrimaryKeys:  cfg.Replication.PrimaryKeys(),
+		SoftDeletes:  cfg.Replication.SoftDeleteColumns(),
+		PublishMode:     cfg.Service.PublishMode,
+		DeliveryTimeout: cfg.Service.DeliveryTimeout,
+		Listener:         listener,
+		IdlePollInterval: cfg.Service.IdlePollInterval,
+		Routing: publisher.RoutingConfig{
+			Mode:          cfg.Replication.Routing.Mode,
+			TopicTemplate: cfg.Replication.Routing.TopicTemplate,
+			SharedTopic:   cfg.Replication.Routing.SharedTopic,
+			TableTopics:   cfg.Replication.TableTopics(),
+		},
+		Leader:              leaderLock,
+		LeaderCheckInterval: cfg.Service.LeaderElection.CheckInterval,
+		Sharding:            sharding,
+		Retry:               cfg.Service.Retry.Policy(),
 	}, log)
 
 	// Контекст с graceful shutdown
@@ -103,6 +175,15 @@ func main() {
 		}
 	}()
 
+	// Запускаем очистку по расписанию
+	if cleaner != nil {
+		go func() {
+			if err := cleaner.Start(ctx); err != nil && err != context.Canceled {
+				errChan <- err
+			}
+		}()
+	}
+
 	// Ждем сигнал остановки или ошибку
 	select {
 	case sig := <-sigChan:
@@ -117,12 +198,31 @@ func main() {
 		time.Sleep(2 * time.Second)
 		
 		// Выводим метрики
-		processed, failed := pub.GetMetrics()
+		processed, failed, quarantined := pub.GetMetrics()
 		log.Info().
 			Int64("processed", processed).
 			Int64("failed", failed).
+			Int64("quarantined", quarantined).
 			Msg("Publisher metrics")
 		
+		if cleaner != nil {
+			for table, removed := range cleaner.Removed() {
+				log.Info().
+					Str("table", table).
+					Int64("removed", removed).
+					Msg("Retention metrics")
+			}
+		}
+		
+		if status := pub.Status(); status.Enabled {
+			log.Info().
+				Str("identity", status.Identity).
+				Bool("leader", status.Leader).
+				Str("current_leader", status.LeaderIdentity).
+				Time("since", status.Since).
+				Msg("Publisher leader status")
+		}
+		
 		log.Info().Msg("ReplicatorPublisher stopped gracefully")
 		
 	case err := <-errChan:
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -14,6 +15,8 @@ type Config struct {
 	Database DatabaseConfig `yaml:"database"`
 	Kafka    KafkaConfig    `yaml:"kafka"`
 	Logging  LoggingConfig  `yaml:"logging"`
+	Replication ReplicationConfig `yaml:"replication"`
+	Retention   RetentionConfig   `yaml:"retention"` // Очистка replication_queue и replication_queue_failed
 }
 
 // ServiceConfig содержит настройки сервиса
@@ -22,6 +25,113 @@ type ServiceConfig struct {
 	Contour      string        `yaml:"contour"`
 	PollInterval time.Duration `yaml:"poll_interval"`
 	BatchSize    int           `yaml:"batch_size"`
+
+	// PublishMode - режим публикации батча: sync (по умолчанию) или batch (конвейерно)
+	PublishMode     string        `yaml:"publish_mode"`
+	DeliveryTimeout time.Duration `yaml:"delivery_timeout"` // Ожидание delivery reports батча
+
+	// ListenNotify включает пробуждение по NOTIFY из replication_queue вместо чистого опроса
+	ListenNotify     bool          `yaml:"listen_notify"`
+	IdlePollInterval time.Duration `yaml:"idle_poll_interval"` // Страховочный опрос, пока LISTEN активен
+
+	// LeaderElection включает режим одного активного publisher на контур
+	LeaderElection LeaderElectionConfig `yaml:"leader_election"`
+
+	// Sharding включает параллельную публикацию по шардам ключей (альтернатива leader_election)
+	Sharding ShardingConfig `yaml:"sharding"`
+
+	// Retry - повторные попытки публикации записи; исчерпавшие их записи
+	// переносятся в replication_queue_failed
+	Retry RetryConfig `yaml:"retry"`
+}
+
+// ShardingConfig содержит настройки публикации по шардам: запись replication_queue попадает
+// в шард по хешу (таблица, primary key), изменения одной строки публикует один воркер
+type ShardingConfig struct {
+	Enabled  bool          `yaml:"enabled"`
+	Count    int           `yaml:"count"`     // Число шардов (одинаковое на всех экземплярах)
+	Mode     string        `yaml:"mode"`      // static - шарды из shards, lease - аренда в replication_shard_leases
+	Shards   []int         `yaml:"shards"`    // Шарды экземпляра для mode: static (пусто - все)
+	Identity string        `yaml:"identity"`  // Владелец аренды (по умолчанию hostname-pid)
+	LeaseTTL time.Duration `yaml:"lease_ttl"` // Срок аренды шарда
+}
+
+// LeaderElectionConfig содержит настройки выбора ведущего publisher через advisory lock
+type LeaderElectionConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	Identity      string        `yaml:"identity"`       // Идентификатор экземпляра (по умолчанию hostname-pid)
+	CheckInterval time.Duration `yaml:"check_interval"` // Проверка блокировки и попытки захвата резервными
+}
+
+// RetentionConfig содержит настройки встроенной очистки служебной таблицы по расписанию.
+// Из экземпляров сервиса на контуре очистку выполняет один - захвативший advisory lock.
+type RetentionConfig struct {
+	Enabled    bool            `yaml:"enabled"`
+	MaxAge     time.Duration   `yaml:"max_age"`     // Срок хранения строк
+	Schedule   string          `yaml:"schedule"`    // Cron-выражение запуска
+	Window     RetentionWindow `yaml:"window"`      // Время суток, в которое разрешено удалять строки
+	BatchSize  int             `yaml:"batch_size"`  // Сколько строк удалять одной транзакцией
+	BatchPause time.Duration   `yaml:"batch_pause"` // Пауза между батчами
+	Identity   string          `yaml:"identity"`    // Идентификатор экземпляра (по умолчанию hostname-pid)
+
+	// Срок хранения записей replication_queue_failed (только publisher, по умолчанию 30 дней)
+	FailedMaxAge time.Duration `yaml:"failed_max_age"`
+}
+
+// RetentionWindow - интервал времени суток "HH:MM" (через полночь, если end < start)
+type RetentionWindow struct {
+	Start string `yaml:"start"`
+	End   string `yaml:"end"`
+}
+
+// setDefaults задает значения по умолчанию; maxAge - срок хранения таблицы сервиса
+func (r *RetentionConfig) setDefaults(maxAge time.Duration) {
+	if r.MaxAge == 0 {
+		r.MaxAge = maxAge
+	}
+	if r.Schedule == "" {
+		r.Schedule = "*/15 * * * *"
+	}
+	if r.BatchSize == 0 {
+		r.BatchSize = 5000
+	}
+	if r.BatchPause == 0 {
+		r.BatchPause = 100 * time.Millisecond
+	}
+	if r.Identity == "" {
+		r.Identity = instanceID()
+	}
+}
+
+// validate проверяет настройки очистки
+func (r RetentionConfig) validate() error {
+	if !r.Enabled {
+		return nil
+	}
+	if r.MaxAge <= 0 {
+		return fmt.Errorf("retention.max_age must be positive")
+	}
+	if r.BatchSize <= 0 {
+		return fmt.Errorf("retention.batch_size must be positive")
+	}
+	if r.BatchPause < 0 {
+		return fmt.Errorf("retention.batch_pause must not be negative")
+	}
+	if r.Window.Start == "" && r.Window.End == "" {
+		return nil
+	}
+	start, err := time.Parse("15:04", r.Window.Start)
+	if err != nil {
+		return fmt.Errorf("retention.window.start must be HH:MM: %s", r.Window.Start)
+	}
+	end, err := time.Parse("15:04", r.Window.End)
+	if err != nil {
+		return fmt.Errorf("retention.window.end must be HH:MM: %s", r.Window.End)
+	}
+	if start.Equal(end) {
+		return fmt.Errorf("retention.window: start and end must differ")
+	}
+	return nil
 }
 
 // DatabaseConfig содержит настройки подключения к PostgreSQL
@@ -53,6 +163,72 @@ type KafkaConfig struct {
 	MaxInFlight  int    `yaml:"max_in_flight"`
 	BatchSize    int    `yaml:"batch_size"`
 	LingerMs     int    `yaml:"linger_ms"`
+
+	EnableIdempotence bool `yaml:"enable_idempotence"` // Идемпотентный producer (порядок внутри партиции при ретраях)
+}
+
+// ReplicationConfig содержит настройки реплицируемых таблиц
+type ReplicationConfig struct {
+	Routing RoutingConfig `yaml:"routing"`
+	Tables  []TableConfig `yaml:"tables"`
+}
+
+// RoutingConfig содержит настройки маршрутизации событий по топикам
+type RoutingConfig struct {
+	Mode          string `yaml:"mode"`           // per_table (по умолчанию) или shared
+	TopicTemplate string `yaml:"topic_template"` // Плейсхолдеры: {contour}, {database}, {schema}, {table}
+	SharedTopic   string `yaml:"shared_topic"`   // Общий топик для mode: shared
+}
+
+// TableConfig содержит настройки репликации одной таблицы
+type TableConfig struct {
+	Name       string   `yaml:"name"`
+	Topic      string   `yaml:"topic"`       // Явный топик таблицы (приоритетнее topic_template)
+	PrimaryKey []string `yaml:"primary_key"` // Если не задан, читается из pg_index (для primary_key name может быть schema.table)
+	// SoftDeleteColumn - изменения, после которых колонка не NULL (мягкое удаление),
+	// публикуются как логический DELETE
+	SoftDeleteColumn string `yaml:"soft_delete_column"`
+}
+
+// TableTopics возвращает явно заданные топики по имени таблицы schema.table
+func (r ReplicationConfig) TableTopics() map[string]string {
+	topics := make(map[string]string, len(r.Tables))
+	for _, table := range r.Tables {
+		if table.Topic != "" {
+			topics[qualifyTableName(table.Name)] = table.Topic
+		}
+	}
+	return topics
+}
+
+// PrimaryKeys возвращает колонки primary key, заданные в конфигурации, по имени таблицы
+func (r ReplicationConfig) PrimaryKeys() map[string][]string {
+	keys := make(map[string][]string, len(r.Tables))
+	for _, table := range r.Tables {
+		if len(table.PrimaryKey) > 0 {
+			keys[table.Name] = table.PrimaryKey
+		}
+	}
+	return keys
+}
+
+// SoftDeleteColumns возвращает колонки мягкого удаления, публикуемого как DELETE, по имени таблицы schema.table
+func (r ReplicationConfig) SoftDeleteColumns() map[string]string {
+	columns := make(map[string]string)
+	for _, table := range r.Tables {
+		if table.SoftDeleteColumn != "" {
+			columns[qualifyTableName(table.Name)] = table.SoftDeleteColumn
+		}
+	}
+	return columns
+}
+
+// qualifyTableName дополняет имя таблицы схемой public, если схема не указана
+func qualifyTableName(name string) string {
+	if !strings.Contains(name, ".") {
+		return "public." + name
+	}
+	return name
 }
 
 // LoggingConfig содержит настройки логирования
@@ -78,6 +254,9 @@ func Load(configPath string) (*Config, error) {
 	// Переопределяем значениями из переменных окружения
 	cfg.overrideFromEnv()
 
+	// Значения по умолчанию
+	cfg.setDefaults()
+
 	// Валидация
 	if err := cfg.validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
@@ -132,6 +311,54 @@ func (c *Config) overrideFromEnv() {
 	}
 }
 
+// setDefaults задает значения по умолчанию для необязательных параметров
+func (c *Config) setDefaults() {
+	if c.Service.PublishMode == "" {
+		c.Service.PublishMode = "sync"
+	}
+	if c.Service.DeliveryTimeout <= 0 {
+		c.Service.DeliveryTimeout = 30 * time.Second
+	}
+	if c.Service.IdlePollInterval <= 0 {
+		c.Service.IdlePollInterval = 30 * time.Second
+	}
+	if c.Service.LeaderElection.CheckInterval <= 0 {
+		c.Service.LeaderElection.CheckInterval = 5 * time.Second
+	}
+	if c.Service.LeaderElection.Identity == "" {
+		c.Service.LeaderElection.Identity = instanceID()
+	}
+	if c.Service.Sharding.Mode == "" {
+		c.Service.Sharding.Mode = "static"
+	}
+	if c.Service.Sharding.Identity == "" {
+		c.Service.Sharding.Identity = instanceID()
+	}
+	if c.Service.Sharding.LeaseTTL <= 0 {
+		c.Service.Sharding.LeaseTTL = 30 * time.Second
+	}
+	c.Service.Retry.setDefaults(5 * time.Minute)
+	c.Retention.setDefaults(7 * 24 * time.Hour)
+	if c.Retention.FailedMaxAge == 0 {
+		c.Retention.FailedMaxAge = 30 * 24 * time.Hour
+	}
+	if c.Replication.Routing.Mode == "" {
+		c.Replication.Routing.Mode = "per_table"
+	}
+	if c.Replication.Routing.TopicTemplate == "" {
+		c.Replication.Routing.TopicTemplate = "{table}_changes"
+	}
+}
+
+// instanceID возвращает идентификатор экземпляра по умолчанию: hostname-pid
+func instanceID() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "publisher"
+	}
+	return fmt.Sprintf("%s-%d", hostname, os.Getpid())
+}
+
 // validate проверяет корректность конфигурации
 func (c *Config) validate() error {
 	// Service validation
@@ -141,9 +368,47 @@ func (c *Config) validate() error {
 	if c.Service.Contour == "" {
 		return fmt.Errorf("service.contour is required")
 	}
+	if c.Service.PollInterval <= 0 {
+		return fmt.Errorf("service.poll_interval must be positive")
+	}
 	if c.Service.BatchSize <= 0 {
 		return fmt.Errorf("service.batch_size must be positive")
 	}
+	if c.Service.PublishMode != "sync" && c.Service.PublishMode != "batch" {
+		return fmt.Errorf("invalid service.publish_mode: %s", c.Service.PublishMode)
+	}
+
+	if err := c.Service.Retry.validate("service.retry"); err != nil {
+		return err
+	}
+
+	if sharding := c.Service.Sharding; sharding.Enabled {
+		if c.Service.LeaderElection.Enabled {
+			return fmt.Errorf("service.sharding and service.leader_election are mutually exclusive")
+		}
+		if sharding.Count <= 0 {
+			return fmt.Errorf("service.sharding.count must be positive")
+		}
+		switch sharding.Mode {
+		case "static":
+			seen := make(map[int]bool, len(sharding.Shards))
+			for _, shard := range sharding.Shards {
+				if shard < 0 || shard >= sharding.Count {
+					return fmt.Errorf("service.sharding.shards: shard %d is out of range [0, %d)", shard, sharding.Count)
+				}
+				if seen[shard] {
+					return fmt.Errorf("service.sharding.shards: duplicate shard %d", shard)
+				}
+				seen[shard] = true
+			}
+		case "lease":
+			if len(sharding.Shards) > 0 {
+				return fmt.Errorf("service.sharding.shards is not allowed for mode: lease")
+			}
+		default:
+			return fmt.Errorf("invalid service.sharding.mode: %s", sharding.Mode)
+		}
+	}
 
 	// Database validation
 	if c.Database.Host == "" {
@@ -163,6 +428,55 @@ func (c *Config) validate() error {
 	if len(c.Kafka.Brokers) == 0 {
 		return fmt.Errorf("kafka.brokers is required")
 	}
+	if c.Kafka.EnableIdempotence {
+		if c.Kafka.Acks != "all" && c.Kafka.Acks != "-1" {
+			return fmt.Errorf("kafka.enable_idempotence requires kafka.acks: all")
+		}
+		if c.Kafka.MaxInFlight > 5 {
+			return fmt.Errorf("kafka.enable_idempotence requires kafka.max_in_flight <= 5")
+		}
+	}
+	if c.Service.PublishMode == "batch" && !c.Kafka.EnableIdempotence {
+		// Без идемпотентности ретраи при max_in_flight > 1 могут переставить сообщения одного ключа
+		return fmt.Errorf("service.publish_mode: batch requires kafka.enable_idempotence: true")
+	}
+
+	// Replication validation
+	switch c.Replication.Routing.Mode {
+	case "per_table":
+		if !strings.Contains(c.Replication.Routing.TopicTemplate, "{table}") {
+			return fmt.Errorf("replication.routing.topic_template must contain {table} placeholder")
+		}
+	case "shared":
+		if c.Replication.Routing.SharedTopic == "" {
+			return fmt.Errorf("replication.routing.shared_topic is required for mode: shared")
+		}
+	default:
+		return fmt.Errorf("invalid replication.routing.mode: %s", c.Replication.Routing.Mode)
+	}
+
+	seenTables := make(map[string]bool, len(c.Replication.Tables))
+	for i, table := range c.Replication.Tables {
+		if table.Name == "" {
+			return fmt.Errorf("replication.tables[%d].name is required", i)
+		}
+		name := qualifyTableName(table.Name)
+		if seenTables[name] {
+			return fmt.Errorf("replication.tables: duplicate table %s", table.Name)
+		}
+		seenTables[name] = true
+
+		if table.Topic != "" && c.Replication.Routing.Mode == "shared" {
+			return fmt.Errorf("replication.tables[%d].topic is not allowed for routing mode: shared", i)
+		}
+	}
+
+	if err := c.Retention.validate(); err != nil {
+		return err
+	}
+	if c.Retention.Enabled && c.Retention.FailedMaxAge <= 0 {
+		return fmt.Errorf("retention.failed_max_age must be positive")
+	}
 
 	// Logging validation
 	validLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
@@ -0,0 +1,23 @@
+package config
+
+import "testing"
+
+func TestReplicationConfigQualifiesTableNames(t *testing.T) {
+	cfg := ReplicationConfig{Tables: []TableConfig{
+		{Name: "users", Topic: "accounts_stream", SoftDeleteColumn: "deleted_at"},
+		{Name: "billing.users", Topic: "billing_users", SoftDeleteColumn: "archived_at"},
+	}}
+
+	topics := cfg.TableTopics()
+	if topics["public.users"] != "accounts_stream" || topics["billing.users"] != "billing_users" {
+		t.Errorf("TableTopics() = %v", topics)
+	}
+	if _, ok := topics["users"]; ok {
+		t.Errorf("TableTopics() has bare table name: %v", topics)
+	}
+
+	columns := cfg.SoftDeleteColumns()
+	if columns["public.users"] != "deleted_at" || columns["billing.users"] != "archived_at" {
+		t.Errorf("SoftDeleteColumns() = %v", columns)
+	}
+}
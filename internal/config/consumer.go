@@ -3,18 +3,25 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
+
+	"github.com/vahtykov/go-replicator-service/internal/retry"
 )
 
 // ConsumerConfig представляет конфигурацию ReplicatorConsumer
 type ConsumerConfig struct {
-	Service    ConsumerServiceConfig    `yaml:"service"`
-	Database   DatabaseConfig           `yaml:"database"`
-	Kafka      ConsumerKafkaConfig      `yaml:"kafka"`
-	Logging    LoggingConfig            `yaml:"logging"`
-	Processing ProcessingConfig         `yaml:"processing"`
+	Service     ConsumerServiceConfig     `yaml:"service"`
+	Database    DatabaseConfig            `yaml:"database"`
+	Kafka       ConsumerKafkaConfig       `yaml:"kafka"`
+	Logging     LoggingConfig             `yaml:"logging"`
+	Processing  ProcessingConfig          `yaml:"processing"`
+	DLQ         DLQConfig                 `yaml:"dlq"`
+	Replication ConsumerReplicationConfig `yaml:"replication"`
+	Retention   RetentionConfig           `yaml:"retention"` // Очистка processed_events
 }
 
 // ConsumerServiceConfig содержит настройки сервиса
@@ -38,13 +45,161 @@ type ConsumerKafkaConfig struct {
 	SessionTimeoutMs   int      `yaml:"session_timeout_ms"`
 	MaxPollIntervalMs  int      `yaml:"max_poll_interval_ms"`
 	Topics             []string `yaml:"topics"`
+
+	// TopicPattern - подписка по регулярному выражению (должно начинаться с "^")
+	TopicPattern              string `yaml:"topic_pattern"`
+	MetadataRefreshIntervalMs int    `yaml:"metadata_refresh_interval_ms"`
 }
 
 // ProcessingConfig содержит настройки обработки
 type ProcessingConfig struct {
-	BatchSize          int           `yaml:"batch_size"`
-	EventTimeout       time.Duration `yaml:"event_timeout"`
+	BatchSize          int           `yaml:"batch_size"`     // Сколько сообщений партиции применять одной транзакцией
+	BatchMaxWait       time.Duration `yaml:"batch_max_wait"` // Сколько ждать добора батча после первого сообщения
+	EventTimeout       time.Duration `yaml:"event_timeout"`  // Дедлайн транзакции события (батча) и statement_timeout
+	LockTimeout        time.Duration `yaml:"lock_timeout"`   // lock_timeout транзакции (по умолчанию = event_timeout)
 	ConflictResolution string        `yaml:"conflict_resolution"`
+	ApplyMode          string        `yaml:"apply_mode"` // select (по умолчанию) или upsert
+	Retry              RetryConfig   `yaml:"retry"`
+
+	// Параллельное применение: у каждой партиции свой упорядоченный воркер
+	MaxWorkers         int           `yaml:"max_workers"`          // Сколько партиций применяется к БД одновременно
+	PartitionQueueSize int           `yaml:"partition_queue_size"` // Очередь воркера (при переполнении партиция на паузе)
+
+	// Атомарное применение исходных транзакций: сколько ждать все их события (0 - выключено)
+	TransactionTimeout time.Duration `yaml:"transaction_timeout"`
+
+	// Ожидание родительских строк по внешним ключам
+	Dependencies DependenciesConfig `yaml:"dependencies"`
+}
+
+// DependenciesConfig содержит настройки ожидания родительских строк: событие, ссылающееся
+// на еще не реплицированную строку другой таблицы, ждет ее, а не применяется до родителя
+type DependenciesConfig struct {
+	Timeout    time.Duration `yaml:"timeout"`     // Сколько ждать родителя (0 - не ждать), затем retry и DLQ
+	MaxPending int           `yaml:"max_pending"` // Сколько событий могут ждать одновременно
+}
+
+// RetryConfig содержит политику повторных попыток (применения события в consumer, публикации в publisher)
+type RetryConfig struct {
+	MaxAttempts     int           `yaml:"max_attempts"`
+	InitialInterval time.Duration `yaml:"initial_interval"`
+	MaxInterval     time.Duration `yaml:"max_interval"`
+	Multiplier      float64       `yaml:"multiplier"`
+}
+
+// setDefaults задает значения по умолчанию; maxInterval - предельная задержка сервиса
+func (r *RetryConfig) setDefaults(maxInterval time.Duration) {
+	if r.MaxAttempts == 0 {
+		r.MaxAttempts = 5
+	}
+	if r.InitialInterval == 0 {
+		r.InitialInterval = time.Second
+	}
+	if r.MaxInterval == 0 {
+		r.MaxInterval = maxInterval
+	}
+	if r.Multiplier == 0 {
+		r.Multiplier = 2.0
+	}
+}
+
+// validate проверяет политику; section - путь настройки в конфигурации для сообщений об ошибках
+func (r RetryConfig) validate(section string) error {
+	if r.MaxAttempts < 1 {
+		return fmt.Errorf("%s.max_attempts must be positive", section)
+	}
+	if r.InitialInterval < 0 || r.MaxInterval < r.InitialInterval {
+		return fmt.Errorf("%s: max_interval must be >= initial_interval >= 0", section)
+	}
+	if r.Multiplier < 1 {
+		return fmt.Errorf("%s.multiplier must be >= 1", section)
+	}
+	return nil
+}
+
+// Policy возвращает политику повторных попыток для сервиса
+func (r RetryConfig) Policy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts:     r.MaxAttempts,
+		InitialInterval: r.InitialInterval,
+		MaxInterval:     r.MaxInterval,
+		Multiplier:      r.Multiplier,
+	}
+}
+
+// DLQConfig содержит настройки Dead Letter Queue
+type DLQConfig struct {
+	Enabled         bool          `yaml:"enabled"`
+	Topic           string        `yaml:"topic"`
+	RedriveInterval time.Duration `yaml:"redrive_interval"` // Как часто применять записи replication_dlq со status = 'redrive'
+}
+
+// ConsumerReplicationConfig содержит allowlist таблиц, в которые consumer применяет события
+type ConsumerReplicationConfig struct {
+	// UnknownColumns - что делать с колонками события, которых нет в таблице: reject (по умолчанию) или drop
+	UnknownColumns string                `yaml:"unknown_columns"`
+	Tables         []ConsumerTableConfig `yaml:"tables"`
+	Tombstones     TombstonesConfig      `yaml:"tombstones"`
+}
+
+// TombstonesConfig содержит настройки хранения ключей удаленных строк (replication_tombstones)
+type TombstonesConfig struct {
+	Retention       time.Duration `yaml:"retention"`        // Срок хранения, больше максимальной задержки репликации (по умолчанию 7 дней)
+	CleanupInterval time.Duration `yaml:"cleanup_interval"` // Как часто удалять устаревшие tombstones (по умолчанию 1h)
+}
+
+// ConsumerTableConfig содержит настройки применения одной таблицы
+type ConsumerTableConfig struct {
+	Name string `yaml:"name"` // schema.table (без схемы - public)
+
+	// Политика конфликтов таблицы (если не задана - processing.conflict_resolution)
+	ConflictResolution string `yaml:"conflict_resolution"` // last_write_wins (version), skip, error, timestamp, hlc, merge, custom
+	ConflictColumn     string `yaml:"conflict_column"`     // Колонка сравнения (по умолчанию version, updated_at или hlc)
+	TieBreak           string `yaml:"tie_break"`           // none или contour (по умолчанию contour для timestamp и hlc)
+	MergeFallback      string `yaml:"merge_fallback"`      // Для merge: стратегия конфликта по одной колонке (по умолчанию last_write_wins)
+
+	// SoftDeleteColumn - DELETE применяется как UPDATE этой колонки (например deleted_at), строка не удаляется
+	SoftDeleteColumn string `yaml:"soft_delete_column"`
+
+	// Resolver - встроенный resolver для custom (обязателен, если resolver таблицы не зарегистрирован в коде)
+	Resolver *ConsumerResolverConfig `yaml:"resolver"`
+}
+
+// ConsumerResolverConfig задает SQL resolver конфликтов: функцию или выражение над jsonb local, incoming и meta
+type ConsumerResolverConfig struct {
+	Function   string `yaml:"function"`   // [schema.]fn(local jsonb, incoming jsonb, meta jsonb) RETURNS jsonb
+	Expression string `yaml:"expression"` // SQL выражение, например CASE WHEN ... THEN incoming END
+}
+
+// ConflictResolutions возвращает таблицы с собственной политикой конфликтов
+func (r ConsumerReplicationConfig) ConflictResolutions() []ConsumerTableConfig {
+	tables := make([]ConsumerTableConfig, 0, len(r.Tables))
+	for _, table := range r.Tables {
+		if table.ConflictResolution != "" {
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// SoftDeleteColumns возвращает колонки мягкого удаления по имени таблицы schema.table
+func (r ConsumerReplicationConfig) SoftDeleteColumns() map[string]string {
+	columns := make(map[string]string)
+	for _, table := range r.Tables {
+		if table.SoftDeleteColumn != "" {
+			columns[qualifyTableName(table.Name)] = table.SoftDeleteColumn
+		}
+	}
+	return columns
+}
+
+// TableNames возвращает имена таблиц allowlist
+func (r ConsumerReplicationConfig) TableNames() []string {
+	names := make([]string, 0, len(r.Tables))
+	for _, table := range r.Tables {
+		names = append(names, table.Name)
+	}
+	return names
 }
 
 // LoadConsumer загружает конфигурацию Consumer из YAML файла
@@ -63,6 +218,9 @@ func LoadConsumer(configPath string) (*ConsumerConfig, error) {
 	// Переопределяем значениями из переменных окружения
 	cfg.overrideFromEnv()
 
+	// Значения по умолчанию
+	cfg.setDefaults()
+
 	// Валидация
 	if err := cfg.validate(); err != nil {
 		return nil, fmt.Errorf("config validation failed: %w", err)
@@ -110,6 +268,61 @@ func (c *ConsumerConfig) overrideFromEnv() {
 	}
 }
 
+// setDefaults задает значения по умолчанию для необязательных параметров
+func (c *ConsumerConfig) setDefaults() {
+	if c.Processing.BatchSize == 0 {
+		c.Processing.BatchSize = 1
+	}
+	if c.Processing.BatchMaxWait == 0 {
+		c.Processing.BatchMaxWait = 100 * time.Millisecond
+	}
+	if c.Processing.ApplyMode == "" {
+		c.Processing.ApplyMode = "select"
+	}
+	if c.Processing.EventTimeout == 0 {
+		c.Processing.EventTimeout = 30 * time.Second
+	}
+	if c.Processing.LockTimeout == 0 {
+		c.Processing.LockTimeout = c.Processing.EventTimeout
+	}
+	c.Processing.Retry.setDefaults(30 * time.Second)
+	if c.Processing.MaxWorkers == 0 {
+		c.Processing.MaxWorkers = 10
+	}
+	if c.Processing.PartitionQueueSize == 0 {
+		c.Processing.PartitionQueueSize = 100
+	}
+	if c.Processing.Dependencies.MaxPending == 0 {
+		c.Processing.Dependencies.MaxPending = 1000
+	}
+	if c.DLQ.RedriveInterval == 0 {
+		c.DLQ.RedriveInterval = time.Minute
+	}
+	if c.Replication.UnknownColumns == "" {
+		c.Replication.UnknownColumns = "reject"
+	}
+	if c.Replication.Tombstones.Retention == 0 {
+		c.Replication.Tombstones.Retention = 7 * 24 * time.Hour
+	}
+	if c.Replication.Tombstones.CleanupInterval == 0 {
+		c.Replication.Tombstones.CleanupInterval = time.Hour
+	}
+	c.Retention.setDefaults(30 * 24 * time.Hour)
+
+	// "version" - синоним last_write_wins (сравнение по колонке version)
+	if c.Processing.ConflictResolution == "version" {
+		c.Processing.ConflictResolution = "last_write_wins"
+	}
+	for i := range c.Replication.Tables {
+		if c.Replication.Tables[i].ConflictResolution == "version" {
+			c.Replication.Tables[i].ConflictResolution = "last_write_wins"
+		}
+		if c.Replication.Tables[i].MergeFallback == "version" {
+			c.Replication.Tables[i].MergeFallback = "last_write_wins"
+		}
+	}
+}
+
 // validate проверяет корректность конфигурации
 func (c *ConsumerConfig) validate() error {
 	// Service validation
@@ -141,8 +354,16 @@ func (c *ConsumerConfig) validate() error {
 	if c.Kafka.ConsumerGroup == "" {
 		return fmt.Errorf("kafka.consumer_group is required")
 	}
-	if len(c.Kafka.Topics) == 0 {
-		return fmt.Errorf("kafka.topics is required")
+	if len(c.Kafka.Topics) == 0 && c.Kafka.TopicPattern == "" {
+		return fmt.Errorf("kafka.topics or kafka.topic_pattern is required")
+	}
+	if c.Kafka.TopicPattern != "" {
+		if !strings.HasPrefix(c.Kafka.TopicPattern, "^") {
+			return fmt.Errorf("kafka.topic_pattern must start with ^")
+		}
+		if _, err := regexp.Compile(c.Kafka.TopicPattern); err != nil {
+			return fmt.Errorf("invalid kafka.topic_pattern: %w", err)
+		}
 	}
 
 	// Processing validation
@@ -150,11 +371,129 @@ func (c *ConsumerConfig) validate() error {
 		"last_write_wins": true,
 		"skip":            true,
 		"error":           true,
+		"timestamp":       true,
+		"hlc":             true,
 	}
 	if !validStrategies[c.Processing.ConflictResolution] {
 		return fmt.Errorf("invalid processing.conflict_resolution: %s", c.Processing.ConflictResolution)
 	}
 
+	if c.Processing.ApplyMode != "select" && c.Processing.ApplyMode != "upsert" {
+		return fmt.Errorf("invalid processing.apply_mode: %s", c.Processing.ApplyMode)
+	}
+
+	if c.Processing.BatchSize < 1 {
+		return fmt.Errorf("processing.batch_size must be positive")
+	}
+	if c.Processing.BatchMaxWait < 0 {
+		return fmt.Errorf("processing.batch_max_wait must not be negative")
+	}
+
+	if c.Processing.EventTimeout < 0 {
+		return fmt.Errorf("processing.event_timeout must not be negative")
+	}
+	if c.Processing.LockTimeout < 0 || c.Processing.LockTimeout > c.Processing.EventTimeout {
+		return fmt.Errorf("processing.lock_timeout must be between 0 and processing.event_timeout")
+	}
+
+	if err := c.Processing.Retry.validate("processing.retry"); err != nil {
+		return err
+	}
+
+	if c.Processing.MaxWorkers < 1 {
+		return fmt.Errorf("processing.max_workers must be positive")
+	}
+	if c.Processing.PartitionQueueSize < 1 {
+		return fmt.Errorf("processing.partition_queue_size must be positive")
+	}
+	if c.Processing.TransactionTimeout < 0 {
+		return fmt.Errorf("processing.transaction_timeout must not be negative")
+	}
+	if c.Processing.Dependencies.Timeout < 0 {
+		return fmt.Errorf("processing.dependencies.timeout must not be negative")
+	}
+	if c.Processing.Dependencies.MaxPending < 1 {
+		return fmt.Errorf("processing.dependencies.max_pending must be positive")
+	}
+
+	// DLQ validation
+	if c.DLQ.Enabled && c.DLQ.Topic == "" {
+		return fmt.Errorf("dlq.topic is required when dlq is enabled")
+	}
+
+	// Replication validation
+	if len(c.Replication.Tables) == 0 {
+		return fmt.Errorf("replication.tables is required (allowlist of replicated tables)")
+	}
+	if c.Replication.UnknownColumns != "reject" && c.Replication.UnknownColumns != "drop" {
+		return fmt.Errorf("invalid replication.unknown_columns: %s", c.Replication.UnknownColumns)
+	}
+	if c.Replication.Tombstones.Retention < 0 || c.Replication.Tombstones.CleanupInterval < 0 {
+		return fmt.Errorf("replication.tombstones: retention and cleanup_interval must not be negative")
+	}
+
+	// Служебные таблицы репликации не должны изменяться событиями
+	serviceTables := map[string]bool{
+		"replication_queue":        true,
+		"processed_events":         true,
+		"replication_dlq":          true,
+		"replication_conflicts":    true,
+		"replication_tombstones":   true,
+		"replication_shard_leases": true,
+		"replication_queue_failed": true,
+	}
+	seenTables := make(map[string]bool, len(c.Replication.Tables))
+	for _, table := range c.Replication.Tables {
+		if table.Name == "" {
+			return fmt.Errorf("replication.tables: name is required")
+		}
+		name := qualifyTableName(table.Name)
+		if serviceTables[name[strings.Index(name, ".")+1:]] {
+			return fmt.Errorf("replication.tables: service table %s cannot be replicated", table.Name)
+		}
+		if seenTables[name] {
+			return fmt.Errorf("replication.tables: duplicate table %s", table.Name)
+		}
+		seenTables[name] = true
+
+		switch table.ConflictResolution {
+		case "", "merge", "custom":
+		default:
+			if !validStrategies[table.ConflictResolution] {
+				return fmt.Errorf("replication.tables[%s]: invalid conflict_resolution: %s", table.Name, table.ConflictResolution)
+			}
+		}
+		if table.ConflictResolution == "custom" && table.Resolver == nil {
+			return fmt.Errorf("replication.tables[%s]: conflict_resolution custom requires resolver", table.Name)
+		}
+		if table.Resolver != nil {
+			if table.ConflictResolution != "custom" {
+				return fmt.Errorf("replication.tables[%s]: resolver requires conflict_resolution: custom", table.Name)
+			}
+			if (table.Resolver.Function == "") == (table.Resolver.Expression == "") {
+				return fmt.Errorf("replication.tables[%s]: resolver requires exactly one of function or expression", table.Name)
+			}
+		}
+		if table.MergeFallback != "" {
+			if table.ConflictResolution != "merge" {
+				return fmt.Errorf("replication.tables[%s]: merge_fallback requires conflict_resolution: merge", table.Name)
+			}
+			if !validStrategies[table.MergeFallback] {
+				return fmt.Errorf("replication.tables[%s]: invalid merge_fallback: %s", table.Name, table.MergeFallback)
+			}
+		}
+		if table.TieBreak != "" && table.TieBreak != "none" && table.TieBreak != "contour" {
+			return fmt.Errorf("replication.tables[%s]: invalid tie_break: %s", table.Name, table.TieBreak)
+		}
+		if (table.ConflictColumn != "" || table.TieBreak != "") && table.ConflictResolution == "" {
+			return fmt.Errorf("replication.tables[%s]: conflict_resolution is required with conflict_column or tie_break", table.Name)
+		}
+	}
+
+	if err := c.Retention.validate(); err != nil {
+		return err
+	}
+
 	// Logging validation
 	validLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
 	if !validLevels[c.Logging.Level] {
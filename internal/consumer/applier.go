@@ -6,47 +6,117 @@ import (
 
 	"github.com/rs/zerolog"
 	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
 )
 
 // EventApplier применяет события к БД
 type EventApplier struct {
-	db     *gorm.DB
-	config Config
-	logger zerolog.Logger
+	db      *gorm.DB
+	config  Config
+	logger  zerolog.Logger
+	catalog *tableCatalog
+
+	defaultPolicy ConflictPolicy
+	policies      map[string]ConflictPolicy // schema.table -> политика конфликтов
+	softDeletes   map[string]string         // schema.table -> колонка мягкого удаления
 }
 
 // NewEventApplier создает новый EventApplier
 func NewEventApplier(db *gorm.DB, cfg Config, logger zerolog.Logger) *EventApplier {
+	policies := make(map[string]ConflictPolicy, len(cfg.TableConflicts))
+	for name, policy := range cfg.TableConflicts {
+		policies[qualifyTableName(name)] = policy.normalize()
+	}
+
+	softDeletes := make(map[string]string, len(cfg.SoftDeletes))
+	for name, column := range cfg.SoftDeletes {
+		softDeletes[qualifyTableName(name)] = column
+	}
+
 	return &EventApplier{
-		db:     db,
-		config: cfg,
-		logger: logger.With().Str("component", "applier").Logger(),
+		db:            db,
+		config:        cfg,
+		logger:        logger.With().Str("component", "applier").Logger(),
+		catalog:       newTableCatalog(cfg.Tables),
+		defaultPolicy: ConflictPolicy{Strategy: cfg.ConflictResolution}.normalize(),
+		policies:      policies,
+		softDeletes:   softDeletes,
 	}
 }
 
-// Apply применяет событие к БД
+// Apply применяет событие к БД.
+// Таблица и колонки события предварительно проверяются по allowlist и каталогу БД.
 func (a *EventApplier) Apply(tx *gorm.DB, event ReplicationEvent) error {
+	table, err := a.catalog.Lookup(tx, event.GetSchema(), event.Table)
+	if err != nil {
+		return err
+	}
+
+	event, dropped, err := table.sanitize(event, a.config.UnknownColumns)
+	if err != nil {
+		// Колонки могли появиться миграцией после загрузки каталога - перечитаем его при следующей попытке
+		a.catalog.Invalidate(table.Schema, table.Name)
+		return err
+	}
+	if len(dropped) > 0 {
+		a.logger.Warn().
+			Str("event_id", event.EventID).
+			Str("table", event.Table).
+			Strs("dropped_columns", dropped).
+			Msg("Unknown columns dropped from event")
+	}
+
+	// Колонка сравнения политики конфликтов должна существовать
+	policy := a.policyFor(table)
+	if _, ok := table.Columns[policy.Column]; !ok {
+		return fmt.Errorf("conflict column %s (strategy %s) does not exist in %s.%s",
+			policy.Column, policy.Strategy, table.Schema, table.Name)
+	}
+
+	// Приводим значения к типам колонок (bigint, numeric, bytea, массивы, jsonb и т.д.)
+	event, err = table.coerceEvent(event)
+	if err != nil {
+		return fmt.Errorf("failed to coerce event values: %w", err)
+	}
+
+	// Мягкое удаление: DELETE помечает строку, а не удаляет ее
+	if event.Operation == "DELETE" {
+		if column, ok := a.softDeleteColumn(table); ok {
+			return a.applySoftDelete(tx, table, event, column)
+		}
+	}
+
+	if a.config.ApplyMode == ApplyModeUpsert {
+		switch event.Operation {
+		case "INSERT", "UPDATE":
+			return a.applyUpsert(tx, table, event)
+		case "DELETE":
+			return a.applyGuardedDelete(tx, table, event)
+		}
+	}
+
 	switch event.Operation {
 	case "INSERT":
-		return a.applyInsert(tx, event)
+		return a.applyInsert(tx, table, event)
 	case "UPDATE":
-		return a.applyUpdate(tx, event)
+		return a.applyUpdate(tx, table, event)
 	case "DELETE":
-		return a.applyDelete(tx, event)
+		return a.applyDelete(tx, table, event)
 	default:
 		return fmt.Errorf("unknown operation: %s", event.Operation)
 	}
 }
 
 // applyInsert применяет INSERT (или UPDATE если запись уже существует)
-func (a *EventApplier) applyInsert(tx *gorm.DB, event ReplicationEvent) error {
+func (a *EventApplier) applyInsert(tx *gorm.DB, table *tableInfo, event ReplicationEvent) error {
 	if event.After == nil {
 		return fmt.Errorf("INSERT event must have 'after' data")
 	}
 
 	tableName := event.Table
 	data := event.After
-	primaryKeyValue := event.GetPrimaryKeyValue()
+	primaryKeyValue := event.PrimaryKey
 	incomingVersion := event.GetVersion()
 
 	a.logger.Debug().
@@ -56,34 +126,35 @@ func (a *EventApplier) applyInsert(tx *gorm.DB, event ReplicationEvent) error {
 		Msg("Applying INSERT")
 
 	// Проверяем существование записи
-	var existingVersion int64
-	result := tx.Table(tableName).
-		Select("version").
-		Where("id = ?", primaryKeyValue).
-		Scan(&existingVersion)
-
-	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
-		return fmt.Errorf("failed to check existing record: %w", result.Error)
+	existing, exists, err := a.fetchExisting(tx, table, event, a.policyFor(table))
+	if err != nil {
+		return err
 	}
 
 	// Запись уже существует - конфликт
-	if result.Error == nil {
+	if exists {
 		a.logger.Warn().
 			Str("table", tableName).
 			Interface("primary_key", primaryKeyValue).
-			Int64("existing_version", existingVersion).
+			Int64("existing_version", existing.Version).
 			Int64("incoming_version", incomingVersion).
 			Msg("INSERT conflict: record already exists")
 
 		// Применяем conflict resolution
-		return a.resolveConflict(tx, tableName, primaryKeyValue, existingVersion, incomingVersion, data)
+		return a.resolveConflict(tx, table, event, existing, incomingVersion, data)
+	}
+
+	// Ключ удален более новой версией - не воскрешаем строку
+	suppressed, err := a.suppressedByTombstone(tx, table, event)
+	if err != nil || suppressed {
+		return err
 	}
 
 	// Запись не существует - делаем INSERT
 	columns, values := a.buildInsertSQL(data)
 	
 	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		tableName,
+		table.QuotedName,
 		strings.Join(columns, ", "),
 		strings.Join(makePlaceholders(len(values)), ", "),
 	)
@@ -101,14 +172,14 @@ func (a *EventApplier) applyInsert(tx *gorm.DB, event ReplicationEvent) error {
 }
 
 // applyUpdate применяет UPDATE с проверкой версии
-func (a *EventApplier) applyUpdate(tx *gorm.DB, event ReplicationEvent) error {
+func (a *EventApplier) applyUpdate(tx *gorm.DB, table *tableInfo, event ReplicationEvent) error {
 	if event.After == nil {
 		return fmt.Errorf("UPDATE event must have 'after' data")
 	}
 
 	tableName := event.Table
 	data := event.After
-	primaryKeyValue := event.GetPrimaryKeyValue()
+	primaryKeyValue := event.PrimaryKey
 	incomingVersion := event.GetVersion()
 
 	a.logger.Debug().
@@ -117,38 +188,49 @@ func (a *EventApplier) applyUpdate(tx *gorm.DB, event ReplicationEvent) error {
 		Int64("version", incomingVersion).
 		Msg("Applying UPDATE")
 
-	// Проверяем существование и версию
-	var existingVersion int64
-	result := tx.Table(tableName).
-		Select("version").
-		Where("id = ?", primaryKeyValue).
-		Scan(&existingVersion)
+	// Проверяем существование и сравниваем с входящим изменением
+	policy := a.policyFor(table)
+	existing, exists, err := a.fetchExisting(tx, table, event, policy)
+	if err != nil {
+		return err
+	}
 
-	if result.Error == gorm.ErrRecordNotFound {
+	if !exists {
 		// Запись не существует - делаем INSERT (может быть INSERT пришел позже)
 		a.logger.Warn().
 			Str("table", tableName).
 			Interface("primary_key", primaryKeyValue).
 			Msg("UPDATE on non-existing record, converting to INSERT")
-		return a.applyInsert(tx, event)
+		return a.applyInsert(tx, table, event)
 	}
 
-	if result.Error != nil {
-		return fmt.Errorf("failed to check existing record: %w", result.Error)
+	// merge: применяем только колонки, измененные на контуре-источнике
+	if policy.Strategy == ConflictMerge && event.Before != nil {
+		return a.applyMerge(tx, table, event, policy, existing)
 	}
 
 	// Проверка версии (conflict resolution)
-	if existingVersion >= incomingVersion {
-		return a.handleVersionConflict(tableName, primaryKeyValue, existingVersion, incomingVersion)
+	if !policy.incomingWins(existing, event.Source.Contour, a.config.MyContour) {
+		return a.handleVersionConflict(tx, table, event, existing.Version, incomingVersion)
+	}
+
+	// Входящее изменение победило по времени (HLC) или ничьей, хотя локальная строка
+	// изменялась независимо - это тоже конфликт, фиксируем вытесняемую строку
+	if existing.Version >= incomingVersion || existing.Cmp == 0 {
+		if err := a.recordConflict(tx, table, event, database.ConflictResolutionIncomingApplied, existing.Version, incomingVersion); err != nil {
+			return err
+		}
 	}
 
 	// Применяем UPDATE
-	setClauses, values := a.buildUpdateSQL(data)
-	values = append(values, primaryKeyValue) // Добавляем ID для WHERE
+	setClauses, values := a.buildUpdateSQL(event, data)
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+	values = append(values, whereValues...) // Добавляем ключ для WHERE
 
-	sql := fmt.Sprintf("UPDATE %s SET %s WHERE id = ?",
-		tableName,
+	sql := fmt.Sprintf("UPDATE %s SET %s WHERE %s",
+		table.QuotedName,
 		strings.Join(setClauses, ", "),
+		whereClause,
 	)
 
 	if err := tx.Exec(sql, values...).Error; err != nil {
@@ -158,48 +240,55 @@ func (a *EventApplier) applyUpdate(tx *gorm.DB, event ReplicationEvent) error {
 	a.logger.Debug().
 		Str("table", tableName).
 		Interface("primary_key", primaryKeyValue).
-		Int64("old_version", existingVersion).
+		Int64("old_version", existing.Version).
 		Int64("new_version", incomingVersion).
 		Msg("UPDATE applied")
 
 	return nil
 }
 
-// applyDelete применяет DELETE
-func (a *EventApplier) applyDelete(tx *gorm.DB, event ReplicationEvent) error {
+// applyDelete применяет DELETE с проверкой версии по политике конфликтов таблицы
+func (a *EventApplier) applyDelete(tx *gorm.DB, table *tableInfo, event ReplicationEvent) error {
 	tableName := event.Table
-	primaryKeyValue := event.GetPrimaryKeyValue()
+	primaryKeyValue := event.PrimaryKey
 
 	a.logger.Debug().
 		Str("table", tableName).
 		Interface("primary_key", primaryKeyValue).
 		Msg("Applying DELETE")
 
-	// Проверяем существование
-	var existingVersion int64
-	result := tx.Table(tableName).
-		Select("version").
-		Where("id = ?", primaryKeyValue).
-		Scan(&existingVersion)
+	// Проверяем существование и сравниваем с удаляемым образом строки
+	policy := a.policyFor(table)
+	existing, exists, err := a.fetchExisting(tx, table, event, policy)
+	if err != nil {
+		return err
+	}
 
-	if result.Error == gorm.ErrRecordNotFound {
+	if !exists {
 		// Запись уже удалена - это нормально (идемпотентность)
 		a.logger.Debug().
 			Str("table", tableName).
 			Interface("primary_key", primaryKeyValue).
 			Msg("DELETE on non-existing record (already deleted)")
-		return nil
+		// INSERT мог еще не дойти - tombstone не даст ему создать строку
+		return a.recordTombstone(tx, table, event)
 	}
 
-	if result.Error != nil {
-		return fmt.Errorf("failed to check existing record: %w", result.Error)
+	// Строка изменена после удаляемой версии - конфликт по политике таблицы.
+	// События без колонки сравнения в образе строки удаляют без проверки.
+	if _, ok := event.Before[policy.Column]; ok && existing.Cmp > 0 {
+		return a.handleVersionConflict(tx, table, event, existing.Version, event.GetVersion())
 	}
 
 	// Удаляем запись
-	sql := fmt.Sprintf("DELETE FROM %s WHERE id = ?", tableName)
-	if err := tx.Exec(sql, primaryKeyValue).Error; err != nil {
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+	sql := fmt.Sprintf("DELETE FROM %s WHERE %s", table.QuotedName, whereClause)
+	if err := tx.Exec(sql, whereValues...).Error; err != nil {
 		return fmt.Errorf("failed to delete: %w", err)
 	}
+	if err := a.recordTombstone(tx, table, event); err != nil {
+		return err
+	}
 
 	a.logger.Debug().
 		Str("table", tableName).
@@ -210,86 +299,150 @@ func (a *EventApplier) applyDelete(tx *gorm.DB, event ReplicationEvent) error {
 }
 
 // resolveConflict разрешает конфликт при INSERT на существующую запись
-func (a *EventApplier) resolveConflict(tx *gorm.DB, tableName string, primaryKey interface{}, existingVersion, incomingVersion int64, data map[string]interface{}) error {
-	switch a.config.ConflictResolution {
-	case "last_write_wins":
-		if incomingVersion > existingVersion {
-			// Incoming версия новее - делаем UPDATE
+func (a *EventApplier) resolveConflict(tx *gorm.DB, table *tableInfo, event ReplicationEvent, existing existingRow, incomingVersion int64, data map[string]interface{}) error {
+	tableName := event.Table
+	primaryKey := event.PrimaryKey
+	policy := a.policyFor(table).effective()
+
+	switch policy.Strategy {
+	case ConflictLastWriteWins, ConflictTimestamp, ConflictHLC:
+		if policy.incomingWins(existing, event.Source.Contour, a.config.MyContour) {
+			// Incoming изменение новее (или выиграло ничью) - делаем UPDATE
 			a.logger.Info().
 				Str("table", tableName).
 				Interface("primary_key", primaryKey).
-				Int64("existing_version", existingVersion).
+				Str("strategy", policy.Strategy).
+				Int64("existing_version", existing.Version).
 				Int64("incoming_version", incomingVersion).
+				Int("compare", existing.Cmp).
 				Msg("Conflict resolved: updating with newer version")
 
-			setClauses, values := a.buildUpdateSQL(data)
-			values = append(values, primaryKey)
+			if err := a.recordConflict(tx, table, event, database.ConflictResolutionIncomingApplied, existing.Version, incomingVersion); err != nil {
+				return err
+			}
+
+			setClauses, values := a.buildUpdateSQL(event, data)
+			whereClause, whereValues := buildPrimaryKeyWhere(event)
+			values = append(values, whereValues...)
 
-			sql := fmt.Sprintf("UPDATE %s SET %s WHERE id = ?", tableName, strings.Join(setClauses, ", "))
+			sql := fmt.Sprintf("UPDATE %s SET %s WHERE %s", table.QuotedName, strings.Join(setClauses, ", "), whereClause)
 			return tx.Exec(sql, values...).Error
 		}
 		
-		// Existing версия новее или равна - пропускаем
+		// Существующая строка новее или равна - пропускаем
 		a.logger.Info().
 			Str("table", tableName).
 			Interface("primary_key", primaryKey).
-			Int64("existing_version", existingVersion).
+			Str("strategy", policy.Strategy).
+			Int64("existing_version", existing.Version).
 			Int64("incoming_version", incomingVersion).
+			Int("compare", existing.Cmp).
 			Msg("Conflict resolved: skipping older version")
-		return nil
+		return a.recordConflict(tx, table, event, database.ConflictResolutionExistingKept, existing.Version, incomingVersion)
 
-	case "skip":
+	case ConflictSkip:
 		// Просто пропускаем
 		a.logger.Info().
 			Str("table", tableName).
 			Interface("primary_key", primaryKey).
 			Msg("Conflict resolved: skipping (policy=skip)")
-		return nil
+		return a.recordConflict(tx, table, event, database.ConflictResolutionExistingKept, existing.Version, incomingVersion)
 
-	case "error":
-		// Возвращаем ошибку
+	case ConflictError:
+		// Фиксируем конфликт и возвращаем ошибку
+		if err := a.recordConflict(tx, table, event, database.ConflictResolutionRejected, existing.Version, incomingVersion); err != nil {
+			a.logger.Warn().Err(err).Str("event_id", event.EventID).Msg("Failed to record rejected conflict")
+		}
 		return fmt.Errorf("conflict: record already exists (policy=error)")
 
+	case ConflictCustom:
+		return a.resolveCustom(tx, table, event, existing.Version, incomingVersion)
+
 	default:
-		return fmt.Errorf("unknown conflict resolution strategy: %s", a.config.ConflictResolution)
+		return fmt.Errorf("unknown conflict resolution strategy: %s", policy.Strategy)
 	}
 }
 
-// handleVersionConflict обрабатывает конфликт версий при UPDATE
-func (a *EventApplier) handleVersionConflict(tableName string, primaryKey interface{}, existingVersion, incomingVersion int64) error {
-	switch a.config.ConflictResolution {
-	case "last_write_wins":
+// handleVersionConflict обрабатывает устаревшее изменение (существующая строка новее или равна)
+func (a *EventApplier) handleVersionConflict(tx *gorm.DB, table *tableInfo, event ReplicationEvent, existingVersion, incomingVersion int64) error {
+	tableName := event.Table
+	primaryKey := event.PrimaryKey
+	policy := a.policyFor(table).effective()
+
+	switch policy.Strategy {
+	case ConflictLastWriteWins, ConflictTimestamp, ConflictHLC:
 		// Existing версия новее - пропускаем
 		a.logger.Info().
 			Str("table", tableName).
 			Interface("primary_key", primaryKey).
+			Str("strategy", policy.Strategy).
+			Str("compare_column", policy.Column).
 			Int64("existing_version", existingVersion).
 			Int64("incoming_version", incomingVersion).
 			Msg("Version conflict: skipping older version")
-		return nil
+		return a.recordConflict(tx, table, event, database.ConflictResolutionExistingKept, existingVersion, incomingVersion)
 
-	case "skip":
+	case ConflictSkip:
 		a.logger.Info().
 			Str("table", tableName).
 			Interface("primary_key", primaryKey).
 			Msg("Version conflict: skipping (policy=skip)")
-		return nil
+		return a.recordConflict(tx, table, event, database.ConflictResolutionExistingKept, existingVersion, incomingVersion)
+
+	case ConflictError:
+		if err := a.recordConflict(tx, table, event, database.ConflictResolutionRejected, existingVersion, incomingVersion); err != nil {
+			a.logger.Warn().Err(err).Str("event_id", event.EventID).Msg("Failed to record rejected conflict")
+		}
+		return fmt.Errorf("version conflict on %s: existing=%d, incoming=%d (policy=error)", policy.Column, existingVersion, incomingVersion)
 
-	case "error":
-		return fmt.Errorf("version conflict: existing=%d >= incoming=%d (policy=error)", existingVersion, incomingVersion)
+	case ConflictCustom:
+		return a.resolveCustom(tx, table, event, existingVersion, incomingVersion)
 
 	default:
-		return fmt.Errorf("unknown conflict resolution strategy: %s", a.config.ConflictResolution)
+		return fmt.Errorf("unknown conflict resolution strategy: %s", policy.Strategy)
 	}
 }
 
+// fetchExistingVersion читает версию существующей записи по primary key события
+func (a *EventApplier) fetchExistingVersion(tx *gorm.DB, table *tableInfo, event ReplicationEvent) (int64, bool, error) {
+	if len(event.PrimaryKey) == 0 {
+		return 0, false, fmt.Errorf("event has no primary key")
+	}
+
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+
+	var existingVersion int64
+	sql := fmt.Sprintf("SELECT version FROM %s WHERE %s LIMIT 1", table.QuotedName, whereClause)
+	result := tx.Raw(sql, whereValues...).Scan(&existingVersion)
+
+	if result.Error != nil {
+		return 0, false, fmt.Errorf("failed to check existing record: %w", result.Error)
+	}
+
+	return existingVersion, result.RowsAffected > 0, nil
+}
+
+// buildPrimaryKeyWhere строит WHERE по всем колонкам primary key события (колонки проверены по каталогу)
+func buildPrimaryKeyWhere(event ReplicationEvent) (string, []interface{}) {
+	columns := event.GetPrimaryKeyColumns()
+	conditions := make([]string, 0, len(columns))
+	values := make([]interface{}, 0, len(columns))
+
+	for _, column := range columns {
+		conditions = append(conditions, quoteIdentifier(column)+" = ?")
+		values = append(values, event.PrimaryKey[column])
+	}
+
+	return strings.Join(conditions, " AND "), values
+}
+
 // buildInsertSQL строит списки колонок и значений для INSERT
 func (a *EventApplier) buildInsertSQL(data map[string]interface{}) ([]string, []interface{}) {
 	columns := make([]string, 0, len(data))
 	values := make([]interface{}, 0, len(data))
 
 	for key, value := range data {
-		columns = append(columns, key)
+		columns = append(columns, quoteIdentifier(key))
 		values = append(values, value)
 	}
 
@@ -297,16 +450,16 @@ func (a *EventApplier) buildInsertSQL(data map[string]interface{}) ([]string, []
 }
 
 // buildUpdateSQL строит SET clause и значения для UPDATE
-func (a *EventApplier) buildUpdateSQL(data map[string]interface{}) ([]string, []interface{}) {
+func (a *EventApplier) buildUpdateSQL(event ReplicationEvent, data map[string]interface{}) ([]string, []interface{}) {
 	setClauses := make([]string, 0, len(data))
 	values := make([]interface{}, 0, len(data))
 
 	for key, value := range data {
-		// Пропускаем id (не обновляем primary key)
-		if key == "id" {
+		// Не обновляем колонки primary key, если их значение не изменилось
+		if keyValue, isKey := event.PrimaryKey[key]; isKey && fmt.Sprint(keyValue) == fmt.Sprint(value) {
 			continue
 		}
-		setClauses = append(setClauses, fmt.Sprintf("%s = ?", key))
+		setClauses = append(setClauses, quoteIdentifier(key)+" = ?")
 		values = append(values, value)
 	}
 
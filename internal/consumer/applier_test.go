@@ -0,0 +1,30 @@
+package consumer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildPrimaryKeyWhere(t *testing.T) {
+	event := ReplicationEvent{
+		PrimaryKeyColumns: []string{"order_id", "item_id"},
+		PrimaryKey:        map[string]interface{}{"item_id": 3, "order_id": 10},
+	}
+
+	where, values := buildPrimaryKeyWhere(event)
+	if where != `"order_id" = ? AND "item_id" = ?` {
+		t.Errorf("where = %q", where)
+	}
+	if !reflect.DeepEqual(values, []interface{}{10, 3}) {
+		t.Errorf("values = %v", values)
+	}
+}
+
+func TestGetPrimaryKeyColumnsLegacyEvent(t *testing.T) {
+	// Событие без primary_key_columns (старый publisher): порядок по имени колонки
+	event := ReplicationEvent{PrimaryKey: map[string]interface{}{"b": 1, "a": 2, "c": 3}}
+
+	if got := event.GetPrimaryKeyColumns(); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("got %v", got)
+	}
+}
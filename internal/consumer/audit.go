@@ -0,0 +1,93 @@
+package consumer
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// recordConflict сохраняет разрешенный конфликт в replication_conflicts вместе с локальной строкой
+// и входящим образом. Вызывается до изменения строки, чтобы сохранить ее исходное состояние.
+// Отклоненный конфликт (policy=error) пишется вне транзакции события - она будет откачена.
+func (a *EventApplier) recordConflict(tx *gorm.DB, table *tableInfo, event ReplicationEvent, resolution string, existingVersion, incomingVersion int64) error {
+	existingRow, err := a.loadRowImage(tx, table, event)
+	if err != nil {
+		return err
+	}
+
+	conflict := newConflictRecord(table, event, a.policyFor(table).Strategy, resolution, existingRow, existingVersion, incomingVersion)
+
+	db := tx
+	if resolution == database.ConflictResolutionRejected {
+		db = a.db.WithContext(tx.Statement.Context)
+	}
+
+	// Повторное применение того же события (retry, redrive, откат батча) не дублирует запись,
+	// а заменяет ее последним результатом: отклоненный конфликт, примененный позже, не остается rejected
+	result := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "event_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"strategy", "resolution", "winning_version", "losing_version",
+			"existing_row", "incoming_row", "source_contour",
+		}),
+	}).Create(&conflict)
+	if result.Error != nil {
+		return fmt.Errorf("failed to insert into replication_conflicts: %w", result.Error)
+	}
+
+	return nil
+}
+
+// newConflictRecord формирует запись replication_conflicts.
+// Для DELETE входящим образом считается удаляемая строка (before).
+func newConflictRecord(table *tableInfo, event ReplicationEvent, strategy, resolution string, existingRow database.JSONB, existingVersion, incomingVersion int64) database.ReplicationConflict {
+	incomingRow := event.After
+	if event.Operation == "DELETE" {
+		incomingRow = event.Before
+	}
+
+	conflict := database.ReplicationConflict{
+		EventID:       event.EventID,
+		Schema:        table.Schema,
+		Table:         table.Name,
+		Operation:     event.Operation,
+		PrimaryKey:    database.JSONB(event.PrimaryKey),
+		Strategy:      strategy,
+		Resolution:    resolution,
+		ExistingRow:   existingRow,
+		IncomingRow:   database.JSONB(incomingRow),
+		SourceContour: event.Source.Contour,
+	}
+	switch {
+	case resolution == database.ConflictResolutionIncomingApplied:
+		conflict.WinningVersion, conflict.LosingVersion = incomingVersion, existingVersion
+	case resolution == database.ConflictResolutionMerged && incomingVersion > existingVersion:
+		// Сведенная строка получает большую из версий
+		conflict.WinningVersion, conflict.LosingVersion = incomingVersion, existingVersion
+	default:
+		conflict.WinningVersion, conflict.LosingVersion = existingVersion, incomingVersion
+	}
+
+	return conflict
+}
+
+// loadRowImage читает текущую локальную строку по primary key события в виде JSON
+func (a *EventApplier) loadRowImage(tx *gorm.DB, table *tableInfo, event ReplicationEvent) (database.JSONB, error) {
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+
+	var rows []struct {
+		Row database.JSONB `gorm:"column:row"`
+	}
+	sql := fmt.Sprintf("SELECT to_jsonb(t) AS row FROM %s AS t WHERE %s LIMIT 1", table.QuotedName, whereClause)
+	if err := tx.Raw(sql, whereValues...).Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to load existing row image: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	return rows[0].Row, nil
+}
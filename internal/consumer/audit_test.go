@@ -0,0 +1,60 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+func TestNewConflictRecordVersions(t *testing.T) {
+	table := newTestTable("id", "version")
+	event := ReplicationEvent{
+		EventID:    "e1",
+		Operation:  "UPDATE",
+		Table:      "users",
+		PrimaryKey: map[string]interface{}{"id": 1},
+		After:      map[string]interface{}{"id": 1, "version": 3},
+		Source:     SourceInfo{Contour: "B"},
+	}
+	existing := database.JSONB{"id": 1, "version": 5}
+
+	applied := newConflictRecord(table, event, ConflictHLC, database.ConflictResolutionIncomingApplied, existing, 5, 3)
+	if applied.WinningVersion != 3 || applied.LosingVersion != 5 {
+		t.Errorf("incoming_applied: winning=%d losing=%d", applied.WinningVersion, applied.LosingVersion)
+	}
+	if applied.Schema != "public" || applied.Table != "users" || applied.SourceContour != "B" || applied.Strategy != ConflictHLC {
+		t.Errorf("unexpected conflict record: %+v", applied)
+	}
+	if applied.ExistingRow["version"] != 5 || applied.IncomingRow["version"] != 3 {
+		t.Errorf("row images: existing=%v incoming=%v", applied.ExistingRow, applied.IncomingRow)
+	}
+
+	kept := newConflictRecord(table, event, ConflictHLC, database.ConflictResolutionExistingKept, existing, 5, 3)
+	if kept.WinningVersion != 5 || kept.LosingVersion != 3 {
+		t.Errorf("existing_kept: winning=%d losing=%d", kept.WinningVersion, kept.LosingVersion)
+	}
+
+	// Сведенная строка получает большую из версий
+	merged := newConflictRecord(table, event, ConflictHLC, database.ConflictResolutionMerged, existing, 2, 3)
+	if merged.WinningVersion != 3 || merged.LosingVersion != 2 {
+		t.Errorf("merged: winning=%d losing=%d", merged.WinningVersion, merged.LosingVersion)
+	}
+}
+
+func TestNewConflictRecordDeleteUsesBeforeImage(t *testing.T) {
+	event := ReplicationEvent{
+		EventID:    "e2",
+		Operation:  "DELETE",
+		Table:      "users",
+		PrimaryKey: map[string]interface{}{"id": 1},
+		Before:     map[string]interface{}{"id": 1, "version": 2},
+	}
+
+	conflict := newConflictRecord(newTestTable("id", "version"), event, ConflictLastWriteWins, database.ConflictResolutionRejected, nil, 4, 2)
+	if conflict.IncomingRow["version"] != 2 {
+		t.Errorf("expected before image as incoming row, got %v", conflict.IncomingRow)
+	}
+	if conflict.ExistingRow != nil {
+		t.Errorf("expected no existing row, got %v", conflict.ExistingRow)
+	}
+}
@@ -0,0 +1,282 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// handleBatch применяет сообщения одной партиции (в порядке offsets) одной транзакцией.
+// Если транзакция батча не удалась, сообщения применяются по одному, чтобы изолировать сбойное событие.
+// Возвращает число сообщений с начала батча, offsets которых можно коммитить.
+func (c *Consumer) handleBatch(ctx context.Context, messages []*kafka.Message) int {
+	if len(messages) == 1 {
+		return c.handleEach(ctx, messages)
+	}
+
+	events := make([]ReplicationEvent, 0, len(messages))
+	parsed := make([]*ReplicationEvent, len(messages)) // События по индексам сообщений (nil - пропущено)
+	skipped := 0
+	grouped := false
+	for i, message := range messages {
+		var event ReplicationEvent
+		if err := event.FromJSON(message.Value); err != nil {
+			// Битое сообщение разбираем по одному (в т.ч. отправляем в DLQ)
+			return c.handleEach(ctx, messages)
+		}
+
+		// Фильтрация: пропускаем события от своего контура
+		if !c.shouldProcess(event) {
+			skipped++
+			continue
+		}
+
+		if c.transactions.grouped(event) {
+			grouped = true
+		}
+		events = append(events, event)
+		parsed[i] = &event
+	}
+
+	// События исходных транзакций собираются по всем партициям - применяем по одному
+	if grouped {
+		return c.handleGrouped(ctx, messages, parsed)
+	}
+
+	// Потомок без родительской строки ждет ее в буфере - применяем по одному
+	waits, err := c.pending.batchWaitsForParents(ctx, events)
+	if err != nil && ctx.Err() == nil {
+		c.logger.Warn().
+			Err(err).
+			Int("batch_size", len(messages)).
+			Msg("Failed to check parent rows of batch, falling back to per-event apply")
+	}
+	if waits || err != nil {
+		return c.handleEach(ctx, messages)
+	}
+
+	if len(events) > 0 {
+		if err := c.applyEvents(ctx, events); err != nil {
+			if ctx.Err() != nil {
+				// Воркер остановлен - сообщения перечитает новый владелец партиции
+				c.logMessageError(messages[0], err)
+				return 0
+			}
+
+			if isTimeout(err) {
+				c.reportTimeout(ctx, err, batchTables(events)...)
+			}
+
+			c.logger.Warn().
+				Err(err).
+				Int("batch_size", len(messages)).
+				Int64("first_offset", int64(messages[0].TopicPartition.Offset)).
+				Msg("Failed to apply batch, falling back to per-event apply")
+			return c.handleEach(ctx, messages)
+		}
+	}
+
+	atomic.AddInt64(&c.skippedCount, int64(skipped))
+	processed := atomic.AddInt64(&c.processedCount, int64(len(events)))
+	c.logger.Info().
+		Int("events", len(events)).
+		Int("skipped", skipped).
+		Int64("first_offset", int64(messages[0].TopicPartition.Offset)).
+		Int64("last_offset", int64(messages[len(messages)-1].TopicPartition.Offset)).
+		Int64("total_processed", processed).
+		Msg("Batch applied successfully")
+
+	return len(messages)
+}
+
+// handleEach применяет сообщения по одному, пока очередное не окажется некоммитируемым.
+// Возвращает число обработанных сообщений с начала списка.
+func (c *Consumer) handleEach(ctx context.Context, messages []*kafka.Message) int {
+	for i, message := range messages {
+		committable, err := c.handleMessage(ctx, message)
+		if err != nil {
+			c.logMessageError(message, err)
+		}
+		if !committable {
+			return i
+		}
+	}
+	return len(messages)
+}
+
+// handleGrouped применяет сообщения батча по одному в порядке offsets. Дойдя до события
+// исходной транзакции, воркер добавляет в нее и более поздние события той же транзакции из батча:
+// иначе он ждал бы их до таймаута. Событие не добавляется раньше времени, если перед ним
+// стоит еще не примененное событие с тем же ключом (порядок изменений ключа в партиции).
+func (c *Consumer) handleGrouped(ctx context.Context, messages []*kafka.Message, events []*ReplicationEvent) int {
+	for i, message := range messages {
+		if event := events[i]; event != nil && c.transactions.grouped(*event) {
+			c.transactions.register(transactionFollowers(messages, events, i))
+		}
+
+		committable, err := c.handleMessage(ctx, message)
+		if err != nil {
+			c.logMessageError(message, err)
+		}
+		if !committable {
+			return i
+		}
+	}
+	return len(messages)
+}
+
+// transactionFollowers возвращает событие i и более поздние события его транзакции в батче,
+// перед которыми нет событий с тем же ключом сообщения, не входящих в транзакцию
+func transactionFollowers(messages []*kafka.Message, events []*ReplicationEvent, i int) []ReplicationEvent {
+	key := transactionKey(*events[i])
+	followers := []ReplicationEvent{*events[i]}
+	blocked := make(map[string]bool)
+
+	for j := i + 1; j < len(messages); j++ {
+		event := events[j]
+		if event == nil {
+			continue
+		}
+
+		messageKey := string(messages[j].Key)
+		sameTx := event.Transaction != nil && event.Transaction.Count > 1 && transactionKey(*event) == key
+		if sameTx && !blocked[messageKey] {
+			followers = append(followers, *event)
+			continue
+		}
+		blocked[messageKey] = true
+	}
+
+	return followers
+}
+
+// batchTables возвращает таблицы (schema.table), затронутые событиями батча
+func batchTables(events []ReplicationEvent) []string {
+	seen := make(map[string]bool)
+	var tables []string
+	for _, event := range events {
+		table := event.GetSchema() + "." + event.Table
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// logMessageError логирует ошибку обработки сообщения и учитывает ее в метриках
+func (c *Consumer) logMessageError(message *kafka.Message, err error) {
+	topic := ""
+	if message.TopicPartition.Topic != nil {
+		topic = *message.TopicPartition.Topic
+	}
+
+	c.logger.Error().
+		Err(err).
+		Str("topic", topic).
+		Int32("partition", message.TopicPartition.Partition).
+		Int64("offset", int64(message.TopicPartition.Offset)).
+		Msg("Failed to process message")
+	atomic.AddInt64(&c.failedCount, 1)
+}
+
+// applyEvents применяет события одной транзакцией с общей проверкой идемпотентности
+func (c *Consumer) applyEvents(ctx context.Context, events []ReplicationEvent) error {
+	// Начинаем транзакцию (ограничена event_timeout)
+	tx, cancel, err := c.beginTx(ctx)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			c.logger.Error().Interface("panic", r).Msg("Panic in applyEvents")
+		}
+	}()
+
+	// 1. Проверяем идемпотентность всех событий батча одним запросом
+	eventIDs := make([]string, len(events))
+	for i, event := range events {
+		eventIDs[i] = event.EventID
+	}
+
+	var processedIDs []string
+	result := tx.Model(&database.ProcessedEvent{}).
+		Where("event_id IN ?", eventIDs).
+		Pluck("event_id", &processedIDs)
+	if result.Error != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to check processed_events: %w", result.Error)
+	}
+
+	// 2. Откладываем проверку FK constraints до конца батча
+	if err := tx.Exec("SET CONSTRAINTS ALL DEFERRED").Error; err != nil {
+		tx.Rollback()
+		return fmt.Errorf("failed to set constraints deferred: %w", err)
+	}
+
+	// 3. Применяем DML операции в порядке offsets
+	unprocessed := unprocessedEvents(events, processedIDs)
+	if skipped := len(events) - len(unprocessed); skipped > 0 {
+		c.logger.Debug().
+			Int("skipped", skipped).
+			Msg("Events already processed (idempotent skip)")
+	}
+
+	now := time.Now()
+	processedEvents := make([]database.ProcessedEvent, 0, len(unprocessed))
+	for _, event := range unprocessed {
+		if err := c.applier.Apply(tx, event); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply DML for event %s: %w", event.EventID, err)
+		}
+
+		processedEvents = append(processedEvents, database.ProcessedEvent{
+			EventID:     event.EventID,
+			ProcessedAt: now,
+		})
+	}
+
+	// 4. Записываем примененные события в processed_events одним INSERT
+	if len(processedEvents) > 0 {
+		if err := tx.Create(&processedEvents).Error; err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to insert into processed_events: %w", err)
+		}
+	}
+
+	// 5. Коммитим транзакцию (здесь проверяются FK constraints)
+	if err := tx.Commit().Error; err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Строки таблиц появились - будим ожидающие их события
+	c.pending.release(events...)
+
+	return nil
+}
+
+// unprocessedEvents возвращает события батча в исходном порядке без уже обработанных
+// (processedIDs) и без повторов event_id внутри батча
+func unprocessedEvents(events []ReplicationEvent, processedIDs []string) []ReplicationEvent {
+	seen := make(map[string]bool, len(events)+len(processedIDs))
+	for _, eventID := range processedIDs {
+		seen[eventID] = true
+	}
+
+	unprocessed := make([]ReplicationEvent, 0, len(events))
+	for _, event := range events {
+		if seen[event.EventID] {
+			continue
+		}
+		seen[event.EventID] = true
+		unprocessed = append(unprocessed, event)
+	}
+	return unprocessed
+}
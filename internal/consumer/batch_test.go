@@ -0,0 +1,103 @@
+package consumer
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+func TestUnprocessedEvents(t *testing.T) {
+	events := []ReplicationEvent{
+		{EventID: "a"},
+		{EventID: "b"},
+		{EventID: "a"}, // Повтор внутри батча (переотправка publisher)
+		{EventID: "c"},
+		{EventID: "d"},
+	}
+
+	got := unprocessedEvents(events, []string{"c"})
+
+	ids := make([]string, len(got))
+	for i, event := range got {
+		ids[i] = event.EventID
+	}
+	if want := []string{"a", "b", "d"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("got %v, want %v", ids, want)
+	}
+}
+
+func TestUnprocessedEventsAllProcessed(t *testing.T) {
+	events := []ReplicationEvent{{EventID: "a"}, {EventID: "b"}}
+	if got := unprocessedEvents(events, []string{"b", "a"}); len(got) != 0 {
+		t.Errorf("got %d events, want none", len(got))
+	}
+}
+
+func TestTransactionFollowers(t *testing.T) {
+	tx := func(id int64, index int) *ReplicationEvent {
+		return &ReplicationEvent{
+			EventID:     "tx",
+			Transaction: &TransactionInfo{ID: id, Index: index, Count: 2},
+		}
+	}
+	plain := &ReplicationEvent{EventID: "plain"}
+
+	tests := []struct {
+		name   string
+		keys   []string
+		events []*ReplicationEvent
+		want   []int // Индексы Transaction.Index ожидаемых событий
+	}{
+		{
+			name:   "following event of the same transaction",
+			keys:   []string{"a", "b"},
+			events: []*ReplicationEvent{tx(1, 1), tx(1, 2)},
+			want:   []int{1, 2},
+		},
+		{
+			name:   "other key in between does not block",
+			keys:   []string{"a", "c", "b"},
+			events: []*ReplicationEvent{tx(1, 1), plain, tx(1, 2)},
+			want:   []int{1, 2},
+		},
+		{
+			name:   "same key in between blocks",
+			keys:   []string{"a", "b", "b"},
+			events: []*ReplicationEvent{tx(1, 1), plain, tx(1, 2)},
+			want:   []int{1},
+		},
+		{
+			name:   "other transaction with the same key blocks",
+			keys:   []string{"a", "b", "b"},
+			events: []*ReplicationEvent{tx(1, 1), tx(2, 1), tx(1, 2)},
+			want:   []int{1},
+		},
+		{
+			name:   "skipped message does not block",
+			keys:   []string{"a", "b", "b"},
+			events: []*ReplicationEvent{tx(1, 1), nil, tx(1, 2)},
+			want:   []int{1, 2},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messages := make([]*kafka.Message, len(tt.keys))
+			for i, key := range tt.keys {
+				messages[i] = &kafka.Message{Key: []byte(key)}
+			}
+
+			got := transactionFollowers(messages, tt.events, 0)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d events, want %d", len(got), len(tt.want))
+			}
+			for i, event := range got {
+				if event.Transaction.ID != 1 || event.Transaction.Index != tt.want[i] {
+					t.Errorf("event %d: got tx %d index %d, want tx 1 index %d",
+						i, event.Transaction.ID, event.Transaction.Index, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package consumer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// Значения событий приходят из row_to_json: числа - json.Number, bytea - "\x..." (или base64),
+// массивы - JSON массивы, jsonb - вложенные объекты, остальные типы - строки в текстовом формате PostgreSQL.
+// Перед подстановкой в SQL они приводятся к типам колонок: целые и float - к int64/float64,
+// bytea - к []byte, остальные - к текстовому представлению PostgreSQL (pgx передает строки в text формате,
+// сервер сам разбирает numeric, uuid, timestamptz, interval, диапазоны и литералы массивов).
+
+// coerceRow приводит значения строки к типам колонок таблицы
+func (t *tableInfo) coerceRow(data map[string]interface{}) (map[string]interface{}, error) {
+	if data == nil {
+		return nil, nil
+	}
+
+	result := make(map[string]interface{}, len(data))
+	for name, value := range data {
+		column, ok := t.Columns[name]
+		if !ok {
+			return nil, fmt.Errorf("column %s does not exist in %s.%s", name, t.Schema, t.Name)
+		}
+
+		coerced, err := coerceValue(column, value)
+		if err != nil {
+			return nil, fmt.Errorf("column %s.%s.%s (%s): %w", t.Schema, t.Name, name, column.UDTName, err)
+		}
+		result[name] = coerced
+	}
+
+	return result, nil
+}
+
+// coerceEvent приводит к типам колонок primary key и образы строки события
+func (t *tableInfo) coerceEvent(event ReplicationEvent) (ReplicationEvent, error) {
+	var err error
+	if event.PrimaryKey, err = t.coerceRow(event.PrimaryKey); err != nil {
+		return event, fmt.Errorf("primary key: %w", err)
+	}
+	if event.Before, err = t.coerceRow(event.Before); err != nil {
+		return event, fmt.Errorf("before: %w", err)
+	}
+	if event.After, err = t.coerceRow(event.After); err != nil {
+		return event, fmt.Errorf("after: %w", err)
+	}
+	return event, nil
+}
+
+// coerceValue приводит значение к типу колонки
+func coerceValue(column database.Column, value interface{}) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	// Массивы: udt_name элемента - без ведущего "_"
+	if column.DataType == "ARRAY" || strings.HasPrefix(column.UDTName, "_") {
+		return coerceArray(strings.TrimPrefix(column.UDTName, "_"), value)
+	}
+
+	return coerceScalar(column.UDTName, value)
+}
+
+// coerceScalar приводит скалярное значение к типу udtName
+func coerceScalar(udtName string, value interface{}) (interface{}, error) {
+	switch udtName {
+	case "int2", "int4", "int8", "oid":
+		return toInt64(value)
+
+	case "float4", "float8":
+		return toFloat64(value)
+
+	case "bool":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			return strconv.ParseBool(v)
+		}
+		return nil, fmt.Errorf("expected boolean, got %T", value)
+
+	case "bytea":
+		return toBytes(value)
+
+	case "json", "jsonb":
+		// Вложенный JSON сериализуем обратно (json.Number сохраняет исходную запись чисел)
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		return string(encoded), nil
+
+	case "interval":
+		// Число трактуем как секунды, строки (в т.ч. ISO 8601 "P1DT2H") разбирает сервер
+		if number, ok := value.(json.Number); ok {
+			return number.String() + " seconds", nil
+		}
+		return toText(value)
+	}
+
+	if isRangeType(udtName) {
+		if bounds, ok := value.(map[string]interface{}); ok {
+			return rangeLiteral(bounds)
+		}
+	}
+
+	// numeric, uuid, даты и время, диапазоны, enum и прочие типы - в текстовом формате
+	return toText(value)
+}
+
+// coerceArray строит литерал массива PostgreSQL ({...}) с приведением элементов к elemType
+func coerceArray(elemType string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		// Уже литерал массива
+		return v, nil
+	case []interface{}:
+		literal, err := arrayLiteral(elemType, v)
+		if err != nil {
+			return nil, err
+		}
+		return literal, nil
+	}
+	return nil, fmt.Errorf("expected array, got %T", value)
+}
+
+// arrayLiteral рекурсивно строит литерал массива (поддерживаются многомерные массивы)
+func arrayLiteral(elemType string, elements []interface{}) (string, error) {
+	parts := make([]string, 0, len(elements))
+	for i, element := range elements {
+		if element == nil {
+			parts = append(parts, "NULL")
+			continue
+		}
+
+		if nested, ok := element.([]interface{}); ok {
+			literal, err := arrayLiteral(elemType, nested)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, literal)
+			continue
+		}
+
+		coerced, err := coerceScalar(elemType, element)
+		if err != nil {
+			return "", fmt.Errorf("element %d: %w", i, err)
+		}
+
+		var text string
+		switch c := coerced.(type) {
+		case []byte:
+			text = `\x` + hex.EncodeToString(c)
+		case bool:
+			text = "f"
+			if c {
+				text = "t"
+			}
+		default:
+			text = fmt.Sprint(c)
+		}
+		parts = append(parts, quoteArrayElement(text))
+	}
+
+	return "{" + strings.Join(parts, ",") + "}", nil
+}
+
+// quoteArrayElement экранирует элемент литерала массива
+func quoteArrayElement(text string) string {
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(text)
+	return `"` + escaped + `"`
+}
+
+// isRangeType определяет диапазонные типы (в т.ч. multirange)
+func isRangeType(udtName string) bool {
+	return strings.HasSuffix(udtName, "range")
+}
+
+// rangeLiteral строит литерал диапазона из объекта {lower, upper, lower_inc, upper_inc, empty}
+func rangeLiteral(bounds map[string]interface{}) (string, error) {
+	if empty, _ := bounds["empty"].(bool); empty {
+		return "empty", nil
+	}
+
+	bound := func(key string) (string, error) {
+		value, ok := bounds[key]
+		if !ok || value == nil {
+			return "", nil // Бесконечная граница
+		}
+		text, err := toText(value)
+		if err != nil {
+			return "", fmt.Errorf("range %s: %w", key, err)
+		}
+		return quoteArrayElement(text.(string)), nil
+	}
+
+	lower, err := bound("lower")
+	if err != nil {
+		return "", err
+	}
+	upper, err := bound("upper")
+	if err != nil {
+		return "", err
+	}
+
+	// По умолчанию - канонический вид [lower, upper)
+	lowerBracket, upperBracket := "[", ")"
+	if inc, ok := bounds["lower_inc"].(bool); ok && !inc {
+		lowerBracket = "("
+	}
+	if inc, ok := bounds["upper_inc"].(bool); ok && inc {
+		upperBracket = "]"
+	}
+
+	return lowerBracket + lower + "," + upper + upperBracket, nil
+}
+
+// toInt64 приводит значение к int64 без потери точности
+func toInt64(value interface{}) (int64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return strconv.ParseInt(v.String(), 10, 64)
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case int64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case float64:
+		if v != float64(int64(v)) {
+			return 0, fmt.Errorf("expected integer, got %v", v)
+		}
+		return int64(v), nil
+	}
+	return 0, fmt.Errorf("expected integer, got %T", value)
+}
+
+// toFloat64 приводит значение к float64 (строки "NaN", "Infinity" допустимы)
+func toFloat64(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.Float64()
+	case string:
+		return strconv.ParseFloat(v, 64)
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	}
+	return 0, fmt.Errorf("expected number, got %T", value)
+}
+
+// toBytes декодирует bytea: hex формат PostgreSQL ("\x0102") или base64
+func toBytes(value interface{}) ([]byte, error) {
+	switch v := value.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		if strings.HasPrefix(v, `\x`) {
+			decoded, err := hex.DecodeString(v[2:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid hex bytea: %w", err)
+			}
+			return decoded, nil
+		}
+		decoded, err := base64.StdEncoding.DecodeString(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 bytea: %w", err)
+		}
+		return decoded, nil
+	}
+	return nil, fmt.Errorf("expected bytea string, got %T", value)
+}
+
+// toText возвращает текстовое представление скалярного значения
+func toText(value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case map[string]interface{}, []interface{}:
+		return nil, fmt.Errorf("expected scalar, got %T", value)
+	}
+	return fmt.Sprint(value), nil
+}
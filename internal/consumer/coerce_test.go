@@ -0,0 +1,139 @@
+package consumer
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+func TestCoerceValue(t *testing.T) {
+	tests := []struct {
+		name     string
+		column   database.Column
+		value    interface{}
+		want     interface{}
+		wantErr  bool
+		checkNaN bool
+	}{
+		// Числа
+		{name: "int8 keeps precision", column: database.Column{UDTName: "int8"}, value: json.Number("9007199254740993"), want: int64(9007199254740993)},
+		{name: "int4 from string", column: database.Column{UDTName: "int4"}, value: "42", want: int64(42)},
+		{name: "int rejects fraction", column: database.Column{UDTName: "int4"}, value: json.Number("1.5"), wantErr: true},
+		{name: "float8", column: database.Column{UDTName: "float8"}, value: json.Number("1.25"), want: 1.25},
+		{name: "float8 NaN", column: database.Column{UDTName: "float8"}, value: "NaN", checkNaN: true},
+		{name: "numeric keeps trailing zeros", column: database.Column{UDTName: "numeric"}, value: json.Number("10.50"), want: "10.50"},
+		{name: "bool from string", column: database.Column{UDTName: "bool"}, value: "true", want: true},
+
+		// bytea
+		{name: "bytea hex", column: database.Column{UDTName: "bytea"}, value: `\x0aff`, want: []byte{0x0a, 0xff}},
+		{name: "bytea base64", column: database.Column{UDTName: "bytea"}, value: "Cv8=", want: []byte{0x0a, 0xff}},
+		{name: "bytea invalid hex", column: database.Column{UDTName: "bytea"}, value: `\xzz`, wantErr: true},
+
+		// interval
+		{name: "interval number as seconds", column: database.Column{UDTName: "interval"}, value: json.Number("90"), want: "90 seconds"},
+		{name: "interval ISO 8601", column: database.Column{UDTName: "interval"}, value: "P1DT2H", want: "P1DT2H"},
+
+		// Диапазоны
+		{
+			name:   "range default bounds",
+			column: database.Column{UDTName: "int4range"},
+			value:  map[string]interface{}{"lower": json.Number("1"), "upper": json.Number("10")},
+			want:   `["1","10")`,
+		},
+		{
+			name:   "range explicit bounds",
+			column: database.Column{UDTName: "tstzrange"},
+			value: map[string]interface{}{
+				"lower": "2024-01-01 00:00:00+00", "upper": "2024-02-01 00:00:00+00",
+				"lower_inc": false, "upper_inc": true,
+			},
+			want: `("2024-01-01 00:00:00+00","2024-02-01 00:00:00+00"]`,
+		},
+		{
+			name:   "range unbounded upper",
+			column: database.Column{UDTName: "numrange"},
+			value:  map[string]interface{}{"lower": json.Number("1.5"), "upper": nil},
+			want:   `["1.5",)`,
+		},
+		{name: "range empty", column: database.Column{UDTName: "int4range"}, value: map[string]interface{}{"empty": true}, want: "empty"},
+		{name: "range literal", column: database.Column{UDTName: "int4range"}, value: "[1,10)", want: "[1,10)"},
+
+		// Массивы
+		{
+			name:   "int array with null",
+			column: database.Column{DataType: "ARRAY", UDTName: "_int4"},
+			value:  []interface{}{json.Number("1"), nil, json.Number("3")},
+			want:   `{"1",NULL,"3"}`,
+		},
+		{
+			name:   "nested array",
+			column: database.Column{DataType: "ARRAY", UDTName: "_int8"},
+			value:  []interface{}{[]interface{}{json.Number("1"), json.Number("2")}, []interface{}{json.Number("3"), json.Number("4")}},
+			want:   `{{"1","2"},{"3","4"}}`,
+		},
+		{
+			name:   "text array escaping",
+			column: database.Column{DataType: "ARRAY", UDTName: "_text"},
+			value:  []interface{}{`a"b`, `c\d`, "e,f"},
+			want:   `{"a\"b","c\\d","e,f"}`,
+		},
+		{
+			name:   "bool array",
+			column: database.Column{DataType: "ARRAY", UDTName: "_bool"},
+			value:  []interface{}{true, false},
+			want:   `{"t","f"}`,
+		},
+		{
+			name:   "bytea array",
+			column: database.Column{DataType: "ARRAY", UDTName: "_bytea"},
+			value:  []interface{}{`\x01`},
+			want:   `{"\\x01"}`,
+		},
+		{name: "array literal", column: database.Column{DataType: "ARRAY", UDTName: "_int4"}, value: "{1,2}", want: "{1,2}"},
+		{name: "array element error", column: database.Column{DataType: "ARRAY", UDTName: "_int4"}, value: []interface{}{"x"}, wantErr: true},
+
+		// json
+		{
+			name:   "jsonb keeps number text",
+			column: database.Column{UDTName: "jsonb"},
+			value:  map[string]interface{}{"amount": json.Number("1.50")},
+			want:   `{"amount":1.50}`,
+		},
+
+		{name: "null", column: database.Column{UDTName: "int4"}, value: nil, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := coerceValue(tt.column, tt.value)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %#v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tt.checkNaN {
+				if f, ok := got.(float64); !ok || !math.IsNaN(f) {
+					t.Errorf("got %#v, want NaN", got)
+				}
+				return
+			}
+			if want, ok := tt.want.([]byte); ok {
+				if !bytes.Equal(got.([]byte), want) {
+					t.Errorf("got %#v, want %#v", got, want)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
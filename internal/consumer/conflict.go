@@ -0,0 +1,167 @@
+package consumer
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// Стратегии разрешения конфликтов
+const (
+	ConflictLastWriteWins = "last_write_wins" // Побеждает большая version
+	ConflictSkip          = "skip"            // Существующая строка не меняется при INSERT-конфликте и устаревшем UPDATE
+	ConflictError         = "error"           // Конфликт - ошибка применения
+	ConflictTimestamp     = "timestamp"       // Побеждает больший updated_at
+	ConflictHLC           = "hlc"             // Побеждает больший HLC, проставленный триггером stamp_hlc()
+	ConflictMerge         = "merge"           // Применяются только измененные колонки, конфликт по колонке - по Fallback
+)
+
+// Разрешение ничьей (значения колонки сравнения равны)
+const (
+	TieBreakNone    = "none"    // Ничья - входящее изменение не применяется
+	TieBreakContour = "contour" // Побеждает контур с большим именем - оба контура сходятся к одной строке
+)
+
+// ConflictPolicy описывает, как входящее изменение сравнивается с существующей строкой.
+// last_write_wins, skip и error - пресеты со сравнением по version без разрешения ничьей.
+type ConflictPolicy struct {
+	Strategy string
+	Column   string // Колонка сравнения (по умолчанию version, updated_at или hlc - по стратегии)
+	TieBreak string // none или contour (по умолчанию contour для timestamp и hlc)
+	Fallback string // Для merge: стратегия для конфликтов по одной колонке и INSERT/DELETE (по умолчанию last_write_wins)
+}
+
+// normalize заполняет колонку сравнения и разрешение ничьей по умолчанию
+func (p ConflictPolicy) normalize() ConflictPolicy {
+	if p.Strategy == "" {
+		p.Strategy = ConflictLastWriteWins
+	}
+	if p.Strategy == ConflictMerge && p.Fallback == "" {
+		p.Fallback = ConflictLastWriteWins
+	}
+
+	// Для merge колонка сравнения и ничья определяются стратегией Fallback
+	strategy := p.Strategy
+	if strategy == ConflictMerge {
+		strategy = p.Fallback
+	}
+
+	if p.Column == "" {
+		switch strategy {
+		case ConflictTimestamp:
+			p.Column = "updated_at"
+		case ConflictHLC:
+			p.Column = "hlc"
+		default:
+			p.Column = "version"
+		}
+	}
+
+	if p.TieBreak == "" {
+		switch strategy {
+		case ConflictTimestamp, ConflictHLC:
+			p.TieBreak = TieBreakContour
+		default:
+			p.TieBreak = TieBreakNone
+		}
+	}
+
+	return p
+}
+
+// effective возвращает политику, по которой сравниваются строки целиком:
+// для merge - стратегию Fallback с той же колонкой сравнения, для остальных - саму политику
+func (p ConflictPolicy) effective() ConflictPolicy {
+	if p.Strategy != ConflictMerge {
+		return p
+	}
+	return ConflictPolicy{Strategy: p.Fallback, Column: p.Column, TieBreak: p.TieBreak}
+}
+
+// comparesRows определяет, решается ли INSERT-конфликт сравнением строк (а не политикой skip/error)
+func (p ConflictPolicy) comparesRows() bool {
+	switch p.Strategy {
+	case ConflictLastWriteWins, ConflictTimestamp, ConflictHLC:
+		return true
+	}
+	return false
+}
+
+// winsTie определяет, побеждает ли входящее изменение при равенстве колонки сравнения.
+// Существующая строка считается изменением своего контура.
+func (p ConflictPolicy) winsTie(incomingContour, myContour string) bool {
+	return p.TieBreak == TieBreakContour && incomingContour > myContour
+}
+
+// incomingWins определяет, применять ли входящее изменение поверх существующей строки
+func (p ConflictPolicy) incomingWins(existing existingRow, incomingContour, myContour string) bool {
+	if existing.Cmp < 0 {
+		return true
+	}
+	return existing.Cmp == 0 && p.winsTie(incomingContour, myContour)
+}
+
+// incomingValue возвращает значение колонки сравнения входящего изменения
+func (p ConflictPolicy) incomingValue(event ReplicationEvent) interface{} {
+	if event.Operation == "DELETE" {
+		return event.Before[p.Column]
+	}
+	return event.After[p.Column]
+}
+
+// upsertCondition строит условие DO UPDATE ... WHERE для INSERT ... ON CONFLICT
+func (p ConflictPolicy) upsertCondition(incomingContour, myContour string) string {
+	column := quoteIdentifier(p.Column)
+	condition := fmt.Sprintf("target.%s < EXCLUDED.%s OR target.%s IS NULL", column, column, column)
+	if p.winsTie(incomingContour, myContour) {
+		condition += fmt.Sprintf(" OR target.%s IS NOT DISTINCT FROM EXCLUDED.%s", column, column)
+	}
+	return condition
+}
+
+// existingRow - версия существующей строки и результат ее сравнения с входящим изменением
+type existingRow struct {
+	Version int64 `gorm:"column:version"`
+	Cmp     int   `gorm:"column:cmp"` // -1 - существующая строка старее, 0 - равны, 1 - новее
+}
+
+// policyFor возвращает политику конфликтов таблицы
+func (a *EventApplier) policyFor(table *tableInfo) ConflictPolicy {
+	if policy, ok := a.policies[table.Schema+"."+table.Name]; ok {
+		return policy
+	}
+	return a.defaultPolicy
+}
+
+// fetchExisting читает существующую строку по primary key события и сравнивает ее
+// с входящим изменением по колонке политики (сравнение выполняет БД - для любых типов колонки)
+func (a *EventApplier) fetchExisting(tx *gorm.DB, table *tableInfo, event ReplicationEvent, policy ConflictPolicy) (existingRow, bool, error) {
+	if len(event.PrimaryKey) == 0 {
+		return existingRow{}, false, fmt.Errorf("event has no primary key")
+	}
+
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+	incoming := policy.incomingValue(event)
+	column := quoteIdentifier(policy.Column)
+
+	// NULL в существующей строке проигрывает любому значению, NULL во входящем - проигрывает строке
+	sql := fmt.Sprintf(`SELECT version,
+		CASE WHEN %s IS NOT DISTINCT FROM ? THEN 0
+		     WHEN %s IS NULL OR %s < ? THEN -1
+		     ELSE 1 END AS cmp
+		FROM %s WHERE %s LIMIT 1`,
+		column, column, column, table.QuotedName, whereClause)
+
+	values := append([]interface{}{incoming, incoming}, whereValues...)
+
+	var rows []existingRow
+	result := tx.Raw(sql, values...).Scan(&rows)
+	if result.Error != nil {
+		return existingRow{}, false, fmt.Errorf("failed to check existing record: %w", result.Error)
+	}
+	if len(rows) == 0 {
+		return existingRow{}, false, nil
+	}
+
+	return rows[0], true, nil
+}
@@ -0,0 +1,164 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestConflictPolicyNormalize(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy ConflictPolicy
+		want   ConflictPolicy
+	}{
+		{
+			name:   "default is last write wins by version",
+			policy: ConflictPolicy{},
+			want:   ConflictPolicy{Strategy: ConflictLastWriteWins, Column: "version", TieBreak: TieBreakNone},
+		},
+		{
+			name:   "timestamp",
+			policy: ConflictPolicy{Strategy: ConflictTimestamp},
+			want:   ConflictPolicy{Strategy: ConflictTimestamp, Column: "updated_at", TieBreak: TieBreakContour},
+		},
+		{
+			name:   "hlc",
+			policy: ConflictPolicy{Strategy: ConflictHLC},
+			want:   ConflictPolicy{Strategy: ConflictHLC, Column: "hlc", TieBreak: TieBreakContour},
+		},
+		{
+			name:   "explicit column and tie break kept",
+			policy: ConflictPolicy{Strategy: ConflictHLC, Column: "clock", TieBreak: TieBreakNone},
+			want:   ConflictPolicy{Strategy: ConflictHLC, Column: "clock", TieBreak: TieBreakNone},
+		},
+		{
+			name:   "merge takes defaults from fallback",
+			policy: ConflictPolicy{Strategy: ConflictMerge, Fallback: ConflictHLC},
+			want:   ConflictPolicy{Strategy: ConflictMerge, Column: "hlc", TieBreak: TieBreakContour, Fallback: ConflictHLC},
+		},
+		{
+			name:   "merge default fallback",
+			policy: ConflictPolicy{Strategy: ConflictMerge},
+			want:   ConflictPolicy{Strategy: ConflictMerge, Column: "version", TieBreak: TieBreakNone, Fallback: ConflictLastWriteWins},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.normalize(); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConflictPolicyIncomingWins(t *testing.T) {
+	hlc := ConflictPolicy{Strategy: ConflictHLC}.normalize()
+	lww := ConflictPolicy{Strategy: ConflictLastWriteWins}.normalize()
+
+	tests := []struct {
+		name     string
+		policy   ConflictPolicy
+		cmp      int // Результат сравнения существующей строки с входящим изменением
+		incoming string
+		my       string
+		want     bool
+	}{
+		{name: "hlc newer incoming wins", policy: hlc, cmp: -1, incoming: "a", my: "b", want: true},
+		{name: "hlc older incoming loses", policy: hlc, cmp: 1, incoming: "b", my: "a", want: false},
+		{name: "hlc tie won by greater contour", policy: hlc, cmp: 0, incoming: "b", my: "a", want: true},
+		{name: "hlc tie lost by smaller contour", policy: hlc, cmp: 0, incoming: "a", my: "b", want: false},
+		{name: "hlc tie with same contour", policy: hlc, cmp: 0, incoming: "a", my: "a", want: false},
+		{name: "version newer incoming wins", policy: lww, cmp: -1, incoming: "a", my: "b", want: true},
+		{name: "version tie without tie break", policy: lww, cmp: 0, incoming: "b", my: "a", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.policy.incomingWins(existingRow{Cmp: tt.cmp}, tt.incoming, tt.my)
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			// Ничья разрешается одинаково для существующей строки и upsert
+			if tt.cmp == 0 && tt.policy.winsTie(tt.incoming, tt.my) != tt.want {
+				t.Errorf("winsTie disagrees with incomingWins")
+			}
+		})
+	}
+}
+
+func TestConflictPolicyUpsertCondition(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   ConflictPolicy
+		incoming string
+		my       string
+		want     string
+	}{
+		{
+			name:     "version",
+			policy:   ConflictPolicy{Strategy: ConflictLastWriteWins}.normalize(),
+			incoming: "b",
+			my:       "a",
+			want:     `target."version" < EXCLUDED."version" OR target."version" IS NULL`,
+		},
+		{
+			name:     "hlc tie won",
+			policy:   ConflictPolicy{Strategy: ConflictHLC}.normalize(),
+			incoming: "b",
+			my:       "a",
+			want: `target."hlc" < EXCLUDED."hlc" OR target."hlc" IS NULL` +
+				` OR target."hlc" IS NOT DISTINCT FROM EXCLUDED."hlc"`,
+		},
+		{
+			name:     "hlc tie lost",
+			policy:   ConflictPolicy{Strategy: ConflictHLC}.normalize(),
+			incoming: "a",
+			my:       "b",
+			want:     `target."hlc" < EXCLUDED."hlc" OR target."hlc" IS NULL`,
+		},
+		{
+			name:     "quoted column",
+			policy:   ConflictPolicy{Strategy: ConflictTimestamp, Column: `odd"name`, TieBreak: TieBreakNone},
+			incoming: "b",
+			my:       "a",
+			want:     `target."odd""name" < EXCLUDED."odd""name" OR target."odd""name" IS NULL`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.upsertCondition(tt.incoming, tt.my); got != tt.want {
+				t.Errorf("got %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEventApplierPolicyForTable(t *testing.T) {
+	applier := NewEventApplier(nil, Config{
+		ConflictResolution: ConflictSkip,
+		TableConflicts: map[string]ConflictPolicy{
+			"users":            {Strategy: ConflictHLC},
+			"billing.invoices": {Strategy: ConflictTimestamp, TieBreak: TieBreakNone},
+		},
+	}, zerolog.Nop())
+
+	users := applier.policyFor(&tableInfo{Schema: "public", Name: "users"})
+	if users.Strategy != ConflictHLC || users.Column != "hlc" {
+		t.Errorf("public.users policy = %+v", users)
+	}
+
+	invoices := applier.policyFor(&tableInfo{Schema: "billing", Name: "invoices"})
+	if invoices.Column != "updated_at" || invoices.TieBreak != TieBreakNone {
+		t.Errorf("billing.invoices policy = %+v", invoices)
+	}
+
+	// Таблица без своей политики и одноименная таблица другой схемы получают политику по умолчанию
+	for _, table := range []*tableInfo{{Schema: "public", Name: "orders"}, {Schema: "audit", Name: "users"}} {
+		if got := applier.policyFor(table); got.Strategy != ConflictSkip || got.Column != "version" {
+			t.Errorf("%s.%s policy = %+v, want default", table.Schema, table.Name, got)
+		}
+	}
+}
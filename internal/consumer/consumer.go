@@ -2,15 +2,18 @@ package consumer
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"sync/atomic"
 	"time"
 
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/rs/zerolog"
 	"gorm.io/gorm"
 
 	"github.com/vahtykov/go-replicator-service/internal/database"
 	kafkapkg "github.com/vahtykov/go-replicator-service/internal/kafka"
+	"github.com/vahtykov/go-replicator-service/internal/retry"
 )
 
 // Consumer читает события из Kafka и применяет к БД
@@ -20,31 +23,84 @@ type Consumer struct {
 	config       Config
 	logger       zerolog.Logger
 	applier      *EventApplier
+	dlq          *DeadLetterQueue // nil - DLQ выключен
+	transactions *txBuffer        // nil - события применяются без группировки по транзакциям
+	pending      *pendingBuffer   // nil - события не ждут родительские строки
+	slots        chan struct{}    // Слоты применения воркеров партиций (задаются пулом воркеров)
 	
-	// Метрики
-	processedCount int64
-	skippedCount   int64
-	failedCount    int64
+	// Метрики (обновляются воркерами партиций конкурентно)
+	processedCount    int64
+	skippedCount      int64
+	failedCount       int64
+	deadLetteredCount int64
+	timedOutCount     int64
+	incompleteTxCount int64
 }
 
 // Config представляет конфигурацию Consumer
 type Config struct {
 	MyContour           string
 	Database            string
+	// BatchSize - сколько сообщений партиции применять одной транзакцией (1 - по одному)
 	BatchSize           int
+	// BatchMaxWait - сколько ждать добора батча после первого сообщения
+	BatchMaxWait        time.Duration
+	// EventTimeout - дедлайн транзакции применения события (батча) и statement_timeout
 	EventTimeout        time.Duration
-	ConflictResolution  string // last_write_wins, skip, error
+	// LockTimeout - lock_timeout транзакции применения
+	LockTimeout         time.Duration
+	ConflictResolution  string // Пресет по умолчанию: last_write_wins, skip, error, timestamp, hlc
+	// TableConflicts - политики конфликтов отдельных таблиц (schema.table; без схемы - public)
+	TableConflicts      map[string]ConflictPolicy
+	// Resolvers - ConflictResolver таблиц со стратегией custom
+	Resolvers           *ResolverRegistry
+	// SoftDeletes - таблицы, в которых DELETE применяется как UPDATE колонки мягкого удаления (schema.table -> колонка)
+	SoftDeletes         map[string]string
+	// ApplyMode - select (SELECT version + DML) или upsert (INSERT ... ON CONFLICT с проверкой версии)
+	ApplyMode           string
+	// Tables - allowlist реплицируемых таблиц (schema.table; без схемы - public)
+	Tables              []string
+	// UnknownColumns - политика для колонок, которых нет в таблице: reject или drop
+	UnknownColumns      string
+	Retry               retry.Policy
+	// RedriveInterval - как часто повторно применять события replication_dlq со status = 'redrive'
+	RedriveInterval     time.Duration
+	// TombstoneRetention - сколько хранить ключи удаленных строк (больше максимальной задержки репликации)
+	TombstoneRetention  time.Duration
+	// TombstoneCleanupInterval - как часто удалять устаревшие tombstones (0 - не удалять)
+	TombstoneCleanupInterval time.Duration
+	// MaxWorkers - сколько партиций могут применяться к БД одновременно
+	MaxWorkers          int
+	// PartitionQueueSize - размер очереди сообщений воркера партиции (при переполнении партиция ставится на паузу)
+	PartitionQueueSize  int
+	// TransactionTimeout - сколько ждать остальные события исходной транзакции, чтобы применить их атомарно
+	// (0 - события применяются без группировки). По истечении события применяются по отдельности.
+	TransactionTimeout  time.Duration
+	// DependencyTimeout - сколько событие ждет родительскую строку по внешнему ключу (0 - не ждет).
+	// По истечении событие применяется с повторными попытками и уходит в DLQ.
+	DependencyTimeout   time.Duration
+	// MaxPendingEvents - сколько событий могут одновременно ждать родительские строки
+	MaxPendingEvents    int
 }
 
-// New создает новый Consumer
-func New(db *gorm.DB, consumer *kafkapkg.Consumer, cfg Config, logger zerolog.Logger) *Consumer {
-	return &Consumer{
+// New создает новый Consumer. dlq может быть nil - тогда после исчерпания попыток
+// сообщение перечитывается до успешного применения.
+func New(db *gorm.DB, consumer *kafkapkg.Consumer, dlq *DeadLetterQueue, cfg Config, logger zerolog.Logger) *Consumer {
+	c := &Consumer{
 		db:       db,
 		consumer: consumer,
 		config:   cfg,
 		logger:   logger.With().Str("component", "consumer").Logger(),
 		applier:  NewEventApplier(db, cfg, logger),
+		dlq:      dlq,
 	}
+	if cfg.TransactionTimeout > 0 {
+		c.transactions = newTxBuffer(c, cfg.TransactionTimeout)
+	}
+	if cfg.DependencyTimeout > 0 {
+		c.pending = newPendingBuffer(c, cfg.DependencyTimeout, cfg.MaxPendingEvents)
+	}
+	return c
 }
 
 // Start запускает процесс потребления
@@ -52,49 +108,97 @@ func (c *Consumer) Start(ctx context.Context) error {
 	c.logger.Info().
 		Str("contour", c.config.MyContour).
 		Str("database", c.config.Database).
+		Int("max_workers", c.config.MaxWorkers).
 		Msg("Consumer started")
 
+	pool := newWorkerPool(c)
+
+	// При отзыве партиций дообрабатываем их воркеры и коммитим примененные offsets
+	if err := c.consumer.Subscribe(pool.revoke); err != nil {
+		return err
+	}
+
+	// Повторное применение событий из replication_dlq
+	var redrive <-chan time.Time
+	if c.dlq != nil && c.config.RedriveInterval > 0 {
+		ticker := time.NewTicker(c.config.RedriveInterval)
+		defer ticker.Stop()
+		redrive = ticker.C
+	}
+
+	// Очистка устаревших tombstones
+	var tombstoneCleanup <-chan time.Time
+	if c.config.TombstoneCleanupInterval > 0 && c.config.TombstoneRetention > 0 {
+		ticker := time.NewTicker(c.config.TombstoneCleanupInterval)
+		defer ticker.Stop()
+		tombstoneCleanup = ticker.C
+	}
+
+	commitTicker := time.NewTicker(offsetCommitInterval)
+	defer commitTicker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
+			// Останавливаем воркеры и коммитим то, что успели применить
+			pool.stopAll()
 			c.logger.Info().Msg("Consumer stopped by context")
 			return ctx.Err()
+
+		case <-redrive:
+			if err := c.redriveDeadLetters(ctx); err != nil {
+				c.logger.Error().
+					Err(err).
+					Msg("Failed to redrive dead letters")
+			}
+
+		case <-tombstoneCleanup:
+			if err := c.cleanupTombstones(ctx); err != nil {
+				c.logger.Error().
+					Err(err).
+					Msg("Failed to cleanup tombstones")
+			}
+
+		case <-commitTicker.C:
+			pool.commitApplied()
 			
 		default:
-			if err := c.processMessage(ctx); err != nil {
+			// Читаем сообщение из Kafka (timeout 1 секунда)
+			message, err := c.consumer.Poll(1 * time.Second)
+			if err != nil {
 				c.logger.Error().
 					Err(err).
-					Msg("Failed to process message")
-				c.failedCount++
-				// Не останавливаем consumer при ошибке, продолжаем обработку
+					Msg("Failed to poll message")
+				atomic.AddInt64(&c.failedCount, 1)
+				continue
 			}
+
+			// Раздаем сообщения воркерам партиций (и досылаем отложенные)
+			pool.dispatch(ctx, message)
 		}
 	}
 }
 
-// processMessage обрабатывает одно сообщение из Kafka
-func (c *Consumer) processMessage(ctx context.Context) error {
-	// Читаем сообщение из Kafka (timeout 1 секунда)
-	message, err := c.consumer.Poll(1 * time.Second)
-	if err != nil {
-		return fmt.Errorf("failed to poll message: %w", err)
-	}
-	
-	// Если сообщений нет, возвращаемся
-	if message == nil {
-		return nil
-	}
-
+// handleMessage обрабатывает одно сообщение из Kafka.
+// Возвращает true, если сообщение обработано и его offset можно коммитить.
+func (c *Consumer) handleMessage(ctx context.Context, message *kafka.Message) (bool, error) {
 	// Парсим событие
 	var event ReplicationEvent
-	if err := json.Unmarshal(message.Value, &event); err != nil {
+	if err := event.FromJSON(message.Value); err != nil {
 		c.logger.Error().
 			Err(err).
 			Str("raw_message", string(message.Value)).
 			Msg("Failed to parse event")
-		// Коммитим сообщение, чтобы не застревать на битом
-		c.consumer.Commit(message)
-		return fmt.Errorf("failed to parse event: %w", err)
+		parseErr := fmt.Errorf("failed to parse event: %w", err)
+		// Сохраняем битое сообщение в DLQ (если включен) и коммитим, чтобы не застревать на нем
+		if c.dlq != nil {
+			if err := c.dlq.Send(ctx, message, nil, parseErr, 1); err != nil {
+				c.rewind(message)
+				return false, fmt.Errorf("failed to send to dlq: %w", err)
+			}
+			atomic.AddInt64(&c.deadLetteredCount, 1)
+		}
+		return true, parseErr
 	}
 
 	c.logger.Debug().
@@ -111,39 +215,143 @@ func (c *Consumer) processMessage(ctx context.Context) error {
 			Str("source_contour", event.Source.Contour).
 			Str("my_contour", c.config.MyContour).
 			Msg("Skipping own event")
-		c.skippedCount++
+		atomic.AddInt64(&c.skippedCount, 1)
 		// Коммитим, так как событие обработано (пропущено намеренно)
-		return c.consumer.Commit(message)
+		return true, nil
 	}
 
-	// Обрабатываем событие
-	if err := c.applyEvent(ctx, event); err != nil {
-		c.logger.Error().
-			Err(err).
-			Str("event_id", event.EventID).
-			Msg("Failed to apply event")
-		// НЕ коммитим при ошибке - Kafka повторит доставку
-		return fmt.Errorf("failed to apply event: %w", err)
+	// Событие исходной транзакции применяется вместе с остальными ее событиями
+	if c.transactions.grouped(event) {
+		err := c.transactions.submit(ctx, event)
+		if err == nil {
+			processed := atomic.AddInt64(&c.processedCount, 1)
+			c.logger.Info().
+				Str("event_id", event.EventID).
+				Str("table", event.Table).
+				Str("operation", event.Operation).
+				Int64("tx_id", event.Transaction.ID).
+				Int64("total_processed", processed).
+				Msg("Event applied with source transaction")
+			return true, nil
+		}
+		if ctx.Err() != nil {
+			return false, err
+		}
+		// Транзакция не собралась или не применилась - применяем событие отдельно
+	}
+
+	// Строка-потомок ждет родительскую строку, которая идет другим топиком
+	if err := c.pending.await(ctx, event); err != nil {
+		if ctx.Err() != nil {
+			return false, err
+		}
+		if !errors.Is(err, errParentTimeout) && !errors.Is(err, errPendingFull) {
+			c.logger.Warn().
+				Err(err).
+				Str("event_id", event.EventID).
+				Msg("Failed to check parent rows, applying event")
+		}
 	}
 
-	// Коммитим успешно обработанное сообщение
-	if err := c.consumer.Commit(message); err != nil {
+	// Обрабатываем событие с повторными попытками
+	if attempts, err := c.applyWithRetry(ctx, event); err != nil {
 		c.logger.Error().
 			Err(err).
 			Str("event_id", event.EventID).
-			Msg("Failed to commit message")
-		return fmt.Errorf("failed to commit message: %w", err)
+			Int("attempts", attempts).
+			Msg("Failed to apply event")
+		return c.handleFailedEvent(ctx, message, event, err, attempts)
 	}
 
-	c.processedCount++
+	processed := atomic.AddInt64(&c.processedCount, 1)
 	c.logger.Info().
 		Str("event_id", event.EventID).
 		Str("table", event.Table).
 		Str("operation", event.Operation).
-		Int64("total_processed", c.processedCount).
+		Int64("total_processed", processed).
 		Msg("Event applied successfully")
 
-	return nil
+	return true, nil
+}
+
+// applyWithRetry применяет событие, повторяя попытки с экспоненциальной задержкой.
+// Возвращает число выполненных попыток.
+func (c *Consumer) applyWithRetry(ctx context.Context, event ReplicationEvent) (int, error) {
+	maxAttempts := c.config.Retry.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if err = c.applyEvent(ctx, event); err == nil {
+			// Строки таблицы появились - будим ожидающие их события
+			c.pending.release(event)
+			return attempt, nil
+		}
+
+		// Таблица вне allowlist или чужой ключ события - повторять бессмысленно, событие уходит в DLQ
+		if errors.Is(err, ErrTableNotAllowed) || errors.Is(err, ErrPrimaryKeyMismatch) {
+			return attempt, err
+		}
+
+		// Таймаут (блокировка или долгий запрос) - повторяемая ошибка
+		if isTimeout(err) && ctx.Err() == nil {
+			c.reportTimeout(ctx, err, event.GetSchema()+"."+event.Table)
+		}
+
+		if attempt == maxAttempts || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		c.logger.Warn().
+			Err(err).
+			Str("event_id", event.EventID).
+			Int("attempt", attempt).
+			Dur("retry_in", c.config.Retry.Backoff(attempt)).
+			Msg("Failed to apply event, retrying")
+
+		if !c.config.Retry.Wait(ctx, attempt) {
+			return attempt, ctx.Err()
+		}
+	}
+
+	return maxAttempts, err
+}
+
+// handleFailedEvent обрабатывает событие, которое не удалось применить за все попытки:
+// отправляет его в DLQ (offset можно коммитить), либо (без DLQ) возвращает партицию на это сообщение
+func (c *Consumer) handleFailedEvent(ctx context.Context, message *kafka.Message, event ReplicationEvent, cause error, attempts int) (bool, error) {
+	// Воркер остановлен (ребалансировка или остановка сервиса) - сообщение перечитает новый владелец партиции
+	if ctx.Err() != nil {
+		return false, fmt.Errorf("failed to apply event: %w", cause)
+	}
+
+	if c.dlq == nil {
+		// НЕ коммитим при ошибке - перечитываем сообщение повторно
+		c.rewind(message)
+		return false, fmt.Errorf("failed to apply event: %w", cause)
+	}
+
+	if err := c.dlq.Send(ctx, message, &event, cause, attempts); err != nil {
+		c.rewind(message)
+		return false, fmt.Errorf("failed to send event %s to dlq: %w", event.EventID, err)
+	}
+	atomic.AddInt64(&c.deadLetteredCount, 1)
+
+	// Событие помещено в DLQ - коммитим, чтобы партиция продолжила движение
+	return true, fmt.Errorf("event %s moved to dlq after %d attempts: %w", event.EventID, attempts, cause)
+}
+
+// rewind возвращает партицию на сообщение, чтобы оно было прочитано повторно
+func (c *Consumer) rewind(message *kafka.Message) {
+	if err := c.consumer.Seek(message); err != nil {
+		c.logger.Error().
+			Err(err).
+			Int32("partition", message.TopicPartition.Partition).
+			Int64("offset", int64(message.TopicPartition.Offset)).
+			Msg("Failed to rewind partition")
+	}
 }
 
 // shouldProcess определяет, нужно ли обрабатывать событие
@@ -157,11 +365,12 @@ func (c *Consumer) shouldProcess(event ReplicationEvent) bool {
 
 // applyEvent применяет событие к БД
 func (c *Consumer) applyEvent(ctx context.Context, event ReplicationEvent) error {
-	// Начинаем транзакцию
-	tx := c.db.WithContext(ctx).Begin()
-	if tx.Error != nil {
-		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	// Начинаем транзакцию (ограничена event_timeout)
+	tx, cancel, err := c.beginTx(ctx)
+	if err != nil {
+		return err
 	}
+	defer cancel()
 	defer func() {
 		if r := recover(); r != nil {
 			tx.Rollback()
@@ -215,8 +424,33 @@ func (c *Consumer) applyEvent(ctx context.Context, event ReplicationEvent) error
 	return nil
 }
 
+// releaseSlot освобождает слот применения воркера на время ожидания
+func (c *Consumer) releaseSlot() {
+	if c.slots != nil {
+		<-c.slots
+	}
+}
+
+// acquireSlot снова занимает слот применения после ожидания
+func (c *Consumer) acquireSlot() {
+	if c.slots != nil {
+		c.slots <- struct{}{}
+	}
+}
+
 // GetMetrics возвращает метрики consumer
-func (c *Consumer) GetMetrics() (processed, skipped, failed int64) {
-	return c.processedCount, c.skippedCount, c.failedCount
+func (c *Consumer) GetMetrics() (processed, skipped, failed, deadLettered, timedOut, incompleteTx int64) {
+	return atomic.LoadInt64(&c.processedCount),
+		atomic.LoadInt64(&c.skippedCount),
+		atomic.LoadInt64(&c.failedCount),
+		atomic.LoadInt64(&c.deadLetteredCount),
+		atomic.LoadInt64(&c.timedOutCount),
+		atomic.LoadInt64(&c.incompleteTxCount)
+}
+
+// DependencyMetrics возвращает число событий, ожидающих родительские строки, число ожидаемых
+// родительских строк и число ожиданий, завершившихся по таймауту
+func (c *Consumer) DependencyMetrics() (pending, parents int, timedOut int64) {
+	return c.pending.metrics()
 }
 
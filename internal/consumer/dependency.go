@@ -0,0 +1,150 @@
+package consumer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// parentRef - строка родительской таблицы, на которую ссылается событие
+type parentRef struct {
+	Table      string                 // schema.table родителя
+	Constraint string                 // Имя внешнего ключа
+	Key        map[string]interface{} // Колонки родителя -> значения из события
+}
+
+// String возвращает ключ родителя для логов и буфера ожидания
+func (r parentRef) String() string {
+	parts := make([]string, 0, len(r.Key))
+	for column, value := range r.Key {
+		parts = append(parts, fmt.Sprintf("%s=%v", column, value))
+	}
+	sort.Strings(parts)
+	return r.Table + "(" + strings.Join(parts, ",") + ")"
+}
+
+// ForeignKeys возвращает внешние ключи таблицы, загружая их из pg_constraint при первом обращении
+func (c *tableCatalog) ForeignKeys(db *gorm.DB, table *tableInfo) ([]database.ForeignKey, error) {
+	name := table.Schema + "." + table.Name
+
+	c.mu.RLock()
+	keys, ok := c.foreignKeys[name]
+	c.mu.RUnlock()
+	if ok {
+		return keys, nil
+	}
+
+	keys, err := database.LoadForeignKeys(db.Statement.Context, db, table.Schema, table.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.foreignKeys[name] = keys
+	c.mu.Unlock()
+
+	return keys, nil
+}
+
+// missingParents возвращает родительские строки, на которые ссылается INSERT/UPDATE события,
+// но которых еще нет в БД. Учитываются только родительские таблицы из allowlist:
+// строки остальных таблиц репликация не доставит.
+// Событие, которое не проходит проверку колонок, не задерживается - ошибку вернет применение.
+func (a *EventApplier) missingParents(db *gorm.DB, event ReplicationEvent) ([]parentRef, error) {
+	if event.Operation != "INSERT" && event.Operation != "UPDATE" {
+		return nil, nil
+	}
+
+	table, err := a.catalog.Lookup(db, event.GetSchema(), event.Table)
+	if err != nil {
+		return nil, nil
+	}
+	event, _, err = table.sanitize(event, a.config.UnknownColumns)
+	if err != nil {
+		return nil, nil
+	}
+	event, err = table.coerceEvent(event)
+	if err != nil {
+		return nil, nil
+	}
+
+	foreignKeys, err := a.catalog.ForeignKeys(db, table)
+	if err != nil {
+		return nil, err
+	}
+
+	var missing []parentRef
+	for _, fk := range foreignKeys {
+		parent := fk.ParentSchema + "." + fk.ParentTable
+		if !a.catalog.allowed[parent] {
+			continue
+		}
+
+		ref, ok := referencedKey(event, fk)
+		if !ok {
+			continue
+		}
+
+		// Ссылка строки на саму себя выполняется вместе с ее применением
+		if parent == table.Schema+"."+table.Name && referencesItself(event, ref) {
+			continue
+		}
+
+		exists, err := a.parentExists(db, fk, ref)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, parentRef{Table: parent, Constraint: fk.Name, Key: ref})
+		}
+	}
+
+	return missing, nil
+}
+
+// referencedKey возвращает значения колонок родителя, на которые ссылается строка.
+// Если какая-то колонка ключа NULL или не пришла в событии, ссылка не проверяется (MATCH SIMPLE).
+func referencedKey(event ReplicationEvent, fk database.ForeignKey) (map[string]interface{}, bool) {
+	key := make(map[string]interface{}, len(fk.Columns))
+	for i, column := range fk.Columns {
+		value, ok := event.After[column]
+		if !ok || value == nil {
+			return nil, false
+		}
+		key[fk.ParentColumns[i]] = value
+	}
+	return key, true
+}
+
+// referencesItself определяет, ссылается ли строка события на саму себя
+func referencesItself(event ReplicationEvent, key map[string]interface{}) bool {
+	for column, value := range key {
+		if fmt.Sprint(event.After[column]) != fmt.Sprint(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// parentExists проверяет наличие родительской строки
+func (a *EventApplier) parentExists(db *gorm.DB, fk database.ForeignKey, key map[string]interface{}) (bool, error) {
+	conditions := make([]string, 0, len(fk.ParentColumns))
+	values := make([]interface{}, 0, len(fk.ParentColumns))
+	for _, column := range fk.ParentColumns {
+		conditions = append(conditions, fmt.Sprintf("%s = ?", quoteIdentifier(column)))
+		values = append(values, key[column])
+	}
+
+	sql := fmt.Sprintf("SELECT 1 FROM %s.%s WHERE %s LIMIT 1",
+		quoteIdentifier(fk.ParentSchema), quoteIdentifier(fk.ParentTable), strings.Join(conditions, " AND "))
+
+	var found []int
+	if err := db.Raw(sql, values...).Scan(&found).Error; err != nil {
+		return false, fmt.Errorf("failed to check parent row of %s: %w", fk.Name, err)
+	}
+	return len(found) > 0, nil
+}
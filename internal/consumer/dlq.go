@@ -0,0 +1,116 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+	kafkapkg "github.com/vahtykov/go-replicator-service/internal/kafka"
+)
+
+// Заголовки сообщения в DLQ топике (значение сообщения - исходное событие без изменений)
+const (
+	dlqHeaderError           = "dlq.error"
+	dlqHeaderAttempts        = "dlq.attempts"
+	dlqHeaderSourceTopic     = "dlq.source.topic"
+	dlqHeaderSourcePartition = "dlq.source.partition"
+	dlqHeaderSourceOffset    = "dlq.source.offset"
+	dlqHeaderContour         = "dlq.contour"
+	dlqHeaderFailedAt        = "dlq.failed_at"
+)
+
+// DeadLetterQueue отправляет события, которые не удалось применить, в DLQ топик
+// и сохраняет их копию в replication_dlq для разбора и повторного применения
+type DeadLetterQueue struct {
+	db       *gorm.DB
+	producer *kafkapkg.Producer
+	topic    string
+	contour  string
+	logger   zerolog.Logger
+}
+
+// NewDeadLetterQueue создает DeadLetterQueue
+func NewDeadLetterQueue(db *gorm.DB, producer *kafkapkg.Producer, topic string, contour string, logger zerolog.Logger) *DeadLetterQueue {
+	return &DeadLetterQueue{
+		db:       db,
+		producer: producer,
+		topic:    topic,
+		contour:  contour,
+		logger:   logger.With().Str("component", "dlq").Logger(),
+	}
+}
+
+// Send отправляет исходное сообщение с описанием ошибки в DLQ.
+// event может быть nil, если сообщение не удалось разобрать.
+func (q *DeadLetterQueue) Send(ctx context.Context, message *kafka.Message, event *ReplicationEvent, cause error, attempts int) error {
+	failedAt := time.Now().UTC()
+	sourceTopic := ""
+	if message.TopicPartition.Topic != nil {
+		sourceTopic = *message.TopicPartition.Topic
+	}
+
+	// 1. Публикуем в DLQ топик
+	err := q.producer.ProduceMessage(kafkapkg.Message{
+		Topic: q.topic,
+		Key:   message.Key,
+		Value: message.Value,
+		Headers: map[string]string{
+			dlqHeaderError:           cause.Error(),
+			dlqHeaderAttempts:        strconv.Itoa(attempts),
+			dlqHeaderSourceTopic:     sourceTopic,
+			dlqHeaderSourcePartition: strconv.FormatInt(int64(message.TopicPartition.Partition), 10),
+			dlqHeaderSourceOffset:    strconv.FormatInt(int64(message.TopicPartition.Offset), 10),
+			dlqHeaderContour:         q.contour,
+			dlqHeaderFailedAt:        failedAt.Format(time.RFC3339Nano),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to produce to dlq topic: %w", err)
+	}
+
+	// 2. Сохраняем копию в replication_dlq (повторная отправка того же offset игнорируется)
+	deadLetter := database.DeadLetter{
+		SourceTopic:     sourceTopic,
+		SourcePartition: message.TopicPartition.Partition,
+		SourceOffset:    int64(message.TopicPartition.Offset),
+		MessageKey:      string(message.Key),
+		Payload:         string(message.Value),
+		Error:           cause.Error(),
+		Attempts:        attempts,
+		Status:          database.DeadLetterStatusFailed,
+		CreatedAt:       failedAt,
+	}
+	if event != nil {
+		deadLetter.EventID = event.EventID
+		deadLetter.Table = event.Table
+		deadLetter.Operation = event.Operation
+		deadLetter.SourceContour = event.Source.Contour
+	}
+
+	result := q.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&deadLetter)
+	if result.Error != nil {
+		return fmt.Errorf("failed to insert into replication_dlq: %w", result.Error)
+	}
+
+	q.logger.Warn().
+		Err(cause).
+		Str("event_id", deadLetter.EventID).
+		Str("table", deadLetter.Table).
+		Str("source_topic", sourceTopic).
+		Int32("source_partition", deadLetter.SourcePartition).
+		Int64("source_offset", deadLetter.SourceOffset).
+		Int("attempts", attempts).
+		Str("dlq_topic", q.topic).
+		Msg("Event moved to dead letter queue")
+
+	return nil
+}
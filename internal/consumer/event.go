@@ -1,7 +1,9 @@
 package consumer
 
 import (
+	"bytes"
 	"encoding/json"
+	"sort"
 	"time"
 )
 
@@ -10,11 +12,16 @@ type ReplicationEvent struct {
 	EventID    string                 `json:"event_id"`
 	Timestamp  time.Time              `json:"timestamp"`
 	Source     SourceInfo             `json:"source"`
+	Schema     string                 `json:"schema,omitempty"` // Пусто в событиях старых версий publisher
 	Table      string                 `json:"table"`
 	Operation  string                 `json:"operation"` // INSERT, UPDATE, DELETE
 	PrimaryKey map[string]interface{} `json:"primary_key"`
+	// PrimaryKeyColumns задает порядок колонок primary key (для составных ключей)
+	PrimaryKeyColumns []string           `json:"primary_key_columns,omitempty"`
 	Before     map[string]interface{} `json:"before,omitempty"`
 	After      map[string]interface{} `json:"after,omitempty"`
+	// Transaction - границы исходной транзакции (нет в событиях старых версий publisher)
+	Transaction *TransactionInfo `json:"transaction,omitempty"`
 }
 
 // SourceInfo содержит информацию об источнике события
@@ -23,17 +30,35 @@ type SourceInfo struct {
 	Database string `json:"database"`
 }
 
-// FromJSON десериализует событие из JSON
+// FromJSON десериализует событие из JSON.
+// Числа сохраняются как json.Number и приводятся к типам колонок при применении.
 func (e *ReplicationEvent) FromJSON(data []byte) error {
-	return json.Unmarshal(data, e)
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	return decoder.Decode(e)
 }
 
-// GetPrimaryKeyValue возвращает значение primary key (предполагается id)
-func (e *ReplicationEvent) GetPrimaryKeyValue() interface{} {
-	if id, ok := e.PrimaryKey["id"]; ok {
-		return id
+// GetSchema возвращает схему таблицы события (по умолчанию public)
+func (e *ReplicationEvent) GetSchema() string {
+	if e.Schema == "" {
+		return "public"
 	}
-	return nil
+	return e.Schema
+}
+
+// GetPrimaryKeyColumns возвращает колонки primary key в детерминированном порядке
+func (e *ReplicationEvent) GetPrimaryKeyColumns() []string {
+	if len(e.PrimaryKeyColumns) > 0 {
+		return e.PrimaryKeyColumns
+	}
+
+	// Старые события без primary_key_columns - сортируем по имени
+	columns := make([]string, 0, len(e.PrimaryKey))
+	for column := range e.PrimaryKey {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	return columns
 }
 
 // GetVersion возвращает версию записи из After или Before
@@ -53,6 +78,10 @@ func (e *ReplicationEvent) GetVersion() int64 {
 	if version, ok := data["version"]; ok {
 		// Может быть float64 (из JSON) или int64
 		switch v := version.(type) {
+		case json.Number:
+			if n, err := v.Int64(); err == nil {
+				return n
+			}
 		case float64:
 			return int64(v)
 		case int64:
@@ -0,0 +1,226 @@
+package consumer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// Колонки учета версий меняются при каждом UPDATE на обоих контурах, поэтому при слиянии
+// не считаются конфликтующими: в строку записывается большее из локального и входящего значений
+var mergeVersionColumns = []string{"version", "updated_at", "hlc"}
+
+// versionColumns возвращает колонки учета версий с учетом колонки сравнения политики
+func (p ConflictPolicy) versionColumns() map[string]bool {
+	columns := make(map[string]bool, len(mergeVersionColumns)+1)
+	for _, column := range mergeVersionColumns {
+		columns[column] = true
+	}
+	columns[p.Column] = true
+	return columns
+}
+
+// changedColumns возвращает колонки, измененные событием (before и after различаются).
+// Значения уже приведены к типам колонок, поэтому сравниваются по текстовому представлению.
+func changedColumns(event ReplicationEvent, skip map[string]bool) []string {
+	columns := make([]string, 0, len(event.After))
+	for column, after := range event.After {
+		if skip[column] {
+			continue
+		}
+		// Колонки без образа до изменения считаем измененными
+		before, ok := event.Before[column]
+		if !ok || fmt.Sprint(before) != fmt.Sprint(after) {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// columnState - состояние локального значения измененной колонки
+type columnState int
+
+const (
+	columnUntouched columnState = iota // Локальное значение равно before - колонку можно применить
+	columnConverged                    // Локальное значение уже равно after - применять нечего
+	columnConflict                     // Колонка изменена на обоих контурах по-разному
+)
+
+// compareColumns сравнивает локальные значения колонок с before и after события.
+// Сравнение выполняет БД (json приводится к jsonb - у json нет оператора равенства).
+func (a *EventApplier) compareColumns(tx *gorm.DB, table *tableInfo, event ReplicationEvent, columns []string) (map[string]columnState, error) {
+	selects := make([]string, 0, len(columns)*2)
+	values := make([]interface{}, 0, len(columns)*2)
+	for i, column := range columns {
+		expr, placeholder := quoteIdentifier(column), "?"
+		if table.Columns[column].UDTName == "json" {
+			expr, placeholder = expr+"::jsonb", "?::jsonb"
+		}
+		selects = append(selects,
+			fmt.Sprintf("%s IS NOT DISTINCT FROM %s AS b%d", expr, placeholder, i),
+			fmt.Sprintf("%s IS NOT DISTINCT FROM %s AS a%d", expr, placeholder, i))
+		values = append(values, event.Before[column], event.After[column])
+	}
+
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+	values = append(values, whereValues...)
+
+	sql := fmt.Sprintf("SELECT %s FROM %s WHERE %s LIMIT 1", strings.Join(selects, ", "), table.QuotedName, whereClause)
+	rows, err := tx.Raw(sql, values...).Rows()
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare columns: %w", err)
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to compare columns: %w", err)
+		}
+		return nil, fmt.Errorf("record disappeared while comparing columns")
+	}
+
+	equal := make([]bool, len(columns)*2)
+	dest := make([]interface{}, len(equal))
+	for i := range equal {
+		dest[i] = &equal[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return nil, fmt.Errorf("failed to scan column comparison: %w", err)
+	}
+
+	states := make(map[string]columnState, len(columns))
+	for i, column := range columns {
+		equalsBefore, equalsAfter := equal[i*2], equal[i*2+1]
+		switch {
+		case equalsAfter:
+			states[column] = columnConverged
+		case equalsBefore:
+			states[column] = columnUntouched
+		default:
+			states[column] = columnConflict
+		}
+	}
+
+	return states, nil
+}
+
+// applyMerge применяет UPDATE по колонкам: колонки, которые изменились только на контуре-источнике,
+// записываются поверх строки, не затрагивая локальные изменения других колонок.
+// Колонки, измененные на обоих контурах по-разному, разрешаются стратегией Fallback политики.
+func (a *EventApplier) applyMerge(tx *gorm.DB, table *tableInfo, event ReplicationEvent, policy ConflictPolicy, existing existingRow) error {
+	incomingVersion := event.GetVersion()
+	versionColumns := policy.versionColumns()
+
+	changed := changedColumns(event, versionColumns)
+	states := map[string]columnState{}
+	if len(changed) > 0 {
+		var err error
+		if states, err = a.compareColumns(tx, table, event, changed); err != nil {
+			return err
+		}
+	}
+
+	var apply, conflicts []string
+	for _, column := range changed {
+		switch states[column] {
+		case columnUntouched:
+			apply = append(apply, column)
+		case columnConflict:
+			conflicts = append(conflicts, column)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		fallback := policy.effective()
+		resolution := database.ConflictResolutionExistingKept
+
+		switch fallback.Strategy {
+		case ConflictLastWriteWins, ConflictTimestamp, ConflictHLC:
+			if fallback.incomingWins(existing, event.Source.Contour, a.config.MyContour) {
+				apply = append(apply, conflicts...)
+				resolution = database.ConflictResolutionIncomingApplied
+			}
+		case ConflictSkip:
+		case ConflictError:
+			if err := a.recordConflict(tx, table, event, database.ConflictResolutionRejected, existing.Version, incomingVersion); err != nil {
+				a.logger.Warn().Err(err).Str("event_id", event.EventID).Msg("Failed to record rejected conflict")
+			}
+			return fmt.Errorf("merge conflict on columns %s (fallback=error)", strings.Join(conflicts, ", "))
+		default:
+			return fmt.Errorf("unknown merge fallback strategy: %s", fallback.Strategy)
+		}
+
+		a.logger.Info().
+			Str("table", event.Table).
+			Interface("primary_key", event.PrimaryKey).
+			Strs("conflict_columns", conflicts).
+			Strs("applied_columns", apply).
+			Str("fallback", fallback.Strategy).
+			Str("resolution", resolution).
+			Int64("existing_version", existing.Version).
+			Int64("incoming_version", incomingVersion).
+			Msg("Merge conflict resolved by fallback strategy")
+
+		if err := a.recordConflict(tx, table, event, resolution, existing.Version, incomingVersion); err != nil {
+			return err
+		}
+	}
+
+	if len(apply) == 0 && len(conflicts) > 0 {
+		// Все измененные колонки остались локальными - строку не трогаем
+		return nil
+	}
+
+	sort.Strings(apply)
+	return a.execMergeUpdate(tx, table, event, event.After, apply, versionColumns)
+}
+
+// execMergeUpdate записывает колонки columns из row, а колонки учета версий - до большего
+// из локального значения и значения события
+func (a *EventApplier) execMergeUpdate(tx *gorm.DB, table *tableInfo, event ReplicationEvent, row map[string]interface{}, columns []string, versionColumns map[string]bool) error {
+	setClauses := make([]string, 0, len(columns)+len(versionColumns))
+	values := make([]interface{}, 0, cap(setClauses))
+	for _, column := range columns {
+		setClauses = append(setClauses, fmt.Sprintf("%s = ?", quoteIdentifier(column)))
+		values = append(values, row[column])
+	}
+
+	versioned := make([]string, 0, len(versionColumns))
+	for column := range versionColumns {
+		if _, ok := event.After[column]; ok {
+			versioned = append(versioned, column)
+		}
+	}
+	sort.Strings(versioned)
+	for _, column := range versioned {
+		quoted := quoteIdentifier(column)
+		setClauses = append(setClauses, fmt.Sprintf("%s = GREATEST(%s, ?)", quoted, quoted))
+		values = append(values, event.After[column])
+	}
+
+	if len(setClauses) == 0 {
+		return nil
+	}
+
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+	values = append(values, whereValues...)
+
+	sql := fmt.Sprintf("UPDATE %s SET %s WHERE %s", table.QuotedName, strings.Join(setClauses, ", "), whereClause)
+	if err := tx.Exec(sql, values...).Error; err != nil {
+		return fmt.Errorf("failed to merge update: %w", err)
+	}
+
+	a.logger.Debug().
+		Str("table", event.Table).
+		Interface("primary_key", event.PrimaryKey).
+		Strs("columns", columns).
+		Int64("new_version", event.GetVersion()).
+		Msg("UPDATE merged")
+
+	return nil
+}
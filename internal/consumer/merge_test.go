@@ -0,0 +1,66 @@
+package consumer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangedColumns(t *testing.T) {
+	tests := []struct {
+		name  string
+		event ReplicationEvent
+		skip  map[string]bool
+		want  []string
+	}{
+		{
+			name: "only changed columns in sorted order",
+			event: ReplicationEvent{
+				Before: map[string]interface{}{"id": int64(1), "name": "a", "email": "x", "age": int64(30)},
+				After:  map[string]interface{}{"id": int64(1), "name": "b", "email": "x", "age": int64(31)},
+			},
+			want: []string{"age", "name"},
+		},
+		{
+			name: "version columns skipped",
+			event: ReplicationEvent{
+				Before: map[string]interface{}{"name": "a", "version": int64(1), "updated_at": "2024-01-01"},
+				After:  map[string]interface{}{"name": "b", "version": int64(2), "updated_at": "2024-01-02"},
+			},
+			skip: ConflictPolicy{Column: "version"}.versionColumns(),
+			want: []string{"name"},
+		},
+		{
+			name: "column missing in before is changed",
+			event: ReplicationEvent{
+				Before: map[string]interface{}{"name": "a"},
+				After:  map[string]interface{}{"name": "a", "note": "new"},
+			},
+			want: []string{"note"},
+		},
+		{
+			name: "change to null",
+			event: ReplicationEvent{
+				Before: map[string]interface{}{"note": "text"},
+				After:  map[string]interface{}{"note": nil},
+			},
+			want: []string{"note"},
+		},
+		{
+			name: "bytes compared by value",
+			event: ReplicationEvent{
+				Before: map[string]interface{}{"data": []byte{1, 2}},
+				After:  map[string]interface{}{"data": []byte{1, 2}},
+			},
+			want: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := changedColumns(tt.event, tt.skip)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,277 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errPendingFull - буфер ожидающих событий заполнен: событие применяется без ожидания родителя
+var errPendingFull = errors.New("pending buffer is full")
+
+// errParentTimeout - родительская строка не пришла за dependency_timeout
+var errParentTimeout = errors.New("parent row did not arrive in time")
+
+// pendingBuffer задерживает события, ссылающиеся на еще не реплицированные родительские строки
+// (родитель и потомок идут разными топиками и могут прийти в любом порядке).
+// Воркер партиции ждет, пока событие родительской таблицы не будет применено, и перепроверяет ссылку.
+type pendingBuffer struct {
+	c       *Consumer
+	timeout time.Duration
+	max     int
+
+	mu      sync.Mutex
+	parked  map[string]int           // Ключ родителя -> сколько событий его ждут
+	size    int                      // Сколько событий ждут сейчас
+	signals map[string]chan struct{} // schema.table родителя -> закрывается при применении ее событий
+
+	timedOut int64
+}
+
+// newPendingBuffer создает буфер ожидания родительских строк
+func newPendingBuffer(c *Consumer, timeout time.Duration, max int) *pendingBuffer {
+	if max < 1 {
+		max = 1
+	}
+	return &pendingBuffer{
+		c:       c,
+		timeout: timeout,
+		max:     max,
+		parked:  make(map[string]int),
+		signals: make(map[string]chan struct{}),
+	}
+}
+
+// await задерживает событие, пока не появятся все его родительские строки.
+// errParentTimeout и errPendingFull означают, что событие нужно применять как есть:
+// без родителя применение завершится ошибкой FK и уйдет в retry и DLQ.
+func (b *pendingBuffer) await(ctx context.Context, event ReplicationEvent) error {
+	if b == nil {
+		return nil
+	}
+
+	missing, err := b.c.applier.missingParents(b.c.db.WithContext(ctx), event)
+	if err != nil || len(missing) == 0 {
+		return err
+	}
+
+	keys := make([]string, len(missing))
+	for i, ref := range missing {
+		keys[i] = ref.String()
+	}
+
+	if !b.park(keys) {
+		b.c.logger.Warn().
+			Str("event_id", event.EventID).
+			Str("table", event.Table).
+			Strs("missing_parents", keys).
+			Int("max_pending", b.max).
+			Msg("Pending buffer is full, applying event without waiting for parent")
+		return errPendingFull
+	}
+	defer b.unpark(keys)
+
+	b.c.logger.Info().
+		Str("event_id", event.EventID).
+		Str("table", event.Table).
+		Strs("missing_parents", keys).
+		Msg("Event parked until parent row arrives")
+
+	// Родитель придет через другую партицию - освобождаем ей слот применения
+	b.c.releaseSlot()
+	defer b.c.acquireSlot()
+
+	started := time.Now()
+	deadline := time.NewTimer(b.timeout)
+	defer deadline.Stop()
+
+	for {
+		// Сигналы берем до перепроверки, чтобы не пропустить применение родителя между ними.
+		// Ждем все таблицы недостающих родителей: событие перепроверяется, какой бы из них ни пришел.
+		signal, stop := b.signal(parentTables(missing))
+
+		missing, err = b.c.applier.missingParents(b.c.db.WithContext(ctx), event)
+		if err != nil {
+			stop()
+			return err
+		}
+		if len(missing) == 0 {
+			stop()
+			b.c.logger.Info().
+				Str("event_id", event.EventID).
+				Str("table", event.Table).
+				Dur("waited", time.Since(started)).
+				Msg("Parent row arrived, releasing parked event")
+			return nil
+		}
+
+		select {
+		case <-signal:
+			stop()
+		case <-deadline.C:
+			stop()
+			atomic.AddInt64(&b.timedOut, 1)
+			b.c.logger.Warn().
+				Str("event_id", event.EventID).
+				Str("table", event.Table).
+				Str("missing_parents", missing[0].String()).
+				Dur("timeout", b.timeout).
+				Msg("Parent row did not arrive in time, applying event with retry")
+			return errParentTimeout
+		case <-ctx.Done():
+			stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// batchWaitsForParents определяет, ссылается ли событие батча на родительскую строку, которой нет
+// ни в БД, ни среди событий самого батча (их FK проверяются при коммите). Такой батч применяется
+// по одному, чтобы потомок дождался родителя в буфере.
+func (b *pendingBuffer) batchWaitsForParents(ctx context.Context, events []ReplicationEvent) (bool, error) {
+	if b == nil {
+		return false, nil
+	}
+
+	db := b.c.db.WithContext(ctx)
+	for _, event := range events {
+		missing, err := b.c.applier.missingParents(db, event)
+		if err != nil {
+			return false, err
+		}
+		for _, ref := range missing {
+			if !batchProvides(events, ref) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// batchProvides определяет, вставляет или обновляет ли событие батча родительскую строку ref
+func batchProvides(events []ReplicationEvent, ref parentRef) bool {
+	for _, event := range events {
+		if event.Operation != "INSERT" && event.Operation != "UPDATE" {
+			continue
+		}
+		if event.GetSchema()+"."+event.Table != ref.Table {
+			continue
+		}
+		if referencesItself(event, ref.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// parentTables возвращает таблицы недостающих родительских строк без повторов
+func parentTables(missing []parentRef) []string {
+	seen := make(map[string]bool, len(missing))
+	tables := make([]string, 0, len(missing))
+	for _, ref := range missing {
+		if !seen[ref.Table] {
+			seen[ref.Table] = true
+			tables = append(tables, ref.Table)
+		}
+	}
+	return tables
+}
+
+// park учитывает событие в буфере. false - буфер заполнен.
+func (b *pendingBuffer) park(keys []string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.size >= b.max {
+		return false
+	}
+	b.size++
+	for _, key := range keys {
+		b.parked[key]++
+	}
+	return true
+}
+
+// unpark удаляет событие из буфера
+func (b *pendingBuffer) unpark(keys []string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.size--
+	for _, key := range keys {
+		if b.parked[key]--; b.parked[key] <= 0 {
+			delete(b.parked, key)
+		}
+	}
+}
+
+// signal возвращает канал, который закроется при применении событий любой из таблиц,
+// и функцию, которую нужно вызвать, когда канал больше не нужен
+func (b *pendingBuffer) signal(tables []string) (<-chan struct{}, func()) {
+	b.mu.Lock()
+	signals := make([]chan struct{}, len(tables))
+	for i, table := range tables {
+		signal, ok := b.signals[table]
+		if !ok {
+			signal = make(chan struct{})
+			b.signals[table] = signal
+		}
+		signals[i] = signal
+	}
+	b.mu.Unlock()
+
+	if len(signals) == 1 {
+		return signals[0], func() {}
+	}
+
+	// Родители в нескольких таблицах: канал закрывается по первому из сигналов
+	merged := make(chan struct{})
+	done := make(chan struct{})
+	var once sync.Once
+	for _, signal := range signals {
+		go func(signal <-chan struct{}) {
+			select {
+			case <-signal:
+				once.Do(func() { close(merged) })
+			case <-done:
+			}
+		}(signal)
+	}
+	return merged, func() { close(done) }
+}
+
+// release будит события, ожидающие строки таблиц примененных событий.
+// Вызывается после коммита транзакции применения.
+func (b *pendingBuffer) release(events ...ReplicationEvent) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, event := range events {
+		if event.Operation != "INSERT" && event.Operation != "UPDATE" {
+			continue
+		}
+		table := event.GetSchema() + "." + event.Table
+		if signal, ok := b.signals[table]; ok {
+			close(signal)
+			delete(b.signals, table)
+		}
+	}
+}
+
+// metrics возвращает число ожидающих событий, число ожидаемых ими родительских строк
+// и число истекших ожиданий
+func (b *pendingBuffer) metrics() (pending, parents int, timedOut int64) {
+	if b == nil {
+		return 0, 0, 0
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size, len(b.parked), atomic.LoadInt64(&b.timedOut)
+}
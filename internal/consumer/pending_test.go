@@ -0,0 +1,77 @@
+package consumer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingSignalAnyParentTable(t *testing.T) {
+	b := newPendingBuffer(nil, time.Second, 1)
+
+	signal, stop := b.signal(parentTables([]parentRef{
+		{Table: "public.customers"},
+		{Table: "public.products"},
+		{Table: "public.customers"},
+	}))
+	defer stop()
+
+	// Применение событий второй таблицы будит ожидание
+	b.release(ReplicationEvent{Operation: "INSERT", Table: "products"})
+
+	select {
+	case <-signal:
+	case <-time.After(time.Second):
+		t.Fatal("signal was not closed after parent table release")
+	}
+}
+
+func TestPendingSignalIgnoresDelete(t *testing.T) {
+	b := newPendingBuffer(nil, time.Second, 1)
+
+	signal, stop := b.signal([]string{"public.customers"})
+	defer stop()
+
+	b.release(ReplicationEvent{Operation: "DELETE", Table: "customers"})
+
+	select {
+	case <-signal:
+		t.Fatal("signal was closed by DELETE")
+	default:
+	}
+}
+
+func TestBatchProvidesParent(t *testing.T) {
+	ref := parentRef{Table: "public.customers", Key: map[string]interface{}{"id": int64(7)}}
+
+	tests := []struct {
+		name   string
+		events []ReplicationEvent
+		want   bool
+	}{
+		{
+			name:   "parent inserted in batch",
+			events: []ReplicationEvent{{Operation: "INSERT", Table: "customers", After: map[string]interface{}{"id": float64(7)}}},
+			want:   true,
+		},
+		{
+			name:   "other parent row",
+			events: []ReplicationEvent{{Operation: "INSERT", Table: "customers", After: map[string]interface{}{"id": float64(8)}}},
+		},
+		{
+			name:   "same key in other schema",
+			events: []ReplicationEvent{{Operation: "INSERT", Schema: "billing", Table: "customers", After: map[string]interface{}{"id": float64(7)}}},
+		},
+		{
+			name:   "parent deleted in batch",
+			events: []ReplicationEvent{{Operation: "DELETE", Table: "customers", Before: map[string]interface{}{"id": float64(7)}}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := batchProvides(tt.events, ref); got != tt.want {
+				t.Errorf("batchProvides() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
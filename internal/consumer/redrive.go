@@ -0,0 +1,72 @@
+package consumer
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// redriveBatchSize - сколько записей replication_dlq повторно применяется за один проход
+const redriveBatchSize = 100
+
+// redriveDeadLetters повторно применяет события из replication_dlq,
+// которые оператор пометил status = 'redrive'
+func (c *Consumer) redriveDeadLetters(ctx context.Context) error {
+	var deadLetters []database.DeadLetter
+	result := c.db.WithContext(ctx).
+		Where("status = ?", database.DeadLetterStatusRedrive).
+		Order("id ASC").
+		Limit(redriveBatchSize).
+		Find(&deadLetters)
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to fetch dead letters: %w", result.Error)
+	}
+
+	for _, deadLetter := range deadLetters {
+		updates := map[string]interface{}{
+			"attempts": deadLetter.Attempts + 1,
+		}
+
+		var event ReplicationEvent
+		err := event.FromJSON([]byte(deadLetter.Payload))
+		if err == nil {
+			err = c.applyEvent(ctx, event)
+		}
+
+		if err != nil {
+			updates["status"] = database.DeadLetterStatusFailed
+			updates["error"] = err.Error()
+
+			c.logger.Warn().
+				Err(err).
+				Int64("dlq_id", deadLetter.ID).
+				Str("event_id", deadLetter.EventID).
+				Msg("Dead letter redrive failed")
+		} else {
+			// Родительская строка могла прийти только через DLQ - будим ожидающие ее события
+			c.pending.release(event)
+
+			updates["status"] = database.DeadLetterStatusRedriven
+			updates["redriven_at"] = time.Now()
+
+			c.logger.Info().
+				Int64("dlq_id", deadLetter.ID).
+				Str("event_id", deadLetter.EventID).
+				Str("table", deadLetter.Table).
+				Msg("Dead letter redriven successfully")
+		}
+
+		result := c.db.WithContext(ctx).
+			Model(&database.DeadLetter{}).
+			Where("id = ?", deadLetter.ID).
+			Updates(updates)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update dead letter %d: %w", deadLetter.ID, result.Error)
+		}
+	}
+
+	return nil
+}
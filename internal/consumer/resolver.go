@@ -0,0 +1,283 @@
+package consumer
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// ConflictCustom - конфликт разрешает ConflictResolver, зарегистрированный для таблицы
+const ConflictCustom = "custom"
+
+// ResolutionAction - решение ConflictResolver
+type ResolutionAction int
+
+const (
+	ResolutionApply ResolutionAction = iota // Применить входящее изменение
+	ResolutionSkip                          // Оставить локальную строку
+	ResolutionMerge                         // Записать строку Resolution.Row
+)
+
+// Conflict - данные конфликта, передаваемые в ConflictResolver
+type Conflict struct {
+	Schema          string
+	Table           string
+	Operation       string                 // INSERT, UPDATE или DELETE
+	Local           map[string]interface{} // Текущая локальная строка
+	Incoming        map[string]interface{} // Образ строки из события (before для DELETE)
+	Event           ReplicationEvent
+	MyContour       string
+	ExistingVersion int64
+	IncomingVersion int64
+}
+
+// Resolution - результат разрешения конфликта
+type Resolution struct {
+	Action ResolutionAction
+	Row    map[string]interface{} // Для ResolutionMerge: колонки, которые нужно записать в строку
+}
+
+// ConflictResolver разрешает конфликт по бизнес-правилам таблицы.
+// Ошибка отклоняет событие (как политика error) - оно уходит в retry и DLQ.
+type ConflictResolver interface {
+	Resolve(tx *gorm.DB, conflict Conflict) (Resolution, error)
+}
+
+// ResolverRegistry хранит ConflictResolver по имени таблицы (schema.table)
+type ResolverRegistry struct {
+	mu        sync.RWMutex
+	resolvers map[string]ConflictResolver
+}
+
+// NewResolverRegistry создает пустой реестр
+func NewResolverRegistry() *ResolverRegistry {
+	return &ResolverRegistry{resolvers: make(map[string]ConflictResolver)}
+}
+
+// Register регистрирует resolver таблицы (без схемы - public)
+func (r *ResolverRegistry) Register(table string, resolver ConflictResolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.resolvers[qualifyTableName(table)] = resolver
+}
+
+// Get возвращает resolver таблицы
+func (r *ResolverRegistry) Get(schema, table string) (ConflictResolver, bool) {
+	if r == nil {
+		return nil, false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	resolver, ok := r.resolvers[schema+"."+table]
+	return resolver, ok
+}
+
+// functionNamePattern - имя функции [schema.]name
+var functionNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// SQLResolver разрешает конфликт SQL выражением над jsonb значениями local, incoming и meta.
+// Результат выражения: NULL или 'skip' - оставить локальную строку, 'apply' - применить входящее
+// изменение, jsonb объект - записать его колонки в строку.
+// Исключение (RAISE) в выражении или функции отклоняет событие.
+type SQLResolver struct {
+	expression string
+}
+
+// NewSQLExpressionResolver создает resolver по SQL выражению, например:
+// CASE WHEN (incoming->>'balance')::numeric > (local->>'balance')::numeric THEN incoming ELSE NULL END
+func NewSQLExpressionResolver(expression string) (*SQLResolver, error) {
+	if strings.TrimSpace(expression) == "" {
+		return nil, fmt.Errorf("resolver expression is empty")
+	}
+	return &SQLResolver{expression: expression}, nil
+}
+
+// NewSQLFunctionResolver создает resolver, вызывающий функцию fn(local jsonb, incoming jsonb, meta jsonb) RETURNS jsonb
+func NewSQLFunctionResolver(function string) (*SQLResolver, error) {
+	if !functionNamePattern.MatchString(function) {
+		return nil, fmt.Errorf("invalid resolver function name: %s", function)
+	}
+
+	parts := strings.Split(function, ".")
+	for i, part := range parts {
+		parts[i] = quoteIdentifier(part)
+	}
+	return &SQLResolver{expression: strings.Join(parts, ".") + "(local, incoming, meta)"}, nil
+}
+
+// Resolve вычисляет выражение в транзакции события
+func (r *SQLResolver) Resolve(tx *gorm.DB, conflict Conflict) (Resolution, error) {
+	local, err := json.Marshal(conflict.Local)
+	if err != nil {
+		return Resolution{}, fmt.Errorf("failed to encode local row: %w", err)
+	}
+	incoming, err := json.Marshal(conflict.Incoming)
+	if err != nil {
+		return Resolution{}, fmt.Errorf("failed to encode incoming row: %w", err)
+	}
+	meta, err := json.Marshal(map[string]interface{}{
+		"event_id":         conflict.Event.EventID,
+		"schema":           conflict.Schema,
+		"table":            conflict.Table,
+		"operation":        conflict.Operation,
+		"source_contour":   conflict.Event.Source.Contour,
+		"my_contour":       conflict.MyContour,
+		"existing_version": conflict.ExistingVersion,
+		"incoming_version": conflict.IncomingVersion,
+	})
+	if err != nil {
+		return Resolution{}, fmt.Errorf("failed to encode conflict metadata: %w", err)
+	}
+
+	sql := fmt.Sprintf(`SELECT (%s)::text AS result
+		FROM (SELECT ?::jsonb AS local, ?::jsonb AS incoming, ?::jsonb AS meta) AS conflict`, r.expression)
+
+	var rows []struct {
+		Result *string `gorm:"column:result"`
+	}
+	if err := tx.Raw(sql, string(local), string(incoming), string(meta)).Scan(&rows).Error; err != nil {
+		return Resolution{}, fmt.Errorf("conflict resolver failed: %w", err)
+	}
+	if len(rows) == 0 || rows[0].Result == nil {
+		return Resolution{Action: ResolutionSkip}, nil
+	}
+
+	result := strings.TrimSpace(*rows[0].Result)
+	switch result {
+	case "apply", `"apply"`:
+		return Resolution{Action: ResolutionApply}, nil
+	case "skip", `"skip"`, "null":
+		return Resolution{Action: ResolutionSkip}, nil
+	}
+
+	var row database.JSONB
+	if err := row.Scan(result); err != nil {
+		return Resolution{}, fmt.Errorf("conflict resolver must return a JSON object, 'apply', 'skip' or NULL: %w", err)
+	}
+	return Resolution{Action: ResolutionMerge, Row: row}, nil
+}
+
+// resolveCustom передает конфликт зарегистрированному ConflictResolver таблицы и применяет его решение
+func (a *EventApplier) resolveCustom(tx *gorm.DB, table *tableInfo, event ReplicationEvent, existingVersion, incomingVersion int64) error {
+	resolver, ok := a.config.Resolvers.Get(table.Schema, table.Name)
+	if !ok {
+		return fmt.Errorf("no conflict resolver registered for %s.%s", table.Schema, table.Name)
+	}
+
+	local, err := a.loadRowImage(tx, table, event)
+	if err != nil {
+		return err
+	}
+
+	incoming := event.After
+	if event.Operation == "DELETE" {
+		incoming = event.Before
+	}
+
+	// Ошибка SQL в resolver прерывает транзакцию - откатываемся к точке сохранения,
+	// чтобы записать отклоненный конфликт
+	if err := tx.SavePoint("conflict_resolver").Error; err != nil {
+		return fmt.Errorf("failed to create savepoint: %w", err)
+	}
+
+	resolution, err := resolver.Resolve(tx, Conflict{
+		Schema:          table.Schema,
+		Table:           table.Name,
+		Operation:       event.Operation,
+		Local:           local,
+		Incoming:        incoming,
+		Event:           event,
+		MyContour:       a.config.MyContour,
+		ExistingVersion: existingVersion,
+		IncomingVersion: incomingVersion,
+	})
+	if err != nil {
+		if rollbackErr := tx.RollbackTo("conflict_resolver").Error; rollbackErr != nil {
+			return fmt.Errorf("%w (rollback to savepoint: %v)", err, rollbackErr)
+		}
+		if recordErr := a.recordConflict(tx, table, event, database.ConflictResolutionRejected, existingVersion, incomingVersion); recordErr != nil {
+			a.logger.Warn().Err(recordErr).Str("event_id", event.EventID).Msg("Failed to record rejected conflict")
+		}
+		return err
+	}
+
+	logEvent := a.logger.Info().
+		Str("table", event.Table).
+		Interface("primary_key", event.PrimaryKey).
+		Int64("existing_version", existingVersion).
+		Int64("incoming_version", incomingVersion)
+
+	switch resolution.Action {
+	case ResolutionSkip:
+		logEvent.Msg("Conflict resolved by custom resolver: keeping existing row")
+		return a.recordConflict(tx, table, event, database.ConflictResolutionExistingKept, existingVersion, incomingVersion)
+
+	case ResolutionApply:
+		logEvent.Msg("Conflict resolved by custom resolver: applying incoming change")
+		if err := a.recordConflict(tx, table, event, database.ConflictResolutionIncomingApplied, existingVersion, incomingVersion); err != nil {
+			return err
+		}
+		return a.forceApply(tx, table, event)
+
+	case ResolutionMerge:
+		row, err := table.coerceRow(resolution.Row)
+		if err != nil {
+			return fmt.Errorf("conflict resolver returned invalid row: %w", err)
+		}
+
+		logEvent.Msg("Conflict resolved by custom resolver: writing merged row")
+		if err := a.recordConflict(tx, table, event, database.ConflictResolutionMerged, existingVersion, incomingVersion); err != nil {
+			return err
+		}
+
+		// Ключ и колонки учета версий из строки resolver не берем: версии сводятся к большей
+		versionColumns := a.policyFor(table).versionColumns()
+		isKey := make(map[string]bool, len(event.PrimaryKey))
+		for column := range event.PrimaryKey {
+			isKey[column] = true
+		}
+		columns := make([]string, 0, len(row))
+		for column := range row {
+			if !isKey[column] && !versionColumns[column] {
+				columns = append(columns, column)
+			}
+		}
+		sort.Strings(columns)
+		return a.execMergeUpdate(tx, table, event, row, columns, versionColumns)
+	}
+
+	return fmt.Errorf("unknown conflict resolver action: %d", resolution.Action)
+}
+
+// forceApply применяет входящее изменение поверх существующей строки без проверки версии
+func (a *EventApplier) forceApply(tx *gorm.DB, table *tableInfo, event ReplicationEvent) error {
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+
+	if event.Operation == "DELETE" {
+		if column, ok := a.softDeleteColumn(table); ok {
+			return a.execSoftDelete(tx, table, event, column)
+		}
+
+		sql := fmt.Sprintf("DELETE FROM %s WHERE %s", table.QuotedName, whereClause)
+		if err := tx.Exec(sql, whereValues...).Error; err != nil {
+			return fmt.Errorf("failed to delete: %w", err)
+		}
+		return a.recordTombstone(tx, table, event)
+	}
+
+	setClauses, values := a.buildUpdateSQL(event, event.After)
+	values = append(values, whereValues...)
+
+	sql := fmt.Sprintf("UPDATE %s SET %s WHERE %s", table.QuotedName, strings.Join(setClauses, ", "), whereClause)
+	if err := tx.Exec(sql, values...).Error; err != nil {
+		return fmt.Errorf("failed to update: %w", err)
+	}
+	return nil
+}
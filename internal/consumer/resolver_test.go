@@ -0,0 +1,70 @@
+package consumer
+
+import "testing"
+
+func TestNewSQLFunctionResolver(t *testing.T) {
+	tests := []struct {
+		function string
+		want     string
+		wantErr  bool
+	}{
+		{function: "resolve_balance", want: `"resolve_balance"(local, incoming, meta)`},
+		{function: "billing.resolve_balance", want: `"billing"."resolve_balance"(local, incoming, meta)`},
+		{function: "Resolve_2", want: `"Resolve_2"(local, incoming, meta)`},
+		{function: "a.b.c", wantErr: true},
+		{function: "resolve(local)", wantErr: true},
+		{function: "drop table x; --", wantErr: true},
+		{function: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.function, func(t *testing.T) {
+			resolver, err := NewSQLFunctionResolver(tt.function)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %q", resolver.expression)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resolver.expression != tt.want {
+				t.Errorf("got %q, want %q", resolver.expression, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewSQLExpressionResolverEmpty(t *testing.T) {
+	if _, err := NewSQLExpressionResolver("  "); err == nil {
+		t.Fatal("expected error for empty expression")
+	}
+}
+
+func TestResolverRegistryQualifiesTables(t *testing.T) {
+	registry := NewResolverRegistry()
+	resolver := &SQLResolver{expression: "incoming"}
+	registry.Register("accounts", resolver)
+	registry.Register("billing.invoices", resolver)
+
+	tests := []struct {
+		schema, table string
+		want          bool
+	}{
+		{schema: "public", table: "accounts", want: true},
+		{schema: "billing", table: "invoices", want: true},
+		{schema: "billing", table: "accounts", want: false},
+		{schema: "public", table: "invoices", want: false},
+	}
+	for _, tt := range tests {
+		if _, ok := registry.Get(tt.schema, tt.table); ok != tt.want {
+			t.Errorf("Get(%s, %s) found = %v, want %v", tt.schema, tt.table, ok, tt.want)
+		}
+	}
+
+	var empty *ResolverRegistry
+	if _, ok := empty.Get("public", "accounts"); ok {
+		t.Error("nil registry must not return resolvers")
+	}
+}
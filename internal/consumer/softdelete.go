@@ -0,0 +1,81 @@
+package consumer
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// softDeleteColumn возвращает колонку мягкого удаления таблицы
+func (a *EventApplier) softDeleteColumn(table *tableInfo) (string, bool) {
+	column, ok := a.softDeletes[table.Schema+"."+table.Name]
+	return column, ok
+}
+
+// applySoftDelete применяет DELETE как UPDATE колонки мягкого удаления: строка остается в таблице.
+// Версия проверяется так же, как при физическом удалении.
+func (a *EventApplier) applySoftDelete(tx *gorm.DB, table *tableInfo, event ReplicationEvent, column string) error {
+	if _, ok := table.Columns[column]; !ok {
+		return fmt.Errorf("soft delete column %s does not exist in %s.%s", column, table.Schema, table.Name)
+	}
+
+	a.logger.Debug().
+		Str("table", event.Table).
+		Interface("primary_key", event.PrimaryKey).
+		Str("column", column).
+		Msg("Applying DELETE as soft delete")
+
+	policy := a.policyFor(table)
+	existing, exists, err := a.fetchExisting(tx, table, event, policy)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		// Строки нет - помечать нечего, tombstone не даст опоздавшему INSERT ее создать
+		a.logger.Debug().
+			Str("table", event.Table).
+			Interface("primary_key", event.PrimaryKey).
+			Msg("Soft DELETE on non-existing record")
+		return a.recordTombstone(tx, table, event)
+	}
+
+	// Строка изменена после удаляемой версии - конфликт по политике таблицы
+	if _, ok := event.Before[policy.Column]; ok && existing.Cmp > 0 {
+		return a.handleVersionConflict(tx, table, event, existing.Version, event.GetVersion())
+	}
+
+	return a.execSoftDelete(tx, table, event, column)
+}
+
+// execSoftDelete проставляет колонку мягкого удаления. Время удаления берется из образа строки
+// (логический DELETE из мягкого удаления на источнике) или из события; уже удаленная строка
+// сохраняет исходное время. version не уменьшается.
+func (a *EventApplier) execSoftDelete(tx *gorm.DB, table *tableInfo, event ReplicationEvent, column string) error {
+	deletedAt := event.Before[column]
+	if deletedAt == nil {
+		deletedAt = event.Timestamp
+		if event.Timestamp.IsZero() {
+			deletedAt = time.Now().UTC()
+		}
+	}
+
+	quoted := quoteIdentifier(column)
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+	values := append([]interface{}{deletedAt, event.GetVersion()}, whereValues...)
+
+	sql := fmt.Sprintf("UPDATE %s SET %s = COALESCE(%s, ?), version = GREATEST(version, ?) WHERE %s",
+		table.QuotedName, quoted, quoted, whereClause)
+	if err := tx.Exec(sql, values...).Error; err != nil {
+		return fmt.Errorf("failed to soft delete: %w", err)
+	}
+
+	a.logger.Debug().
+		Str("table", event.Table).
+		Interface("primary_key", event.PrimaryKey).
+		Str("column", column).
+		Msg("Soft DELETE applied")
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package consumer
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSoftDeleteColumnBySchema(t *testing.T) {
+	applier := NewEventApplier(nil, Config{
+		SoftDeletes: map[string]string{"users": "deleted_at", "billing.invoices": "archived_at"},
+	}, zerolog.Nop())
+
+	tests := []struct {
+		schema, table string
+		want          string
+		ok            bool
+	}{
+		{"public", "users", "deleted_at", true},
+		{"billing", "invoices", "archived_at", true},
+		{"billing", "users", "", false},
+		{"public", "orders", "", false},
+	}
+	for _, tt := range tests {
+		column, ok := applier.softDeleteColumn(&tableInfo{Schema: tt.schema, Name: tt.table})
+		if column != tt.want || ok != tt.ok {
+			t.Errorf("%s.%s: got (%q, %v), want (%q, %v)", tt.schema, tt.table, column, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestApplySoftDeleteRequiresColumn(t *testing.T) {
+	applier := NewEventApplier(nil, Config{}, zerolog.Nop())
+	event := ReplicationEvent{
+		Operation:  "DELETE",
+		Table:      "users",
+		PrimaryKey: map[string]interface{}{"id": 1},
+		Before:     map[string]interface{}{"id": 1, "version": 1},
+	}
+
+	// Проверка колонки выполняется до обращения к БД
+	if err := applier.applySoftDelete(nil, newTestTable("id", "version"), event, "deleted_at"); err == nil {
+		t.Error("expected error for missing soft delete column")
+	}
+}
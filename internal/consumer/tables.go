@@ -0,0 +1,198 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// Политики обработки колонок события, которых нет в таблице получателя
+const (
+	UnknownColumnsReject = "reject" // Событие не применяется (по умолчанию)
+	UnknownColumnsDrop   = "drop"   // Неизвестные колонки отбрасываются
+)
+
+// ErrTableNotAllowed - таблица события не входит в allowlist (такое событие не повторяется, а уходит в DLQ)
+var ErrTableNotAllowed = errors.New("table is not allowed for replication")
+
+// ErrPrimaryKeyMismatch - колонки ключа события не совпадают с primary key таблицы получателя.
+// Строка ищется только по настоящему primary key: событие с другим ключом не повторяется, а уходит в DLQ.
+var ErrPrimaryKeyMismatch = errors.New("event key does not match table primary key")
+
+// tableInfo описывает реплицируемую таблицу по данным каталога БД
+type tableInfo struct {
+	Schema     string
+	Name       string
+	QuotedName string // "schema"."table" - для подстановки в SQL
+	Columns    map[string]database.Column
+	PrimaryKey []string // Колонки primary key в порядке объявления (из pg_index)
+}
+
+// tableCatalog проверяет таблицы событий по allowlist и кеширует их колонки из information_schema.
+// Имена колонок в SQL подставляются только после проверки по каталогу и всегда в кавычках.
+type tableCatalog struct {
+	allowed map[string]bool // schema.table
+
+	mu          sync.RWMutex
+	tables      map[string]*tableInfo
+	foreignKeys map[string][]database.ForeignKey // Загружаются при первой проверке зависимостей
+}
+
+// newTableCatalog создает каталог. Имена таблиц без схемы относятся к public.
+func newTableCatalog(allowlist []string) *tableCatalog {
+	allowed := make(map[string]bool, len(allowlist))
+	for _, name := range allowlist {
+		allowed[qualifyTableName(name)] = true
+	}
+
+	return &tableCatalog{
+		allowed:     allowed,
+		tables:      make(map[string]*tableInfo),
+		foreignKeys: make(map[string][]database.ForeignKey),
+	}
+}
+
+// Lookup возвращает описание таблицы, загружая его из каталога при первом обращении
+func (c *tableCatalog) Lookup(db *gorm.DB, schema, table string) (*tableInfo, error) {
+	name := schema + "." + table
+	if !c.allowed[name] {
+		return nil, fmt.Errorf("%w: %s", ErrTableNotAllowed, name)
+	}
+
+	c.mu.RLock()
+	info, ok := c.tables[name]
+	c.mu.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	columns, err := database.LoadColumns(db.Statement.Context, db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+	primaryKey, err := database.LoadPrimaryKeyColumns(db.Statement.Context, db, schema, table)
+	if err != nil {
+		return nil, err
+	}
+
+	info = &tableInfo{
+		Schema:     schema,
+		Name:       table,
+		QuotedName: quoteIdentifier(schema) + "." + quoteIdentifier(table),
+		Columns:    make(map[string]database.Column, len(columns)),
+		PrimaryKey: primaryKey,
+	}
+	for _, column := range columns {
+		info.Columns[column.Name] = column
+	}
+
+	c.mu.Lock()
+	c.tables[name] = info
+	c.mu.Unlock()
+
+	return info, nil
+}
+
+// Invalidate сбрасывает закешированные колонки таблицы (например, после миграции)
+func (c *tableCatalog) Invalidate(schema, table string) {
+	c.mu.Lock()
+	delete(c.tables, schema+"."+table)
+	delete(c.foreignKeys, schema+"."+table)
+	c.mu.Unlock()
+}
+
+// sanitize проверяет колонки события по каталогу и возвращает событие с проверенными данными.
+// Ключ события должен совпадать с primary key таблицы; остальные неизвестные колонки
+// отклоняются или отбрасываются в зависимости от политики.
+func (t *tableInfo) sanitize(event ReplicationEvent, policy string) (ReplicationEvent, []string, error) {
+	if err := t.checkPrimaryKey(event); err != nil {
+		return event, nil, err
+	}
+	if _, ok := t.Columns["version"]; !ok {
+		return event, nil, fmt.Errorf("table %s.%s has no version column", t.Schema, t.Name)
+	}
+
+	var unknown []string
+	filter := func(data map[string]interface{}) map[string]interface{} {
+		if data == nil {
+			return nil
+		}
+		filtered := make(map[string]interface{}, len(data))
+		for column, value := range data {
+			if _, ok := t.Columns[column]; !ok {
+				unknown = append(unknown, column)
+				continue
+			}
+			filtered[column] = value
+		}
+		return filtered
+	}
+
+	event.Before = filter(event.Before)
+	event.After = filter(event.After)
+
+	if len(unknown) == 0 {
+		return event, nil, nil
+	}
+
+	unknown = uniqueSorted(unknown)
+	if policy == UnknownColumnsDrop {
+		return event, unknown, nil
+	}
+	return event, unknown, fmt.Errorf("unknown columns in %s.%s: %s", t.Schema, t.Name, strings.Join(unknown, ", "))
+}
+
+// checkPrimaryKey проверяет, что набор колонок ключа события - ровно primary key таблицы.
+// Иначе событие с подмененным ключом (например, по неуникальной колонке) изменило бы чужие строки.
+func (t *tableInfo) checkPrimaryKey(event ReplicationEvent) error {
+	columns := event.GetPrimaryKeyColumns()
+
+	listed := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		listed[column] = true
+	}
+
+	// Список колонок и значения ключа события содержат ровно колонки primary key таблицы
+	matches := len(listed) == len(columns) && len(columns) == len(t.PrimaryKey) && len(event.PrimaryKey) == len(t.PrimaryKey)
+	for _, column := range t.PrimaryKey {
+		if _, ok := event.PrimaryKey[column]; !ok || !listed[column] {
+			matches = false
+		}
+	}
+	if !matches {
+		return fmt.Errorf("%w: %s.%s has primary key (%s), event key (%s)", ErrPrimaryKeyMismatch,
+			t.Schema, t.Name, strings.Join(t.PrimaryKey, ", "), strings.Join(columns, ", "))
+	}
+	return nil
+}
+
+// quoteIdentifier экранирует идентификатор PostgreSQL
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// qualifyTableName дополняет имя таблицы схемой public, если схема не указана
+func qualifyTableName(name string) string {
+	if strings.Contains(name, ".") {
+		return name
+	}
+	return "public." + name
+}
+
+// uniqueSorted возвращает отсортированный список без повторов
+func uniqueSorted(values []string) []string {
+	sort.Strings(values)
+	result := values[:0]
+	for i, value := range values {
+		if i == 0 || value != values[i-1] {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,164 @@
+package consumer
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// newTestTable описывает public.users с колонками columns; primary key - первая колонка
+func newTestTable(columns ...string) *tableInfo {
+	info := &tableInfo{
+		Schema:     "public",
+		Name:       "users",
+		QuotedName: `"public"."users"`,
+		Columns:    make(map[string]database.Column, len(columns)),
+		PrimaryKey: columns[:1],
+	}
+	for _, column := range columns {
+		info.Columns[column] = database.Column{Name: column}
+	}
+	return info
+}
+
+func TestTableCatalogRejectsNotAllowedTable(t *testing.T) {
+	catalog := newTableCatalog([]string{"users", "billing.invoices"})
+
+	// Проверка allowlist выполняется до обращения к БД, поэтому db не нужен
+	_, err := catalog.Lookup(nil, "public", "orders")
+	if !errors.Is(err, ErrTableNotAllowed) {
+		t.Fatalf("expected ErrTableNotAllowed, got %v", err)
+	}
+	_, err = catalog.Lookup(nil, "public", "invoices")
+	if !errors.Is(err, ErrTableNotAllowed) {
+		t.Fatalf("expected ErrTableNotAllowed for table from another schema, got %v", err)
+	}
+}
+
+func TestTableCatalogReturnsCachedTable(t *testing.T) {
+	catalog := newTableCatalog([]string{"billing.invoices"})
+	cached := newTestTable("id", "version")
+	catalog.tables["billing.invoices"] = cached
+
+	info, err := catalog.Lookup(nil, "billing", "invoices")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info != cached {
+		t.Errorf("expected cached table info")
+	}
+}
+
+func TestTableInfoSanitize(t *testing.T) {
+	table := newTestTable("id", "name", "version")
+
+	event := ReplicationEvent{
+		Table:      "users",
+		PrimaryKey: map[string]interface{}{"id": 1},
+		After:      map[string]interface{}{"id": 1, "name": "a", "version": 2, "legacy": "x"},
+	}
+
+	if _, _, err := table.sanitize(event, UnknownColumnsReject); err == nil {
+		t.Error("expected unknown column to be rejected")
+	}
+
+	sanitized, unknown, err := table.sanitize(event, UnknownColumnsDrop)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(unknown, []string{"legacy"}) {
+		t.Errorf("unknown = %v, want [legacy]", unknown)
+	}
+	if _, ok := sanitized.After["legacy"]; ok {
+		t.Error("unknown column was not dropped")
+	}
+	if _, ok := event.After["legacy"]; !ok {
+		t.Error("sanitize must not modify the original event data")
+	}
+}
+
+func TestTableInfoSanitizeRequiresKeyAndVersion(t *testing.T) {
+	event := ReplicationEvent{
+		Table:      "users",
+		PrimaryKey: map[string]interface{}{"uuid": "u1"},
+		After:      map[string]interface{}{"uuid": "u1", "version": 1},
+	}
+	if _, _, err := newTestTable("id", "uuid", "version").sanitize(event, UnknownColumnsDrop); !errors.Is(err, ErrPrimaryKeyMismatch) {
+		t.Errorf("expected ErrPrimaryKeyMismatch for key other than primary key, got %v", err)
+	}
+
+	event.PrimaryKey = map[string]interface{}{"id": 1}
+	event.After = map[string]interface{}{"id": 1}
+	if _, _, err := newTestTable("id").sanitize(event, UnknownColumnsDrop); err == nil {
+		t.Error("expected error for table without version column")
+	}
+}
+
+func TestTableInfoSanitizeRejectsSpoofedKey(t *testing.T) {
+	table := newTestTable("order_id", "item_id", "sku", "version")
+	table.PrimaryKey = []string{"order_id", "item_id"}
+
+	tests := []struct {
+		name    string
+		columns []string
+		key     map[string]interface{}
+	}{
+		// Поиск строки по неуникальной колонке затронул бы чужие строки
+		{name: "non-key column", columns: []string{"sku"}, key: map[string]interface{}{"sku": "A-1"}},
+		{name: "part of composite key", columns: []string{"order_id"}, key: map[string]interface{}{"order_id": 10}},
+		{
+			name:    "extra column",
+			columns: []string{"order_id", "item_id", "sku"},
+			key:     map[string]interface{}{"order_id": 10, "item_id": 3, "sku": "A-1"},
+		},
+		{
+			name:    "repeated column in key list",
+			columns: []string{"order_id", "order_id"},
+			key:     map[string]interface{}{"order_id": 10, "item_id": 3},
+		},
+		{
+			name:    "key list differs from key values",
+			columns: []string{"order_id", "sku"},
+			key:     map[string]interface{}{"order_id": 10, "item_id": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := ReplicationEvent{
+				Table:             "users",
+				PrimaryKeyColumns: tt.columns,
+				PrimaryKey:        tt.key,
+				After:             map[string]interface{}{"order_id": 10, "item_id": 3, "sku": "A-1", "version": 1},
+			}
+			if _, _, err := table.sanitize(event, UnknownColumnsReject); !errors.Is(err, ErrPrimaryKeyMismatch) {
+				t.Errorf("expected ErrPrimaryKeyMismatch, got %v", err)
+			}
+		})
+	}
+
+	// Настоящий ключ принимается в любом порядке колонок
+	event := ReplicationEvent{
+		Table:             "users",
+		PrimaryKeyColumns: []string{"item_id", "order_id"},
+		PrimaryKey:        map[string]interface{}{"order_id": 10, "item_id": 3},
+		After:             map[string]interface{}{"order_id": 10, "item_id": 3, "version": 1},
+	}
+	if _, _, err := table.sanitize(event, UnknownColumnsReject); err != nil {
+		t.Errorf("unexpected error for real primary key: %v", err)
+	}
+}
+
+func TestQuoteIdentifier(t *testing.T) {
+	if got := quoteIdentifier(`weird"name`); got != `"weird""name"` {
+		t.Errorf("quoteIdentifier = %s", got)
+	}
+	if got := qualifyTableName("users"); got != "public.users" {
+		t.Errorf("qualifyTableName(users) = %s", got)
+	}
+	if got := qualifyTableName("billing.invoices"); got != "billing.invoices" {
+		t.Errorf("qualifyTableName(billing.invoices) = %s", got)
+	}
+}
@@ -0,0 +1,137 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+// SQLSTATE ошибок, которыми PostgreSQL прерывает запрос по таймауту
+const (
+	sqlStateQueryCanceled    = "57014" // statement_timeout или отмена по дедлайну контекста
+	sqlStateLockNotAvailable = "55P03" // lock_timeout
+)
+
+// lockHoldersTimeout - сколько ждать запроса к pg_locks после таймаута применения
+const lockHoldersTimeout = 5 * time.Second
+
+// lockHolder описывает сессию, удерживающую блокировку на таблице
+type lockHolder struct {
+	PID             int32      `gorm:"column:pid"`
+	User            string     `gorm:"column:usename"`
+	ApplicationName string     `gorm:"column:application_name"`
+	State           string     `gorm:"column:state"`
+	Mode            string     `gorm:"column:mode"`
+	XactStart       *time.Time `gorm:"column:xact_start"`
+	Query           string     `gorm:"column:query"`
+}
+
+// beginTx начинает транзакцию применения, ограниченную event_timeout:
+// дедлайн контекста на стороне клиента и statement_timeout/lock_timeout на стороне сервера.
+// cancel нужно вызвать после завершения транзакции.
+func (c *Consumer) beginTx(ctx context.Context) (*gorm.DB, context.CancelFunc, error) {
+	cancel := context.CancelFunc(func() {})
+	if c.config.EventTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.config.EventTimeout)
+	}
+
+	tx := c.db.WithContext(ctx).Begin()
+	if tx.Error != nil {
+		cancel()
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", tx.Error)
+	}
+
+	// SET LOCAL действует до конца транзакции; значения в миллисекундах (параметры здесь не поддерживаются)
+	if c.config.EventTimeout > 0 {
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL statement_timeout = %d", c.config.EventTimeout.Milliseconds())).Error; err != nil {
+			tx.Rollback()
+			cancel()
+			return nil, nil, fmt.Errorf("failed to set statement_timeout: %w", err)
+		}
+	}
+	if c.config.LockTimeout > 0 {
+		if err := tx.Exec(fmt.Sprintf("SET LOCAL lock_timeout = %d", c.config.LockTimeout.Milliseconds())).Error; err != nil {
+			tx.Rollback()
+			cancel()
+			return nil, nil, fmt.Errorf("failed to set lock_timeout: %w", err)
+		}
+	}
+
+	return tx, cancel, nil
+}
+
+// isTimeout определяет, что применение прервано по таймауту (такие ошибки повторяемые)
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == sqlStateQueryCanceled || pgErr.Code == sqlStateLockNotAvailable
+	}
+	return false
+}
+
+// reportTimeout учитывает таймаут в метриках и логирует сессии, удерживающие блокировки на таблицах
+// (tables - schema.table)
+func (c *Consumer) reportTimeout(ctx context.Context, cause error, tables ...string) {
+	atomic.AddInt64(&c.timedOutCount, 1)
+
+	// Контекст события уже истек - для диагностики нужен свой
+	queryCtx, cancel := context.WithTimeout(ctx, lockHoldersTimeout)
+	defer cancel()
+
+	for _, table := range tables {
+		schema, name, _ := strings.Cut(table, ".")
+		var holders []lockHolder
+		result := c.db.WithContext(queryCtx).Raw(`
+			SELECT a.pid, a.usename, a.application_name, a.state, l.mode, a.xact_start,
+			       left(a.query, 200) AS query
+			FROM pg_locks l
+			JOIN pg_stat_activity a ON a.pid = l.pid
+			WHERE l.relation = to_regclass(quote_ident(?) || '.' || quote_ident(?))
+			  AND l.granted
+			  AND l.pid <> pg_backend_pid()
+			ORDER BY a.xact_start
+		`, schema, name).Scan(&holders)
+
+		if result.Error != nil {
+			c.logger.Warn().
+				Err(result.Error).
+				Str("table", table).
+				Msg("Failed to query lock holders")
+			continue
+		}
+
+		if len(holders) == 0 {
+			c.logger.Warn().
+				Err(cause).
+				Str("table", table).
+				Msg("Event apply timed out, no lock holders found")
+			continue
+		}
+
+		for _, holder := range holders {
+			event := c.logger.Warn().
+				Err(cause).
+				Str("table", table).
+				Int32("holder_pid", holder.PID).
+				Str("holder_user", holder.User).
+				Str("holder_application", holder.ApplicationName).
+				Str("holder_state", holder.State).
+				Str("lock_mode", holder.Mode).
+				Str("holder_query", holder.Query)
+			if holder.XactStart != nil {
+				event = event.Time("holder_xact_start", *holder.XactStart)
+			}
+			event.Msg("Event apply timed out, lock held by another session")
+		}
+	}
+}
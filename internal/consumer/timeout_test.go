@@ -0,0 +1,47 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestIsTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "context deadline", err: context.DeadlineExceeded, want: true},
+		{name: "wrapped deadline", err: fmt.Errorf("failed to apply DML: %w", context.DeadlineExceeded), want: true},
+		{name: "statement timeout", err: &pgconn.PgError{Code: sqlStateQueryCanceled}, want: true},
+		{name: "lock timeout", err: fmt.Errorf("commit: %w", &pgconn.PgError{Code: sqlStateLockNotAvailable}), want: true},
+		{name: "foreign key violation", err: &pgconn.PgError{Code: "23503"}, want: false},
+		{name: "context canceled", err: context.Canceled, want: false},
+		{name: "plain error", err: errors.New("boom"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isTimeout(tt.err); got != tt.want {
+				t.Errorf("isTimeout(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBatchTablesDeduplicates(t *testing.T) {
+	events := []ReplicationEvent{
+		{Table: "orders"},
+		{Schema: "crm", Table: "users"},
+		{Schema: "public", Table: "orders"},
+	}
+
+	tables := batchTables(events)
+	if len(tables) != 2 || tables[0] != "public.orders" || tables[1] != "crm.users" {
+		t.Errorf("got %v, want [public.orders crm.users]", tables)
+	}
+}
@@ -0,0 +1,145 @@
+package consumer
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// tombstoneKeySQL вычисляет ключ строки той же функцией, что и триггер при локальном DELETE:
+// replication_tombstone_key() приводит значения к типам колонок, и формат ключа не зависит
+// от того, как значения пришли в событии
+const tombstoneKeySQL = `replication_tombstone_key(?::regclass, ?::jsonb)`
+
+// tombstoneRow строит аргумент для replication_tombstone_key(): значения primary key
+// события в текстовом виде, bytea - в hex-формате PostgreSQL
+func tombstoneRow(event ReplicationEvent) (string, error) {
+	values := make(map[string]string, len(event.PrimaryKey))
+	for column, value := range event.PrimaryKey {
+		if value == nil {
+			return "", fmt.Errorf("primary key column %s is null", column)
+		}
+		if data, ok := value.([]byte); ok {
+			values[column] = `\x` + hex.EncodeToString(data)
+			continue
+		}
+		text, err := toText(value)
+		if err != nil {
+			return "", fmt.Errorf("primary key column %s: %w", column, err)
+		}
+		values[column] = text.(string)
+	}
+
+	encoded, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
+
+// fetchTombstone возвращает версию, с которой строка была удалена
+func (a *EventApplier) fetchTombstone(tx *gorm.DB, table *tableInfo, event ReplicationEvent) (int64, bool, error) {
+	row, err := tombstoneRow(event)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var versions []int64
+	err = tx.Raw(`SELECT version FROM replication_tombstones
+		WHERE schema_name = ? AND table_name = ? AND primary_key = `+tombstoneKeySQL,
+		table.Schema, table.Name, table.QuotedName, row).Scan(&versions).Error
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to check tombstone: %w", err)
+	}
+	if len(versions) == 0 {
+		return 0, false, nil
+	}
+
+	return versions[0], true, nil
+}
+
+// recordTombstone сохраняет ключ удаленной строки с версией удаления
+func (a *EventApplier) recordTombstone(tx *gorm.DB, table *tableInfo, event ReplicationEvent) error {
+	row, err := tombstoneRow(event)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec(`INSERT INTO replication_tombstones (schema_name, table_name, primary_key, version, source_contour, event_id)
+		SELECT ?::VARCHAR, ?::VARCHAR, key, ?::BIGINT, ?::VARCHAR, ?::VARCHAR
+		FROM (SELECT `+tombstoneKeySQL+` AS key) AS k
+		WHERE key IS NOT NULL
+		ON CONFLICT (schema_name, table_name, primary_key) DO UPDATE
+		SET version = GREATEST(replication_tombstones.version, EXCLUDED.version),
+		    source_contour = EXCLUDED.source_contour,
+		    event_id = EXCLUDED.event_id,
+		    deleted_at = NOW()`,
+		table.Schema, table.Name, event.GetVersion(), event.Source.Contour, event.EventID,
+		table.QuotedName, row).Error
+	if err != nil {
+		return fmt.Errorf("failed to record tombstone: %w", err)
+	}
+	return nil
+}
+
+// suppressedByTombstone проверяет, не удалена ли строка более новой версией, чем входящий INSERT/UPDATE.
+// Такое изменение отбрасывается (строка не воскрешается). Более новое изменение снимает tombstone -
+// на другом контуре DELETE проиграл ему по версии, и оба контура сходятся к строке.
+func (a *EventApplier) suppressedByTombstone(tx *gorm.DB, table *tableInfo, event ReplicationEvent) (bool, error) {
+	tombstoneVersion, found, err := a.fetchTombstone(tx, table, event)
+	if err != nil || !found {
+		return false, err
+	}
+
+	incomingVersion := event.GetVersion()
+	if incomingVersion > tombstoneVersion {
+		row, err := tombstoneRow(event)
+		if err != nil {
+			return false, err
+		}
+		err = tx.Exec(`DELETE FROM replication_tombstones
+			WHERE schema_name = ? AND table_name = ? AND primary_key = `+tombstoneKeySQL,
+			table.Schema, table.Name, table.QuotedName, row).Error
+		if err != nil {
+			return false, fmt.Errorf("failed to remove tombstone: %w", err)
+		}
+		return false, nil
+	}
+
+	a.logger.Info().
+		Str("table", event.Table).
+		Interface("primary_key", event.PrimaryKey).
+		Str("operation", event.Operation).
+		Int64("tombstone_version", tombstoneVersion).
+		Int64("incoming_version", incomingVersion).
+		Msg("Stale change for deleted record suppressed by tombstone")
+
+	if err := a.recordConflict(tx, table, event, database.ConflictResolutionExistingKept, tombstoneVersion, incomingVersion); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// cleanupTombstones удаляет tombstones старше срока хранения
+func (c *Consumer) cleanupTombstones(ctx context.Context) error {
+	result := c.db.WithContext(ctx).
+		Where("deleted_at < ?", time.Now().Add(-c.config.TombstoneRetention)).
+		Delete(&database.ReplicationTombstone{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to cleanup tombstones: %w", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		c.logger.Info().
+			Int64("deleted", result.RowsAffected).
+			Dur("retention", c.config.TombstoneRetention).
+			Msg("Expired tombstones removed")
+	}
+	return nil
+}
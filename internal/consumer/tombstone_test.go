@@ -0,0 +1,77 @@
+package consumer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// Значение primary key в событии приходит из to_jsonb(строки) на контуре-источнике,
+// триггер при локальном DELETE передает в replication_tombstone_key() тот же to_jsonb(OLD).
+// После приведения типов consumer должен передать функции тот же текст, что триггер.
+func TestTombstoneRow(t *testing.T) {
+	tests := []struct {
+		name    string
+		udtName string
+		value   interface{} // Значение из JSON события (json.Number для чисел)
+		trigger string      // to_jsonb(OLD) ->> колонка на стороне триггера
+	}{
+		{
+			name:    "int",
+			udtName: "int8",
+			value:   json.Number("9007199254740993"),
+			trigger: "9007199254740993",
+		},
+		{
+			name:    "uuid",
+			udtName: "uuid",
+			value:   "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+			trigger: "a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11",
+		},
+		{
+			name:    "timestamptz",
+			udtName: "timestamptz",
+			value:   "2024-03-01T10:00:00.123456+03:00",
+			trigger: "2024-03-01T10:00:00.123456+03:00",
+		},
+		{
+			name:    "numeric keeps trailing zeros",
+			udtName: "numeric",
+			value:   json.Number("1.50"),
+			trigger: "1.50",
+		},
+		{
+			name:    "bytea",
+			udtName: "bytea",
+			value:   `\x0102ff`,
+			trigger: `\x0102ff`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			coerced, err := coerceValue(database.Column{UDTName: tt.udtName}, tt.value)
+			if err != nil {
+				t.Fatalf("coerceValue: %v", err)
+			}
+
+			got, err := tombstoneRow(ReplicationEvent{PrimaryKey: map[string]interface{}{"id": coerced}})
+			if err != nil {
+				t.Fatalf("tombstoneRow: %v", err)
+			}
+
+			want, _ := json.Marshal(map[string]string{"id": tt.trigger})
+			if got != string(want) {
+				t.Errorf("got %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestTombstoneRowNullKey(t *testing.T) {
+	_, err := tombstoneRow(ReplicationEvent{PrimaryKey: map[string]interface{}{"id": nil}})
+	if err == nil {
+		t.Fatal("expected error for null primary key column")
+	}
+}
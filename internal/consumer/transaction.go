@@ -0,0 +1,268 @@
+package consumer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// TransactionInfo описывает место события в исходной транзакции
+type TransactionInfo struct {
+	ID    int64 `json:"id"`    // txid_current() на контуре-источнике
+	Index int   `json:"index"` // Номер события в транзакции (с 1)
+	Count int   `json:"count"` // Число событий в транзакции
+}
+
+// errTransactionIncomplete - транзакция не собралась за transaction_timeout или ее применение
+// не удалось: события применяются по отдельности
+var errTransactionIncomplete = errors.New("source transaction incomplete")
+
+// txKey идентифицирует исходную транзакцию (txid уникален только в пределах БД контура)
+type txKey struct {
+	contour  string
+	database string
+	id       int64
+}
+
+// txGroup - собираемая транзакция: события приходят из разных партиций (и топиков)
+type txGroup struct {
+	count    int
+	events   map[int]ReplicationEvent // Номер в транзакции -> событие
+	done     chan struct{}            // Закрывается, когда транзакция применена или отменена
+	err      error                    // nil - все события применены одной транзакцией
+	applying bool                     // Все события получены, транзакция применяется
+	created  time.Time
+}
+
+// txBuffer собирает события исходных транзакций и применяет каждую транзакцию атомарно,
+// когда получены все ее события. Воркеры партиций ждут применения своей транзакции.
+type txBuffer struct {
+	c       *Consumer
+	timeout time.Duration
+
+	mu     sync.Mutex
+	groups map[txKey]*txGroup
+}
+
+// newTxBuffer создает буфер транзакций
+func newTxBuffer(c *Consumer, timeout time.Duration) *txBuffer {
+	return &txBuffer{
+		c:       c,
+		timeout: timeout,
+		groups:  make(map[txKey]*txGroup),
+	}
+}
+
+// grouped определяет, нужно ли применять событие в составе исходной транзакции
+func (b *txBuffer) grouped(event ReplicationEvent) bool {
+	return b != nil && event.Transaction != nil && event.Transaction.Count > 1
+}
+
+// submit добавляет событие в его транзакцию и ждет ее применения.
+// Событие, добавленное последним, применяет всю транзакцию одной транзакцией БД.
+// errTransactionIncomplete означает, что событие нужно применить отдельно.
+func (b *txBuffer) submit(ctx context.Context, event ReplicationEvent) error {
+	// Событие уже применено (перечитано после ребалансировки) - остальных частей транзакции не будет
+	var processed int64
+	if err := b.c.db.WithContext(ctx).Model(&database.ProcessedEvent{}).
+		Where("event_id = ?", event.EventID).Count(&processed).Error; err != nil {
+		return fmt.Errorf("failed to check processed_events: %w", err)
+	}
+	if processed > 0 {
+		return nil
+	}
+
+	key := transactionKey(event)
+
+	b.mu.Lock()
+	b.expire()
+	group := b.groupFor(key, event)
+
+	// Транзакция уже применена или отменена по таймауту
+	select {
+	case <-group.done:
+		b.mu.Unlock()
+		return errTransactionIncomplete
+	default:
+	}
+
+	// Повторная доставка события, пока транзакция применяется
+	if group.applying {
+		b.mu.Unlock()
+		return b.wait(ctx, key, group)
+	}
+
+	group.events[event.Transaction.Index] = event
+	if len(group.events) < group.count {
+		b.mu.Unlock()
+		return b.wait(ctx, key, group)
+	}
+
+	// Все события получены - применяем транзакцию в порядке событий источника
+	group.applying = true
+	indexes := make([]int, 0, len(group.events))
+	for index := range group.events {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+
+	events := make([]ReplicationEvent, 0, len(indexes))
+	for _, index := range indexes {
+		events = append(events, group.events[index])
+	}
+	b.mu.Unlock()
+
+	return b.apply(ctx, key, group, events)
+}
+
+// register заранее добавляет события в их транзакции, не дожидаясь применения.
+// Иначе воркер ждал бы событие своей же транзакции, стоящее в батче за текущим, до таймаута.
+func (b *txBuffer) register(events []ReplicationEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.expire()
+	for _, event := range events {
+		group := b.groupFor(transactionKey(event), event)
+		select {
+		case <-group.done:
+			continue
+		default:
+		}
+		if !group.applying {
+			group.events[event.Transaction.Index] = event
+		}
+	}
+}
+
+// transactionKey возвращает ключ исходной транзакции события
+func transactionKey(event ReplicationEvent) txKey {
+	return txKey{contour: event.Source.Contour, database: event.Source.Database, id: event.Transaction.ID}
+}
+
+// groupFor возвращает собираемую транзакцию, создавая ее при первом событии. Вызывается под b.mu.
+func (b *txBuffer) groupFor(key txKey, event ReplicationEvent) *txGroup {
+	group, ok := b.groups[key]
+	if !ok {
+		group = &txGroup{
+			count:   event.Transaction.Count,
+			events:  make(map[int]ReplicationEvent, event.Transaction.Count),
+			done:    make(chan struct{}),
+			created: time.Now(),
+		}
+		b.groups[key] = group
+	}
+	return group
+}
+
+// apply применяет собранную транзакцию и сообщает результат ожидающим воркерам
+func (b *txBuffer) apply(ctx context.Context, key txKey, group *txGroup, events []ReplicationEvent) error {
+	err := b.c.applyEvents(ctx, events)
+	if err != nil {
+		if isTimeout(err) && ctx.Err() == nil {
+			b.c.reportTimeout(ctx, err, batchTables(events)...)
+		}
+		b.c.logger.Warn().
+			Err(err).
+			Str("source_contour", key.contour).
+			Int64("tx_id", key.id).
+			Int("events", len(events)).
+			Msg("Failed to apply source transaction, falling back to per-event apply")
+		err = fmt.Errorf("%w: %v", errTransactionIncomplete, err)
+	} else {
+		b.c.logger.Debug().
+			Str("source_contour", key.contour).
+			Int64("tx_id", key.id).
+			Int("events", len(events)).
+			Msg("Source transaction applied atomically")
+	}
+
+	b.mu.Lock()
+	group.finish(err)
+	b.mu.Unlock()
+
+	return err
+}
+
+// wait ждет, пока транзакцию применит воркер ее последнего события.
+// На время ожидания слот применения освобождается: остальные события транзакции
+// могут стоять в партициях, которым слот еще не достался.
+func (b *txBuffer) wait(ctx context.Context, key txKey, group *txGroup) error {
+	b.c.releaseSlot()
+	defer b.c.acquireSlot()
+
+	timer := time.NewTimer(b.timeout - time.Since(group.created))
+	defer timer.Stop()
+
+	select {
+	case <-group.done:
+	case <-timer.C:
+		b.mu.Lock()
+		if group.applying {
+			// Транзакция уже собрана - ждем результат ее применения
+			b.mu.Unlock()
+			select {
+			case <-group.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			break
+		}
+		select {
+		case <-group.done:
+		default:
+			// Не дождались остальных событий - каждый воркер применит свое событие отдельно
+			atomic.AddInt64(&b.c.incompleteTxCount, 1)
+			b.c.logger.Warn().
+				Str("source_contour", key.contour).
+				Int64("tx_id", key.id).
+				Int("received", len(group.events)).
+				Int("expected", group.count).
+				Dur("timeout", b.timeout).
+				Msg("Source transaction incomplete, applying events separately")
+			group.finish(errTransactionIncomplete)
+		}
+		b.mu.Unlock()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return group.err
+}
+
+// expire отменяет транзакции, которые никто не ждет (воркер остановлен), и удаляет
+// завершенные транзакции, к которым больше не придут события. Вызывается под b.mu.
+func (b *txBuffer) expire() {
+	for key, group := range b.groups {
+		age := time.Since(group.created)
+		select {
+		case <-group.done:
+			// Запоздавшие события транзакции распознаются по закрытой группе, пока она хранится
+			if age > 2*b.timeout {
+				delete(b.groups, key)
+			}
+		default:
+			if age > b.timeout && !group.applying {
+				group.finish(errTransactionIncomplete)
+			}
+		}
+	}
+}
+
+// finish завершает транзакцию с результатом err (однократно). Вызывается под b.mu.
+func (g *txGroup) finish(err error) {
+	select {
+	case <-g.done:
+	default:
+		g.err = err
+		close(g.done)
+	}
+}
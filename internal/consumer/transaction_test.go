@@ -0,0 +1,110 @@
+package consumer
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func txEvent(id int64, index, count int) ReplicationEvent {
+	return ReplicationEvent{
+		EventID:     fmt.Sprintf("tx%d-%d", id, index),
+		Source:      SourceInfo{Contour: "A", Database: "db"},
+		Transaction: &TransactionInfo{ID: id, Index: index, Count: count},
+	}
+}
+
+func TestTxBufferGrouped(t *testing.T) {
+	var nilBuffer *txBuffer
+	if nilBuffer.grouped(txEvent(1, 1, 2)) {
+		t.Error("disabled buffer must not group events")
+	}
+
+	b := newTxBuffer(nil, time.Second)
+	if b.grouped(ReplicationEvent{}) {
+		t.Error("event without transaction info must not be grouped")
+	}
+	if b.grouped(txEvent(1, 1, 1)) {
+		t.Error("single-event transaction must not be grouped")
+	}
+	if !b.grouped(txEvent(1, 1, 2)) {
+		t.Error("multi-event transaction must be grouped")
+	}
+}
+
+func TestTxBufferRegisterCollectsEvents(t *testing.T) {
+	b := newTxBuffer(nil, time.Minute)
+	b.register([]ReplicationEvent{txEvent(7, 2, 3), txEvent(7, 1, 3), txEvent(8, 1, 2)})
+
+	group := b.groups[transactionKey(txEvent(7, 1, 3))]
+	if group == nil || group.count != 3 || len(group.events) != 2 {
+		t.Fatalf("unexpected group for tx 7: %+v", group)
+	}
+	if len(b.groups) != 2 {
+		t.Errorf("expected 2 transactions, got %d", len(b.groups))
+	}
+
+	// Одинаковый txid на другом контуре - другая транзакция
+	other := txEvent(7, 3, 3)
+	other.Source.Contour = "B"
+	if transactionKey(other) == transactionKey(txEvent(7, 3, 3)) {
+		t.Error("transactions of different contours must not share a key")
+	}
+}
+
+func TestTxBufferRegisterSkipsFinishedGroups(t *testing.T) {
+	b := newTxBuffer(nil, time.Minute)
+	b.register([]ReplicationEvent{txEvent(1, 1, 2)})
+
+	group := b.groups[transactionKey(txEvent(1, 1, 2))]
+	group.finish(nil)
+
+	b.register([]ReplicationEvent{txEvent(1, 2, 2)})
+	if len(group.events) != 1 {
+		t.Errorf("event added to an already applied transaction")
+	}
+}
+
+func TestTxBufferExpire(t *testing.T) {
+	b := newTxBuffer(nil, time.Minute)
+	b.register([]ReplicationEvent{txEvent(1, 1, 2), txEvent(2, 1, 2), txEvent(3, 1, 2), txEvent(4, 1, 2)})
+
+	stale := b.groups[transactionKey(txEvent(1, 1, 2))]
+	stale.created = time.Now().Add(-2 * time.Minute)
+
+	applying := b.groups[transactionKey(txEvent(2, 1, 2))]
+	applying.created = time.Now().Add(-2 * time.Minute)
+	applying.applying = true
+
+	finished := b.groups[transactionKey(txEvent(3, 1, 2))]
+	finished.created = time.Now().Add(-3 * time.Minute)
+	finished.finish(nil)
+
+	b.expire()
+
+	if !errors.Is(stale.err, errTransactionIncomplete) {
+		t.Errorf("stale transaction: err = %v, want errTransactionIncomplete", stale.err)
+	}
+	select {
+	case <-applying.done:
+		t.Error("transaction being applied must not be canceled")
+	default:
+	}
+	if _, ok := b.groups[transactionKey(txEvent(3, 1, 2))]; ok {
+		t.Error("finished transaction must be removed after 2*timeout")
+	}
+	if _, ok := b.groups[transactionKey(txEvent(4, 1, 2))]; !ok {
+		t.Error("fresh transaction must be kept")
+	}
+}
+
+func TestTxGroupFinishOnce(t *testing.T) {
+	group := &txGroup{done: make(chan struct{})}
+	group.finish(nil)
+	group.finish(errTransactionIncomplete)
+
+	if group.err != nil {
+		t.Errorf("second finish overwrote result: %v", group.err)
+	}
+}
@@ -0,0 +1,213 @@
+package consumer
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Режимы применения событий
+const (
+	ApplyModeSelect = "select" // SELECT version, затем INSERT/UPDATE/DELETE (по умолчанию)
+	ApplyModeUpsert = "upsert" // Один INSERT ... ON CONFLICT с проверкой версии на стороне БД
+)
+
+// upsertOutcome - результат upsert по данным RETURNING
+type upsertOutcome int
+
+const (
+	upsertInserted upsertOutcome = iota // Строки не было - вставлена
+	upsertUpdated                       // Строка обновлена более новой версией
+	upsertSkipped                       // Строка уже есть и не изменена (устаревшая версия или политика)
+)
+
+// applyUpsert применяет INSERT/UPDATE одним запросом INSERT ... ON CONFLICT (pk) DO UPDATE,
+// который обновляет строку только если входящее изменение новее (по политике конфликтов таблицы)
+func (a *EventApplier) applyUpsert(tx *gorm.DB, table *tableInfo, event ReplicationEvent) error {
+	if event.After == nil {
+		return fmt.Errorf("%s event must have 'after' data", event.Operation)
+	}
+
+	policy := a.policyFor(table)
+
+	// Смена primary key: ON CONFLICT по новому ключу не найдет старую строку
+	if event.Operation == "UPDATE" && primaryKeyChanged(event) {
+		a.logger.Debug().
+			Str("table", event.Table).
+			Interface("primary_key", event.PrimaryKey).
+			Msg("Primary key changed, applying UPDATE without upsert")
+		return a.applyUpdate(tx, table, event)
+	}
+
+	// merge сравнивает локальную строку с образом до изменения по колонкам - нужен SELECT
+	if event.Operation == "UPDATE" && policy.Strategy == ConflictMerge {
+		return a.applyUpdate(tx, table, event)
+	}
+
+	// Ключ удален более новой версией - upsert не должен воскресить строку
+	suppressed, err := a.suppressedByTombstone(tx, table, event)
+	if err != nil || suppressed {
+		return err
+	}
+
+	incomingVersion := event.GetVersion()
+
+	a.logger.Debug().
+		Str("table", event.Table).
+		Interface("primary_key", event.PrimaryKey).
+		Int64("version", incomingVersion).
+		Msgf("Applying %s as upsert", event.Operation)
+
+	// При конфликте INSERT с политиками skip/error существующую строку не трогаем
+	updateOnConflict := event.Operation == "UPDATE" || policy.effective().comparesRows()
+
+	outcome, err := a.execUpsert(tx, table, event, policy, updateOnConflict)
+	if err != nil {
+		return err
+	}
+
+	switch outcome {
+	case upsertInserted:
+		a.logger.Debug().
+			Str("table", event.Table).
+			Interface("primary_key", event.PrimaryKey).
+			Msg("Upsert applied: row inserted")
+		return nil
+
+	case upsertUpdated:
+		a.logger.Debug().
+			Str("table", event.Table).
+			Interface("primary_key", event.PrimaryKey).
+			Int64("new_version", incomingVersion).
+			Msg("Upsert applied: row updated")
+		return nil
+	}
+
+	// Строка не изменена - читаем ее версию для логирования и разрешения конфликта
+	existing, _, err := a.fetchExisting(tx, table, event, policy)
+	if err != nil {
+		return err
+	}
+
+	if event.Operation == "INSERT" {
+		return a.resolveConflict(tx, table, event, existing, incomingVersion, event.After)
+	}
+	return a.handleVersionConflict(tx, table, event, existing.Version, incomingVersion)
+}
+
+// execUpsert выполняет INSERT ... ON CONFLICT и определяет результат по RETURNING
+func (a *EventApplier) execUpsert(tx *gorm.DB, table *tableInfo, event ReplicationEvent, policy ConflictPolicy, updateOnConflict bool) (upsertOutcome, error) {
+	sql, values := a.buildUpsertSQL(table, event, policy, updateOnConflict)
+
+	var inserted []bool
+	result := tx.Raw(sql, values...).Scan(&inserted)
+	if result.Error != nil {
+		return 0, fmt.Errorf("failed to upsert: %w", result.Error)
+	}
+
+	switch {
+	case len(inserted) == 0:
+		return upsertSkipped, nil
+	case inserted[0]:
+		return upsertInserted, nil
+	default:
+		return upsertUpdated, nil
+	}
+}
+
+// buildUpsertSQL строит INSERT ... ON CONFLICT (pk) с обновлением только более новым изменением
+func (a *EventApplier) buildUpsertSQL(table *tableInfo, event ReplicationEvent, policy ConflictPolicy, updateOnConflict bool) (string, []interface{}) {
+	keyColumns := event.GetPrimaryKeyColumns()
+	isKey := make(map[string]bool, len(keyColumns))
+	conflictTarget := make([]string, 0, len(keyColumns))
+	for _, column := range keyColumns {
+		isKey[column] = true
+		conflictTarget = append(conflictTarget, quoteIdentifier(column))
+	}
+
+	columns, values := a.buildInsertSQL(event.After)
+
+	var onConflict string
+	if updateOnConflict {
+		setClauses := make([]string, 0, len(event.After))
+		for column := range event.After {
+			if isKey[column] {
+				continue
+			}
+			quoted := quoteIdentifier(column)
+			setClauses = append(setClauses, fmt.Sprintf("%s = EXCLUDED.%s", quoted, quoted))
+		}
+		onConflict = fmt.Sprintf("DO UPDATE SET %s WHERE %s",
+			strings.Join(setClauses, ", "),
+			policy.upsertCondition(event.Source.Contour, a.config.MyContour))
+	} else {
+		onConflict = "DO NOTHING"
+	}
+
+	// xmax = 0 у только что вставленной строки, у обновленной - id текущей транзакции
+	sql := fmt.Sprintf("INSERT INTO %s AS target (%s) VALUES (%s) ON CONFLICT (%s) %s RETURNING (xmax = 0) AS inserted",
+		table.QuotedName,
+		strings.Join(columns, ", "),
+		strings.Join(makePlaceholders(len(values)), ", "),
+		strings.Join(conflictTarget, ", "),
+		onConflict,
+	)
+
+	return sql, values
+}
+
+// applyGuardedDelete удаляет строку одним запросом, только если она не новее удаляемой
+// (по колонке сравнения политики конфликтов)
+func (a *EventApplier) applyGuardedDelete(tx *gorm.DB, table *tableInfo, event ReplicationEvent) error {
+	policy := a.policyFor(table)
+
+	// Без колонки сравнения в образе строки проверять нечего
+	if _, ok := event.Before[policy.Column]; !ok {
+		return a.applyDelete(tx, table, event)
+	}
+
+	incomingVersion := event.GetVersion()
+	whereClause, whereValues := buildPrimaryKeyWhere(event)
+	whereValues = append(whereValues, policy.incomingValue(event))
+
+	column := quoteIdentifier(policy.Column)
+	sql := fmt.Sprintf("DELETE FROM %s WHERE %s AND (%s <= ? OR %s IS NULL)", table.QuotedName, whereClause, column, column)
+	result := tx.Exec(sql, whereValues...)
+	if result.Error != nil {
+		return fmt.Errorf("failed to delete: %w", result.Error)
+	}
+
+	if result.RowsAffected > 0 {
+		a.logger.Debug().
+			Str("table", event.Table).
+			Interface("primary_key", event.PrimaryKey).
+			Msg("DELETE applied")
+		return a.recordTombstone(tx, table, event)
+	}
+
+	// Строка не удалена: ее нет (идемпотентность) или локальная версия новее
+	existingVersion, exists, err := a.fetchExistingVersion(tx, table, event)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		a.logger.Debug().
+			Str("table", event.Table).
+			Interface("primary_key", event.PrimaryKey).
+			Msg("DELETE on non-existing record (already deleted)")
+		return a.recordTombstone(tx, table, event)
+	}
+
+	return a.handleVersionConflict(tx, table, event, existingVersion, incomingVersion)
+}
+
+// primaryKeyChanged определяет, изменился ли primary key строки в UPDATE
+func primaryKeyChanged(event ReplicationEvent) bool {
+	for _, column := range event.GetPrimaryKeyColumns() {
+		if fmt.Sprint(event.PrimaryKey[column]) != fmt.Sprint(event.After[column]) {
+			return true
+		}
+	}
+	return false
+}
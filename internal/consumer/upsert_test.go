@@ -0,0 +1,69 @@
+package consumer
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBuildUpsertSQLUpdatesOnlyNewerVersion(t *testing.T) {
+	a := &EventApplier{}
+	table := newTestTable("order_id", "item_id", "version")
+	table.PrimaryKey = []string{"order_id", "item_id"}
+	event := ReplicationEvent{
+		Operation:         "UPDATE",
+		PrimaryKeyColumns: []string{"order_id", "item_id"},
+		PrimaryKey:        map[string]interface{}{"order_id": 10, "item_id": 3},
+		After:             map[string]interface{}{"order_id": 10, "item_id": 3, "version": 5},
+	}
+
+	sql, values := a.buildUpsertSQL(table, event, ConflictPolicy{}.normalize(), true)
+
+	wantParts := []string{
+		`INSERT INTO "public"."users" AS target (`,
+		`ON CONFLICT ("order_id", "item_id") DO UPDATE SET "version" = EXCLUDED."version" WHERE target."version" < EXCLUDED."version" OR target."version" IS NULL`,
+		`RETURNING (xmax = 0) AS inserted`,
+	}
+	for _, part := range wantParts {
+		if !strings.Contains(sql, part) {
+			t.Errorf("sql %q does not contain %q", sql, part)
+		}
+	}
+	// Колонки ключа не попадают в SET
+	if strings.Contains(sql, `"order_id" = EXCLUDED`) {
+		t.Errorf("primary key column must not be updated: %s", sql)
+	}
+	if len(values) != 3 {
+		t.Errorf("expected 3 values, got %v", values)
+	}
+}
+
+func TestBuildUpsertSQLDoNothing(t *testing.T) {
+	a := &EventApplier{}
+	event := ReplicationEvent{
+		Operation:  "INSERT",
+		PrimaryKey: map[string]interface{}{"id": 1},
+		After:      map[string]interface{}{"id": 1},
+	}
+
+	sql, _ := a.buildUpsertSQL(newTestTable("id", "version"), event, ConflictPolicy{}.normalize(), false)
+
+	want := `INSERT INTO "public"."users" AS target ("id") VALUES (?) ON CONFLICT ("id") DO NOTHING RETURNING (xmax = 0) AS inserted`
+	if sql != want {
+		t.Errorf("sql = %q\nwant  %q", sql, want)
+	}
+}
+
+func TestPrimaryKeyChanged(t *testing.T) {
+	event := ReplicationEvent{
+		PrimaryKey: map[string]interface{}{"id": 1},
+		After:      map[string]interface{}{"id": 1, "name": "a"},
+	}
+	if primaryKeyChanged(event) {
+		t.Error("key did not change")
+	}
+
+	event.After["id"] = 2
+	if !primaryKeyChanged(event) {
+		t.Error("key changed from 1 to 2")
+	}
+}
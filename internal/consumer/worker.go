@@ -0,0 +1,309 @@
+package consumer
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+)
+
+// offsetCommitInterval - как часто коммитить примененные offsets партиций
+const offsetCommitInterval = time.Second
+
+// partitionKey идентифицирует партицию топика
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+// partitionWorker последовательно применяет сообщения одной партиции,
+// сохраняя порядок изменений каждого ключа
+type partitionWorker struct {
+	key      partitionKey
+	messages chan *kafka.Message
+	handle   func(ctx context.Context, messages []*kafka.Message) int // Consumer.handleBatch
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+
+	// applied - последний offset непрерывно примененного префикса (-1 - ничего не применено).
+	// Пишется воркером, читается основным циклом.
+	applied int64
+
+	// Поля ниже принадлежат основному циклу (Poll)
+	committed int64            // Последний закоммиченный offset
+	overflow  []*kafka.Message // Сообщения, не поместившиеся в очередь воркера
+	paused    bool             // Партиция на паузе, пока overflow не разобран
+}
+
+// workerPool раздает сообщения воркерам партиций и коммитит примененные offsets.
+// Все методы вызываются только из основного цикла Consumer (в т.ч. из rebalance callback внутри Poll).
+type workerPool struct {
+	c       *Consumer
+	workers map[partitionKey]*partitionWorker
+	slots   chan struct{} // Ограничивает число одновременно применяющих воркеров
+}
+
+// newWorkerPool создает пул воркеров партиций
+func newWorkerPool(c *Consumer) *workerPool {
+	maxWorkers := c.config.MaxWorkers
+	if maxWorkers < 1 {
+		maxWorkers = 1
+	}
+
+	slots := make(chan struct{}, maxWorkers)
+	c.slots = slots
+
+	return &workerPool{
+		c:       c,
+		workers: make(map[partitionKey]*partitionWorker),
+		slots:   slots,
+	}
+}
+
+// dispatch передает сообщение воркеру его партиции.
+// Если очередь воркера заполнена, партиция ставится на паузу, а сообщение откладывается.
+func (p *workerPool) dispatch(ctx context.Context, message *kafka.Message) {
+	p.flushOverflow()
+
+	if message == nil {
+		return
+	}
+
+	worker := p.workerFor(ctx, message.TopicPartition)
+
+	// Сохраняем порядок: пока есть отложенные сообщения, новые встают за ними
+	if len(worker.overflow) == 0 {
+		select {
+		case worker.messages <- message:
+			return
+		default:
+		}
+	}
+
+	worker.overflow = append(worker.overflow, message)
+	if !worker.paused {
+		if err := p.c.consumer.Pause(message.TopicPartition); err != nil {
+			p.c.logger.Error().Err(err).Msg("Failed to pause partition")
+			return
+		}
+		worker.paused = true
+	}
+}
+
+// flushOverflow досылает отложенные сообщения и снимает партиции с паузы
+func (p *workerPool) flushOverflow() {
+	for _, worker := range p.workers {
+		worker.flush()
+
+		if worker.paused && len(worker.overflow) == 0 {
+			topic := worker.key.topic
+			err := p.c.consumer.Resume(kafka.TopicPartition{Topic: &topic, Partition: worker.key.partition})
+			if err != nil {
+				p.c.logger.Error().Err(err).Msg("Failed to resume partition")
+				continue
+			}
+			worker.paused = false
+		}
+	}
+}
+
+// workerFor возвращает воркер партиции, создавая его при первом сообщении
+func (p *workerPool) workerFor(ctx context.Context, tp kafka.TopicPartition) *partitionWorker {
+	key := partitionKey{topic: *tp.Topic, partition: tp.Partition}
+	if worker, ok := p.workers[key]; ok {
+		return worker
+	}
+
+	queueSize := p.c.config.PartitionQueueSize
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	workerCtx, cancel := context.WithCancel(ctx)
+	worker := &partitionWorker{
+		key:       key,
+		messages:  make(chan *kafka.Message, queueSize),
+		handle:    p.c.handleBatch,
+		ctx:       workerCtx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		applied:   -1,
+		committed: -1,
+	}
+	p.workers[key] = worker
+
+	go worker.run(p.c, p.slots)
+
+	p.c.logger.Debug().
+		Str("topic", key.topic).
+		Int32("partition", key.partition).
+		Msg("Partition worker started")
+
+	return worker
+}
+
+// commitApplied коммитит offsets, примененные воркерами с прошлого коммита
+func (p *workerPool) commitApplied() {
+	for _, worker := range p.workers {
+		p.commitWorker(worker)
+	}
+}
+
+// commitWorker коммитит непрерывно примененный префикс партиции
+func (p *workerPool) commitWorker(worker *partitionWorker) {
+	applied := atomic.LoadInt64(&worker.applied)
+	if applied <= worker.committed {
+		return
+	}
+
+	if err := p.c.consumer.CommitOffset(worker.key.topic, worker.key.partition, kafka.Offset(applied)); err != nil {
+		p.c.logger.Error().
+			Err(err).
+			Str("topic", worker.key.topic).
+			Int32("partition", worker.key.partition).
+			Msg("Failed to commit offset")
+		return
+	}
+	worker.committed = applied
+}
+
+// revoke останавливает воркеры отозванных партиций и коммитит примененные offsets.
+// Вызывается из rebalance callback до того, как партиции будут переданы другому consumer.
+func (p *workerPool) revoke(partitions []kafka.TopicPartition) {
+	for _, tp := range partitions {
+		if tp.Topic == nil {
+			continue
+		}
+		key := partitionKey{topic: *tp.Topic, partition: tp.Partition}
+		if worker, ok := p.workers[key]; ok {
+			p.stop(worker)
+		}
+	}
+}
+
+// stopAll останавливает все воркеры (при остановке сервиса)
+func (p *workerPool) stopAll() {
+	for _, worker := range p.workers {
+		p.stop(worker)
+	}
+}
+
+// stop останавливает воркер: новые сообщения не принимаются, незавершенное применение
+// отменяется (транзакция откатывается), примененный префикс коммитится
+func (p *workerPool) stop(worker *partitionWorker) {
+	close(worker.messages)
+	worker.cancel()
+	<-worker.done
+
+	p.commitWorker(worker)
+	delete(p.workers, worker.key)
+
+	p.c.logger.Debug().
+		Str("topic", worker.key.topic).
+		Int32("partition", worker.key.partition).
+		Int64("committed_offset", worker.committed).
+		Msg("Partition worker stopped")
+}
+
+// flush досылает отложенные сообщения в очередь воркера, пока в ней есть место
+func (w *partitionWorker) flush() {
+	for len(w.overflow) > 0 {
+		select {
+		case w.messages <- w.overflow[0]:
+			w.overflow[0] = nil
+			w.overflow = w.overflow[1:]
+		default:
+			return
+		}
+	}
+}
+
+// run последовательно применяет сообщения партиции батчами
+func (w *partitionWorker) run(c *Consumer, slots chan struct{}) {
+	defer close(w.done)
+
+	// Offset, на который перемотана партиция после неудачи (-1 - перемотки нет).
+	// Сообщения, прочитанные до перемотки, пропускаются до повторного прихода этого offset.
+	rewindOffset := kafka.Offset(-1)
+
+	for {
+		batch, open := w.collect(c.config.BatchSize, c.config.BatchMaxWait, &rewindOffset)
+		if len(batch) > 0 {
+			// Ждем свободный слот применения к БД
+			select {
+			case slots <- struct{}{}:
+			case <-w.ctx.Done():
+				return
+			}
+
+			handled := w.handle(w.ctx, batch)
+			<-slots
+
+			if handled > 0 {
+				atomic.StoreInt64(&w.applied, int64(batch[handled-1].TopicPartition.Offset))
+			}
+			if handled < len(batch) && w.ctx.Err() == nil {
+				rewindOffset = batch[handled].TopicPartition.Offset
+			}
+		}
+
+		if !open || w.ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// collect собирает батч: ждет первое сообщение, затем добирает до batchSize в течение maxWait.
+// Возвращает false, если очередь закрыта или воркер остановлен.
+func (w *partitionWorker) collect(batchSize int, maxWait time.Duration, rewindOffset *kafka.Offset) ([]*kafka.Message, bool) {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+
+	var batch []*kafka.Message
+	var deadline <-chan time.Time
+
+	for len(batch) < batchSize {
+		var message *kafka.Message
+		var ok bool
+
+		if len(batch) == 0 {
+			select {
+			case message, ok = <-w.messages:
+			case <-w.ctx.Done():
+				return nil, false
+			}
+		} else {
+			select {
+			case message, ok = <-w.messages:
+			case <-deadline:
+				return batch, true
+			case <-w.ctx.Done():
+				return nil, false
+			}
+		}
+
+		if !ok {
+			return batch, false
+		}
+
+		offset := message.TopicPartition.Offset
+		if *rewindOffset >= 0 {
+			if offset != *rewindOffset {
+				continue
+			}
+			*rewindOffset = -1
+		}
+
+		batch = append(batch, message)
+		if len(batch) == 1 && batchSize > 1 {
+			timer := time.NewTimer(maxWait)
+			defer timer.Stop()
+			deadline = timer.C
+		}
+	}
+
+	return batch, true
+}
@@ -0,0 +1,100 @@
+package consumer
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
+	"github.com/rs/zerolog"
+)
+
+// runWorker прогоняет сообщения с указанными offsets через воркер партиции
+// и возвращает offsets батчей, переданных в handle
+func runWorker(t *testing.T, batchSize int, offsets []kafka.Offset, handle func(batch []*kafka.Message) int) (*partitionWorker, [][]kafka.Offset) {
+	t.Helper()
+
+	topic := "users_changes"
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var batches [][]kafka.Offset
+	worker := &partitionWorker{
+		messages: make(chan *kafka.Message, len(offsets)),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		applied:  -1,
+		handle: func(ctx context.Context, messages []*kafka.Message) int {
+			batch := make([]kafka.Offset, len(messages))
+			for i, message := range messages {
+				batch[i] = message.TopicPartition.Offset
+			}
+			batches = append(batches, batch)
+			return handle(messages)
+		},
+	}
+	for _, offset := range offsets {
+		worker.messages <- &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Offset: offset}}
+	}
+	close(worker.messages)
+
+	c := &Consumer{config: Config{BatchSize: batchSize, BatchMaxWait: time.Millisecond}, logger: zerolog.Nop()}
+	worker.run(c, make(chan struct{}, 1))
+
+	return worker, batches
+}
+
+func TestPartitionWorkerRewindsToFailedOffset(t *testing.T) {
+	failed := false
+	// Первое применение offset 3 не удается - партиция перематывается на него,
+	// уже прочитанные 4 и 5 пропускаются до повторного прихода 3
+	worker, batches := runWorker(t, 1, []kafka.Offset{1, 2, 3, 4, 5, 3, 4, 5}, func(batch []*kafka.Message) int {
+		if batch[0].TopicPartition.Offset == 3 && !failed {
+			failed = true
+			return 0
+		}
+		return len(batch)
+	})
+
+	want := [][]kafka.Offset{{1}, {2}, {3}, {3}, {4}, {5}}
+	if !reflect.DeepEqual(batches, want) {
+		t.Errorf("batches %v, want %v", batches, want)
+	}
+	if applied := atomic.LoadInt64(&worker.applied); applied != 5 {
+		t.Errorf("applied offset %d, want 5", applied)
+	}
+}
+
+func TestPartitionWorkerCommitsHandledPrefix(t *testing.T) {
+	// Из батча 5..8 применены только 5 и 6: коммитится непрерывный префикс,
+	// 8 не коммитится, пока не применен 7
+	worker, _ := runWorker(t, 4, []kafka.Offset{5, 6, 7, 8}, func(batch []*kafka.Message) int {
+		return 2
+	})
+
+	if applied := atomic.LoadInt64(&worker.applied); applied != 6 {
+		t.Errorf("applied offset %d, want 6", applied)
+	}
+}
+
+func TestPartitionWorkerFlushKeepsOrder(t *testing.T) {
+	topic := "users_changes"
+	worker := &partitionWorker{messages: make(chan *kafka.Message, 2)}
+	for offset := kafka.Offset(1); offset <= 3; offset++ {
+		worker.overflow = append(worker.overflow, &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Offset: offset}})
+	}
+
+	worker.flush()
+
+	if len(worker.overflow) != 1 || worker.overflow[0].TopicPartition.Offset != 3 {
+		t.Fatalf("overflow should keep the last message, got %d messages", len(worker.overflow))
+	}
+	for want := kafka.Offset(1); want <= 2; want++ {
+		if got := (<-worker.messages).TopicPartition.Offset; got != want {
+			t.Errorf("queued offset %d, want %d", got, want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// LoadPrimaryKeyColumns возвращает колонки primary key таблицы в порядке их объявления (из pg_index)
+func LoadPrimaryKeyColumns(ctx context.Context, db *gorm.DB, schema, tableName string) ([]string, error) {
+	var columns []string
+	result := db.WithContext(ctx).Raw(`
+		SELECT a.attname
+		FROM pg_index i
+		JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+		WHERE i.indrelid = to_regclass(quote_ident(?) || '.' || quote_ident(?)) AND i.indisprimary
+		ORDER BY array_position(i.indkey::int2[], a.attnum)`,
+		schema, tableName,
+	).Scan(&columns)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load primary key of %s.%s: %w", schema, tableName, result.Error)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s.%s not found or has no primary key", schema, tableName)
+	}
+
+	return columns, nil
+}
+
+// Column описывает колонку таблицы (из information_schema.columns)
+type Column struct {
+	Name     string `gorm:"column:column_name"`
+	DataType string `gorm:"column:data_type"`
+	UDTName  string `gorm:"column:udt_name"`
+}
+
+// LoadColumns возвращает колонки таблицы в порядке их объявления
+func LoadColumns(ctx context.Context, db *gorm.DB, schema, tableName string) ([]Column, error) {
+	var columns []Column
+	result := db.WithContext(ctx).Raw(`
+		SELECT column_name, data_type, udt_name
+		FROM information_schema.columns
+		WHERE table_schema = ? AND table_name = ?
+		ORDER BY ordinal_position`,
+		schema, tableName,
+	).Scan(&columns)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load columns of %s.%s: %w", schema, tableName, result.Error)
+	}
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("table %s.%s not found", schema, tableName)
+	}
+
+	return columns, nil
+}
+
+// ForeignKey описывает внешний ключ таблицы (из pg_constraint)
+type ForeignKey struct {
+	Name          string
+	Columns       []string // Колонки таблицы в порядке ключа
+	ParentSchema  string
+	ParentTable   string
+	ParentColumns []string // Колонки родительской таблицы, соответствующие Columns
+}
+
+// LoadForeignKeys возвращает внешние ключи таблицы
+func LoadForeignKeys(ctx context.Context, db *gorm.DB, schema, tableName string) ([]ForeignKey, error) {
+	var rows []struct {
+		Name          string `gorm:"column:name"`
+		ParentSchema  string `gorm:"column:parent_schema"`
+		ParentTable   string `gorm:"column:parent_table"`
+		Columns       string `gorm:"column:columns"`
+		ParentColumns string `gorm:"column:parent_columns"`
+	}
+	result := db.WithContext(ctx).Raw(`
+		SELECT c.conname AS name,
+		       pn.nspname AS parent_schema,
+		       p.relname AS parent_table,
+		       (SELECT json_agg(a.attname ORDER BY k.n)
+		        FROM unnest(c.conkey) WITH ORDINALITY AS k(attnum, n)
+		        JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = k.attnum)::text AS columns,
+		       (SELECT json_agg(a.attname ORDER BY k.n)
+		        FROM unnest(c.confkey) WITH ORDINALITY AS k(attnum, n)
+		        JOIN pg_attribute a ON a.attrelid = c.confrelid AND a.attnum = k.attnum)::text AS parent_columns
+		FROM pg_constraint c
+		JOIN pg_class t ON t.oid = c.conrelid
+		JOIN pg_namespace tn ON tn.oid = t.relnamespace
+		JOIN pg_class p ON p.oid = c.confrelid
+		JOIN pg_namespace pn ON pn.oid = p.relnamespace
+		WHERE c.contype = 'f' AND tn.nspname = ? AND t.relname = ?
+		ORDER BY c.conname`,
+		schema, tableName,
+	).Scan(&rows)
+
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to load foreign keys of %s.%s: %w", schema, tableName, result.Error)
+	}
+
+	keys := make([]ForeignKey, 0, len(rows))
+	for _, row := range rows {
+		key := ForeignKey{Name: row.Name, ParentSchema: row.ParentSchema, ParentTable: row.ParentTable}
+		if err := json.Unmarshal([]byte(row.Columns), &key.Columns); err != nil {
+			return nil, fmt.Errorf("failed to decode columns of foreign key %s: %w", row.Name, err)
+		}
+		if err := json.Unmarshal([]byte(row.ParentColumns), &key.ParentColumns); err != nil {
+			return nil, fmt.Errorf("failed to decode parent columns of foreign key %s: %w", row.Name, err)
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
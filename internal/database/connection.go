@@ -26,17 +26,27 @@ type Config struct {
 	ApplicationName string // Для защиты от петли репликации
 }
 
-// Connect устанавливает соединение с PostgreSQL через GORM
-func Connect(cfg Config, log zerolog.Logger) (*gorm.DB, error) {
-	// Формируем DSN
+// DSN формирует строку подключения к PostgreSQL
+func (cfg Config) DSN() string {
 	dsn := fmt.Sprintf(
 		"host=%s port=%d dbname=%s user=%s password=%s sslmode=%s",
 		cfg.Host, cfg.Port, cfg.Database, cfg.User, cfg.Password, cfg.SSLMode,
 	)
-	
+
 	// Добавляем application_name если указан
 	if cfg.ApplicationName != "" {
 		dsn += fmt.Sprintf(" application_name=%s", cfg.ApplicationName)
+	}
+
+	return dsn
+}
+
+// Connect устанавливает соединение с PostgreSQL через GORM
+func Connect(cfg Config, log zerolog.Logger) (*gorm.DB, error) {
+	// Формируем DSN
+	dsn := cfg.DSN()
+	
+	if cfg.ApplicationName != "" {
 		log.Info().Str("application_name", cfg.ApplicationName).Msg("Using custom application_name")
 	}
 
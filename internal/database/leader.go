@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+)
+
+// leaderApplicationPrefix - префикс application_name сессии блокировки: по нему в pg_stat_activity
+// видно, какой экземпляр держит блокировку
+const leaderApplicationPrefix = "replicator_leader:"
+
+// maxApplicationNameLength - application_name длиннее 63 байт PostgreSQL обрезает
+const maxApplicationNameLength = 63
+
+// LeaderLock держит advisory lock на выделенном соединении PostgreSQL.
+// Соединение не берется из пула GORM: session-level advisory lock привязан к сессии
+// и освобождается сервером при ее обрыве.
+type LeaderLock struct {
+	cfg      Config
+	name     string
+	identity string
+	key      int64
+	conn     *pgx.Conn
+	logger   zerolog.Logger
+}
+
+// NewLeaderLock создает блокировку name для экземпляра identity.
+// Ключ advisory lock вычисляется из name, поэтому экземпляры с одним name конкурируют между собой.
+func NewLeaderLock(cfg Config, name, identity string, logger zerolog.Logger) *LeaderLock {
+	hash := fnv.New64a()
+	hash.Write([]byte(name))
+
+	// application_name подставляется в DSN без кавычек
+	applicationName := leaderApplicationPrefix + strings.Map(func(r rune) rune {
+		if r == ' ' || r == '\'' || r == '\\' || r == '\t' {
+			return '_'
+		}
+		return r
+	}, identity)
+	if len(applicationName) > maxApplicationNameLength {
+		applicationName = applicationName[:maxApplicationNameLength]
+	}
+	cfg.ApplicationName = applicationName
+
+	return &LeaderLock{
+		cfg:      cfg,
+		name:     name,
+		identity: identity,
+		key:      int64(hash.Sum64()),
+		logger:   logger.With().Str("component", "leader_lock").Str("lock", name).Logger(),
+	}
+}
+
+// Identity возвращает идентификатор экземпляра
+func (l *LeaderLock) Identity() string {
+	return l.identity
+}
+
+// TryAcquire пытается захватить блокировку (pg_try_advisory_lock), подключаясь при необходимости.
+// false без ошибки - блокировку держит другой экземпляр.
+func (l *LeaderLock) TryAcquire(ctx context.Context) (bool, error) {
+	if l.conn == nil {
+		conn, err := pgx.Connect(ctx, l.cfg.DSN())
+		if err != nil {
+			return false, fmt.Errorf("failed to connect leader lock session: %w", err)
+		}
+		l.conn = conn
+	}
+
+	var acquired bool
+	if err := l.conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", l.key).Scan(&acquired); err != nil {
+		l.Close()
+		return false, fmt.Errorf("failed to try advisory lock: %w", err)
+	}
+
+	return acquired, nil
+}
+
+// Check проверяет, что сессия жива и блокировка по-прежнему удерживается.
+// Ошибка означает потерю блокировки: сессия закрывается, захват нужно повторить.
+func (l *LeaderLock) Check(ctx context.Context) error {
+	if l.conn == nil {
+		return fmt.Errorf("leader lock session is not connected")
+	}
+
+	classID, objID := l.lockIDs()
+	var held bool
+	err := l.conn.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM pg_locks
+			WHERE locktype = 'advisory' AND granted AND pid = pg_backend_pid()
+			  AND classid = $1::bigint::oid AND objid = $2::bigint::oid AND objsubid = 1
+		)`, classID, objID).Scan(&held)
+	if err != nil {
+		l.Close()
+		return fmt.Errorf("failed to check advisory lock: %w", err)
+	}
+	if !held {
+		l.Close()
+		return fmt.Errorf("advisory lock is no longer held")
+	}
+
+	return nil
+}
+
+// Holder возвращает идентификатор экземпляра, который держит блокировку ("" - блокировка свободна).
+// Использует соединение блокировки, поэтому вызывается из той же горутины, что и TryAcquire.
+func (l *LeaderLock) Holder(ctx context.Context) (string, error) {
+	if l.conn == nil {
+		return "", fmt.Errorf("leader lock session is not connected")
+	}
+
+	classID, objID := l.lockIDs()
+	var applicationName string
+	err := l.conn.QueryRow(ctx, `
+		SELECT a.application_name
+		FROM pg_locks l
+		JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted
+		  AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+		  AND l.classid = $1::bigint::oid AND l.objid = $2::bigint::oid AND l.objsubid = 1
+		LIMIT 1`, classID, objID).Scan(&applicationName)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to query lock holder: %w", err)
+	}
+
+	return strings.TrimPrefix(applicationName, leaderApplicationPrefix), nil
+}
+
+// Release освобождает блокировку и закрывает сессию
+func (l *LeaderLock) Release(ctx context.Context) {
+	if l.conn == nil {
+		return
+	}
+
+	if _, err := l.conn.Exec(ctx, "SELECT pg_advisory_unlock($1)", l.key); err != nil {
+		l.logger.Debug().Err(err).Msg("Failed to release advisory lock")
+	}
+	l.Close()
+}
+
+// Close закрывает сессию (сервер освобождает блокировку вместе с ней)
+func (l *LeaderLock) Close() {
+	if l.conn == nil {
+		return
+	}
+
+	if err := l.conn.Close(context.Background()); err != nil {
+		l.logger.Debug().Err(err).Msg("Failed to close leader lock session")
+	}
+	l.conn = nil
+}
+
+// lockIDs возвращает ключ блокировки в виде classid и objid из pg_locks
+// (старшие и младшие 32 бита bigint ключа)
+func (l *LeaderLock) lockIDs() (int64, int64) {
+	key := uint64(l.key)
+	return int64(key >> 32), int64(key & 0xffffffff)
+}
@@ -0,0 +1,73 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/rs/zerolog"
+)
+
+// Listener держит выделенное соединение PostgreSQL с LISTEN на канал уведомлений.
+// Соединение не берется из пула GORM, так как LISTEN привязан к сессии.
+type Listener struct {
+	cfg     Config
+	channel string
+	conn    *pgx.Conn
+	logger  zerolog.Logger
+}
+
+// NewListener создает Listener для канала channel
+func NewListener(cfg Config, channel string, logger zerolog.Logger) *Listener {
+	return &Listener{
+		cfg:     cfg,
+		channel: channel,
+		logger:  logger.With().Str("component", "listener").Str("channel", channel).Logger(),
+	}
+}
+
+// Connect устанавливает соединение и подписывается на канал
+func (l *Listener) Connect(ctx context.Context) error {
+	l.Close()
+
+	conn, err := pgx.Connect(ctx, l.cfg.DSN())
+	if err != nil {
+		return fmt.Errorf("failed to connect listener: %w", err)
+	}
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{l.channel}.Sanitize()); err != nil {
+		conn.Close(ctx)
+		return fmt.Errorf("failed to listen channel %s: %w", l.channel, err)
+	}
+
+	l.conn = conn
+	l.logger.Info().Msg("Listening for notifications")
+
+	return nil
+}
+
+// Wait блокируется до прихода уведомления.
+// Ошибка означает отмену контекста или потерю соединения (нужен повторный Connect).
+func (l *Listener) Wait(ctx context.Context) error {
+	if l.conn == nil {
+		return fmt.Errorf("listener is not connected")
+	}
+
+	if _, err := l.conn.WaitForNotification(ctx); err != nil {
+		return fmt.Errorf("failed to wait for notification: %w", err)
+	}
+
+	return nil
+}
+
+// Close закрывает соединение
+func (l *Listener) Close() {
+	if l.conn == nil {
+		return
+	}
+
+	if err := l.conn.Close(context.Background()); err != nil {
+		l.logger.Debug().Err(err).Msg("Failed to close listener connection")
+	}
+	l.conn = nil
+}
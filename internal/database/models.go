@@ -1,6 +1,7 @@
 package database
 
 import (
+	"bytes"
 	"database/sql/driver"
 	"encoding/json"
 	"errors"
@@ -10,10 +11,19 @@ import (
 // ReplicationQueue представляет запись в таблице replication_queue
 type ReplicationQueue struct {
 	ID              int64      `gorm:"column:id;primaryKey;autoIncrement"`
+	Schema          string     `gorm:"column:schema_name;type:varchar(255);default:'public'"`
 	Table           string     `gorm:"column:table_name;type:varchar(255);not null"`
 	Operation       string     `gorm:"column:operation;type:varchar(10);not null"` // INSERT, UPDATE, DELETE
 	RecordData      JSONB      `gorm:"column:record_data;type:jsonb;not null"`
+	PayloadVersion  int        `gorm:"column:payload_version;type:smallint;not null;default:1"` // Версия формата record_data
 	PrimaryKeyValue string     `gorm:"column:primary_key_value;type:varchar(255)"` // Для partition key в Kafka
+	TxID            *int64     `gorm:"column:tx_id"`                        // txid_current() исходной транзакции
+	TxSeq           *int       `gorm:"column:tx_seq"`                       // Номер события в транзакции
+	TxSize          *int       `gorm:"column:tx_size"`                      // Число событий транзакции (записывается при коммите)
+	ShardKey        *int32     `gorm:"column:shard_key"`                    // Хеш (таблица, primary key) для шардов publisher
+	Attempts        int        `gorm:"column:attempts;not null;default:0"`  // Неудачные попытки публикации
+	LastError       *string    `gorm:"column:last_error;type:text"`
+	NextAttemptAt   *time.Time `gorm:"column:next_attempt_at;type:timestamptz"` // Не публиковать раньше
 	CreatedAt       time.Time  `gorm:"column:created_at;type:timestamptz;default:now()"`
 	Published       bool       `gorm:"column:published;type:boolean;default:false"`
 	PublishedAt     *time.Time `gorm:"column:published_at;type:timestamptz"`
@@ -35,6 +45,97 @@ func (ProcessedEvent) TableName() string {
 	return "processed_events"
 }
 
+// Результаты разрешения конфликта в replication_conflicts
+const (
+	ConflictResolutionIncomingApplied = "incoming_applied" // Применено входящее изменение
+	ConflictResolutionExistingKept    = "existing_kept"    // Оставлена локальная строка
+	ConflictResolutionRejected        = "rejected"         // Ошибка по политике error
+	ConflictResolutionMerged          = "merged"           // Записана строка, сведенная ConflictResolver
+)
+
+// ReplicationConflict представляет запись в таблице replication_conflicts
+type ReplicationConflict struct {
+	ID             int64     `gorm:"column:id;primaryKey;autoIncrement"`
+	EventID        string    `gorm:"column:event_id;type:varchar(255);not null"`
+	Schema         string    `gorm:"column:schema_name;type:varchar(255);not null"`
+	Table          string    `gorm:"column:table_name;type:varchar(255);not null"`
+	Operation      string    `gorm:"column:operation;type:varchar(10);not null"`
+	PrimaryKey     JSONB     `gorm:"column:primary_key;type:jsonb;not null"`
+	Strategy       string    `gorm:"column:strategy;type:varchar(50);not null"`
+	Resolution     string    `gorm:"column:resolution;type:varchar(20);not null"`
+	WinningVersion int64     `gorm:"column:winning_version"`
+	LosingVersion  int64     `gorm:"column:losing_version"`
+	ExistingRow    JSONB     `gorm:"column:existing_row;type:jsonb"`
+	IncomingRow    JSONB     `gorm:"column:incoming_row;type:jsonb"`
+	SourceContour  string    `gorm:"column:source_contour;type:varchar(50);not null"`
+	CreatedAt      time.Time `gorm:"column:created_at;type:timestamptz;default:now()"`
+}
+
+// TableName возвращает имя таблицы для GORM
+func (ReplicationConflict) TableName() string {
+	return "replication_conflicts"
+}
+
+// ReplicationTombstone представляет запись в таблице replication_tombstones
+type ReplicationTombstone struct {
+	Schema        string    `gorm:"column:schema_name;primaryKey;type:varchar(255)"`
+	Table         string    `gorm:"column:table_name;primaryKey;type:varchar(255)"`
+	PrimaryKey    JSONB     `gorm:"column:primary_key;primaryKey;type:jsonb"` // Значения ключа в текстовом виде
+	Version       int64     `gorm:"column:version;not null"`                  // Версия удаленной строки
+	SourceContour *string   `gorm:"column:source_contour;type:varchar(50)"`   // nil - удалена на этом контуре
+	EventID       *string   `gorm:"column:event_id;type:varchar(255)"`
+	DeletedAt     time.Time `gorm:"column:deleted_at;type:timestamptz;default:now()"`
+}
+
+// TableName возвращает имя таблицы для GORM
+func (ReplicationTombstone) TableName() string {
+	return "replication_tombstones"
+}
+
+// ShardLease представляет запись в таблице replication_shard_leases
+type ShardLease struct {
+	Shard      int        `gorm:"column:shard;primaryKey"`
+	Owner      *string    `gorm:"column:owner;type:varchar(255)"` // nil - шард свободен
+	LeaseUntil *time.Time `gorm:"column:lease_until;type:timestamptz"`
+	UpdatedAt  time.Time  `gorm:"column:updated_at;type:timestamptz;default:now()"`
+}
+
+// TableName возвращает имя таблицы для GORM
+func (ShardLease) TableName() string {
+	return "replication_shard_leases"
+}
+
+// Статусы записей replication_dlq
+const (
+	DeadLetterStatusFailed   = "failed"   // Событие не применено
+	DeadLetterStatusRedrive  = "redrive"  // Оператор запросил повторное применение
+	DeadLetterStatusRedriven = "redriven" // Событие успешно применено повторно
+)
+
+// DeadLetter представляет запись в таблице replication_dlq
+type DeadLetter struct {
+	ID              int64      `gorm:"column:id;primaryKey;autoIncrement"`
+	EventID         string     `gorm:"column:event_id;type:varchar(255)"`
+	Table           string     `gorm:"column:table_name;type:varchar(255)"`
+	Operation       string     `gorm:"column:operation;type:varchar(10)"`
+	SourceContour   string     `gorm:"column:source_contour;type:varchar(50)"`
+	SourceTopic     string     `gorm:"column:source_topic;type:varchar(255);not null"`
+	SourcePartition int32      `gorm:"column:source_partition;not null"`
+	SourceOffset    int64      `gorm:"column:source_offset;not null"`
+	MessageKey      string     `gorm:"column:message_key;type:text"`
+	Payload         string     `gorm:"column:payload;type:text;not null"` // Исходное сообщение без изменений
+	Error           string     `gorm:"column:error;type:text;not null"`
+	Attempts        int        `gorm:"column:attempts;not null"`
+	Status          string     `gorm:"column:status;type:varchar(20);default:failed"`
+	CreatedAt       time.Time  `gorm:"column:created_at;type:timestamptz;default:now()"`
+	RedrivenAt      *time.Time `gorm:"column:redriven_at;type:timestamptz"`
+}
+
+// TableName возвращает имя таблицы для GORM
+func (DeadLetter) TableName() string {
+	return "replication_dlq"
+}
+
 // JSONB представляет PostgreSQL JSONB тип
 type JSONB map[string]interface{}
 
@@ -53,13 +154,18 @@ func (j *JSONB) Scan(value interface{}) error {
 		return nil
 	}
 
-	bytes, ok := value.([]byte)
-	if !ok {
+	var data []byte
+	switch v := value.(type) {
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
 		return errors.New("failed to unmarshal JSONB value")
 	}
 
-	var result map[string]interface{}
-	if err := json.Unmarshal(bytes, &result); err != nil {
+	result, err := decodeJSONObject(data)
+	if err != nil {
 		return err
 	}
 
@@ -77,11 +183,23 @@ func (j JSONB) MarshalJSON() ([]byte, error) {
 
 // UnmarshalJSON реализует json.Unmarshaler
 func (j *JSONB) UnmarshalJSON(data []byte) error {
-	var result map[string]interface{}
-	if err := json.Unmarshal(data, &result); err != nil {
+	result, err := decodeJSONObject(data)
+	if err != nil {
 		return err
 	}
 	*j = result
 	return nil
 }
 
+// decodeJSONObject разбирает JSON объект, сохраняя числа как json.Number
+// (bigint и numeric не теряют точность при преобразовании во float64)
+func decodeJSONObject(data []byte) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var result map[string]interface{}
+	if err := decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -23,6 +23,11 @@ type ConsumerConfig struct {
 	SessionTimeoutMs   int
 	MaxPollIntervalMs  int
 	Topics             []string
+
+	// TopicPattern - регулярное выражение для подписки (librdkafka требует префикс "^").
+	// Новые топики, подходящие под шаблон, подхватываются без перезапуска.
+	TopicPattern              string
+	MetadataRefreshIntervalMs int // Как часто обновлять список топиков для TopicPattern
 }
 
 // Consumer обертка над confluent-kafka-go Consumer
@@ -45,6 +50,10 @@ func NewConsumer(cfg ConsumerConfig, logger zerolog.Logger) (*Consumer, error) {
 		"client.id":                "replicator-consumer",
 	}
 
+	if cfg.MetadataRefreshIntervalMs > 0 {
+		configMap["topic.metadata.refresh.interval.ms"] = cfg.MetadataRefreshIntervalMs
+	}
+
 	// SSL конфигурация
 	if cfg.SSLEnabled {
 		configMap["security.protocol"] = "SSL"
@@ -71,25 +80,59 @@ func NewConsumer(cfg ConsumerConfig, logger zerolog.Logger) (*Consumer, error) {
 		return nil, fmt.Errorf("failed to create kafka consumer: %w", err)
 	}
 
-	// Подписываемся на топики
-	if err := consumer.SubscribeTopics(cfg.Topics, nil); err != nil {
-		consumer.Close()
-		return nil, fmt.Errorf("failed to subscribe to topics: %w", err)
+	// Топики подписки (явные + шаблон); подписка выполняется в Subscribe
+	subscriptions := make([]string, 0, len(cfg.Topics)+1)
+	subscriptions = append(subscriptions, cfg.Topics...)
+	if cfg.TopicPattern != "" {
+		subscriptions = append(subscriptions, cfg.TopicPattern)
 	}
 
 	logger.Info().
 		Strs("brokers", cfg.Brokers).
 		Str("group_id", cfg.ConsumerGroup).
 		Strs("topics", cfg.Topics).
+		Str("topic_pattern", cfg.TopicPattern).
 		Msg("Kafka consumer created successfully")
 
 	return &Consumer{
 		consumer: consumer,
 		logger:   logger,
-		topics:   cfg.Topics,
+		topics:   subscriptions,
 	}, nil
 }
 
+// Subscribe подписывается на топики. onRevoke вызывается из Poll перед отзывом партиций
+// при ребалансировке - до возврата из него можно дообработать и закоммитить партиции.
+func (c *Consumer) Subscribe(onRevoke func(partitions []kafka.TopicPartition)) error {
+	rebalanceCb := func(_ *kafka.Consumer, event kafka.Event) error {
+		switch e := event.(type) {
+		case kafka.AssignedPartitions:
+			c.logger.Info().
+				Str("partitions", fmt.Sprint(e.Partitions)).
+				Msg("Partitions assigned")
+		case kafka.RevokedPartitions:
+			c.logger.Info().
+				Str("partitions", fmt.Sprint(e.Partitions)).
+				Msg("Partitions revoked")
+			if onRevoke != nil {
+				onRevoke(e.Partitions)
+			}
+		}
+		// Assign/Unassign выполняет библиотека после возврата из callback
+		return nil
+	}
+
+	if err := c.consumer.SubscribeTopics(c.topics, rebalanceCb); err != nil {
+		return fmt.Errorf("failed to subscribe to topics: %w", err)
+	}
+
+	c.logger.Info().
+		Strs("subscriptions", c.topics).
+		Msg("Subscribed to topics")
+
+	return nil
+}
+
 // Poll читает сообщение из Kafka
 func (c *Consumer) Poll(timeout time.Duration) (*kafka.Message, error) {
 	event := c.consumer.Poll(int(timeout.Milliseconds()))
@@ -137,6 +180,58 @@ func (c *Consumer) Commit(message *kafka.Message) error {
 	return nil
 }
 
+// CommitOffset подтверждает обработку партиции до offset включительно
+func (c *Consumer) CommitOffset(topic string, partition int32, offset kafka.Offset) error {
+	_, err := c.consumer.CommitOffsets([]kafka.TopicPartition{{
+		Topic:     &topic,
+		Partition: partition,
+		Offset:    offset + 1, // В Kafka коммитится offset следующего сообщения
+	}})
+	if err != nil {
+		return fmt.Errorf("failed to commit offset: %w", err)
+	}
+
+	c.logger.Debug().
+		Str("topic", topic).
+		Int32("partition", partition).
+		Int64("offset", int64(offset)).
+		Msg("Offset committed")
+
+	return nil
+}
+
+// Pause приостанавливает выборку сообщений партиции
+func (c *Consumer) Pause(partition kafka.TopicPartition) error {
+	if err := c.consumer.Pause([]kafka.TopicPartition{partition}); err != nil {
+		return fmt.Errorf("failed to pause partition: %w", err)
+	}
+	return nil
+}
+
+// Resume возобновляет выборку сообщений партиции
+func (c *Consumer) Resume(partition kafka.TopicPartition) error {
+	if err := c.consumer.Resume([]kafka.TopicPartition{partition}); err != nil {
+		return fmt.Errorf("failed to resume partition: %w", err)
+	}
+	return nil
+}
+
+// Seek возвращает позицию партиции сообщения на его offset,
+// чтобы следующий Poll прочитал это сообщение повторно
+func (c *Consumer) Seek(message *kafka.Message) error {
+	if err := c.consumer.Seek(message.TopicPartition, 0); err != nil {
+		return fmt.Errorf("failed to seek: %w", err)
+	}
+
+	c.logger.Debug().
+		Str("topic", *message.TopicPartition.Topic).
+		Int32("partition", message.TopicPartition.Partition).
+		Int64("offset", int64(message.TopicPartition.Offset)).
+		Msg("Partition rewound to message")
+
+	return nil
+}
+
 // Close закрывает consumer
 func (c *Consumer) Close() {
 	c.logger.Info().Msg("Closing Kafka consumer...")
@@ -1,7 +1,9 @@
 package kafka
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/v2/kafka"
 	"github.com/rs/zerolog"
@@ -21,6 +23,37 @@ type ProducerConfig struct {
 	MaxInFlight  int
 	BatchSize    int
 	LingerMs     int
+
+	// EnableIdempotence включает идемпотентный producer:
+	// брокер отбрасывает дубли ретраев и сохраняет порядок сообщений внутри партиции
+	EnableIdempotence bool
+}
+
+// Message представляет сообщение для отправки
+type Message struct {
+	Topic   string
+	Key     []byte
+	Value   []byte
+	Headers map[string]string
+}
+
+// toKafkaMessage конвертирует Message в сообщение confluent-kafka-go
+func (m Message) toKafkaMessage() *kafka.Message {
+	topic := m.Topic
+	message := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{
+			Topic:     &topic,
+			Partition: kafka.PartitionAny,
+		},
+		Key:   m.Key,
+		Value: m.Value,
+	}
+
+	for key, value := range m.Headers {
+		message.Headers = append(message.Headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+
+	return message
 }
 
 // Producer обертка над confluent-kafka-go Producer
@@ -42,6 +75,10 @@ func NewProducer(cfg ProducerConfig, logger zerolog.Logger) (*Producer, error) {
 		"client.id":      "replicator-publisher",
 	}
 
+	if cfg.EnableIdempotence {
+		configMap["enable.idempotence"] = true
+	}
+
 	// SSL конфигурация
 	if cfg.SSLEnabled {
 		configMap["security.protocol"] = "SSL"
@@ -72,6 +109,7 @@ func NewProducer(cfg ProducerConfig, logger zerolog.Logger) (*Producer, error) {
 		Strs("brokers", cfg.Brokers).
 		Str("acks", cfg.Acks).
 		Str("compression", cfg.Compression).
+		Bool("idempotence", cfg.EnableIdempotence).
 		Msg("Kafka producer created successfully")
 
 	p := &Producer{
@@ -87,14 +125,13 @@ func NewProducer(cfg ProducerConfig, logger zerolog.Logger) (*Producer, error) {
 
 // Produce отправляет сообщение в Kafka
 func (p *Producer) Produce(topic string, key []byte, value []byte) error {
-	message := &kafka.Message{
-		TopicPartition: kafka.TopicPartition{
-			Topic:     &topic,
-			Partition: kafka.PartitionAny,
-		},
-		Key:   key,
-		Value: value,
-	}
+	return p.ProduceMessage(Message{Topic: topic, Key: key, Value: value})
+}
+
+// ProduceMessage отправляет сообщение (с заголовками) в Kafka и ждет подтверждения доставки
+func (p *Producer) ProduceMessage(msg Message) error {
+	topic := msg.Topic
+	message := msg.toKafkaMessage()
 
 	// Отправляем сообщение (асинхронно)
 	deliveryChan := make(chan kafka.Event, 1)
@@ -138,6 +175,104 @@ func (p *Producer) ProduceAsync(topic string, key []byte, value []byte) error {
 	return nil
 }
 
+// ErrDeliveryTimeout - delivery report сообщения не получен за отведенное время
+var ErrDeliveryTimeout = errors.New("delivery report not received")
+
+// IsRetriable определяет, связана ли ошибка отправки с доступностью Kafka, а не с самим сообщением.
+// Такие ошибки проходят сами, а сообщение с неретраибельной ошибкой (слишком большое,
+// неизвестный топик) не будет доставлено никогда.
+func IsRetriable(err error) bool {
+	if errors.Is(err, ErrDeliveryTimeout) {
+		return true
+	}
+
+	var kafkaErr kafka.Error
+	if !errors.As(err, &kafkaErr) {
+		return false
+	}
+	if kafkaErr.IsRetriable() {
+		return true
+	}
+
+	switch kafkaErr.Code() {
+	case kafka.ErrTransport, kafka.ErrAllBrokersDown, kafka.ErrMsgTimedOut, kafka.ErrTimedOut,
+		kafka.ErrQueueFull, kafka.ErrRequestTimedOut, kafka.ErrLeaderNotAvailable,
+		kafka.ErrNotLeaderForPartition, kafka.ErrNotEnoughReplicas, kafka.ErrNotEnoughReplicasAfterAppend:
+		return true
+	}
+	return false
+}
+
+// ProduceBatch ставит в очередь все сообщения батча и один раз ждет delivery reports по всем.
+// Возвращает ошибки по индексам сообщений (nil - сообщение доставлено).
+// Сообщения, по которым не пришел delivery report за timeout, считаются недоставленными.
+func (p *Producer) ProduceBatch(messages []Message, timeout time.Duration) []error {
+	errs := make([]error, len(messages))
+	delivered := make([]bool, len(messages))
+	deliveryChan := make(chan kafka.Event, len(messages))
+
+	// Ставим все сообщения в очередь producer
+	pending := 0
+	for i, msg := range messages {
+		message := msg.toKafkaMessage()
+		message.Opaque = i // Индекс сообщения для сопоставления delivery report
+
+		if err := p.producer.Produce(message, deliveryChan); err != nil {
+			errs[i] = fmt.Errorf("failed to produce message: %w", err)
+			delivered[i] = true // delivery report не придет
+			continue
+		}
+		pending++
+	}
+
+	// Ждем delivery reports по всем поставленным в очередь сообщениям
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for pending > 0 {
+		select {
+		case e := <-deliveryChan:
+			m, ok := e.(*kafka.Message)
+			if !ok {
+				continue
+			}
+			i, ok := m.Opaque.(int)
+			if !ok || i < 0 || i >= len(messages) {
+				continue
+			}
+
+			delivered[i] = true
+			pending--
+
+			if m.TopicPartition.Error != nil {
+				errs[i] = fmt.Errorf("delivery failed: %w", m.TopicPartition.Error)
+				continue
+			}
+
+			p.logger.Debug().
+				Str("topic", *m.TopicPartition.Topic).
+				Int32("partition", m.TopicPartition.Partition).
+				Int64("offset", int64(m.TopicPartition.Offset)).
+				Msg("Message delivered successfully")
+
+		case <-timer.C:
+			p.logger.Warn().
+				Int("pending", pending).
+				Dur("timeout", timeout).
+				Msg("Timed out waiting for delivery reports")
+
+			for i := range messages {
+				if !delivered[i] {
+					errs[i] = fmt.Errorf("%w within %s", ErrDeliveryTimeout, timeout)
+				}
+			}
+			return errs
+		}
+	}
+
+	return errs
+}
+
 // Flush ждет доставки всех сообщений
 func (p *Producer) Flush(timeoutMs int) int {
 	remaining := p.producer.Flush(timeoutMs)
@@ -0,0 +1,82 @@
+package publisher
+
+import (
+	"context"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+	"github.com/vahtykov/go-replicator-service/internal/kafka"
+)
+
+// publishRecordsBatch публикует записи батча конвейерно: все сообщения сразу ставятся
+// в очередь producer, delivery reports ожидаются один раз на весь батч.
+// Возвращает ID записей, которые можно пометить опубликованными, и записи, которые доставить не удалось.
+func (p *Publisher) publishRecordsBatch(ctx context.Context, records []database.ReplicationQueue) ([]int64, []recordFailure) {
+	messages, messageRecords, recordErrs := buildBatchMessages(records, func(record database.ReplicationQueue) (kafka.Message, error) {
+		message, _, err := p.buildMessage(ctx, record)
+		return message, err
+	})
+
+	// Одно ожидание delivery reports на весь батч
+	deliveryErrs := p.producer.ProduceBatch(messages, p.config.DeliveryTimeout)
+	for j, err := range deliveryErrs {
+		recordErrs[messageRecords[j]] = err
+	}
+
+	return publishableRecords(records, recordErrs)
+}
+
+// buildBatchMessages строит сообщения записей батча. Возвращает сообщения, индекс записи
+// для каждого сообщения и ошибки построения по записям. После записи, сообщение которой
+// построить не удалось, более поздние записи того же ключа в очередь producer не ставятся
+// (как в publishRecordsSync): иначе они обогнали бы ее в Kafka.
+func buildBatchMessages(records []database.ReplicationQueue, build func(database.ReplicationQueue) (kafka.Message, error)) ([]kafka.Message, []int, []error) {
+	recordErrs := make([]error, len(records))
+	blockedKeys := make(map[string]bool)
+
+	messages := make([]kafka.Message, 0, len(records))
+	messageRecords := make([]int, 0, len(records)) // Индекс записи для каждого сообщения
+
+	for i, record := range records {
+		key := recordOrderKey(record)
+		if blockedKeys[key] {
+			continue
+		}
+
+		message, err := build(record)
+		if err != nil {
+			recordErrs[i] = err
+			blockedKeys[key] = true
+			continue
+		}
+
+		messages = append(messages, message)
+		messageRecords = append(messageRecords, i)
+	}
+
+	return messages, messageRecords, recordErrs
+}
+
+// publishableRecords возвращает ID записей, которые можно пометить опубликованными, и неопубликованные записи.
+// Сохраняем порядок по ключу: если запись не доставлена, более поздние записи
+// с тем же ключом не помечаются опубликованными и будут переотправлены вслед за ней.
+func publishableRecords(records []database.ReplicationQueue, recordErrs []error) ([]int64, []recordFailure) {
+	blockedKeys := make(map[string]bool)
+
+	publishedIDs := make([]int64, 0, len(records))
+	var failures []recordFailure
+
+	for i, record := range records {
+		key := recordOrderKey(record)
+		if recordErrs[i] == nil && !blockedKeys[key] {
+			publishedIDs = append(publishedIDs, record.ID)
+			continue
+		}
+
+		if recordErrs[i] != nil {
+			failures = append(failures, recordFailure{record: record, err: recordErrs[i]})
+		}
+		blockedKeys[key] = true
+	}
+
+	return publishedIDs, failures
+}
@@ -0,0 +1,120 @@
+package publisher
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+	"github.com/vahtykov/go-replicator-service/internal/kafka"
+)
+
+func queueRecord(id int64, table string, shardKey int32) database.ReplicationQueue {
+	return database.ReplicationQueue{ID: id, Schema: "public", Table: table, ShardKey: &shardKey}
+}
+
+func TestPublishableRecords(t *testing.T) {
+	failed := errors.New("delivery failed")
+
+	tests := []struct {
+		name       string
+		records    []database.ReplicationQueue
+		errs       []error
+		want       []int64
+		wantFailed []int64
+	}{
+		{
+			name:    "all delivered",
+			records: []database.ReplicationQueue{queueRecord(1, "users", 1), queueRecord(2, "users", 2)},
+			errs:    []error{nil, nil},
+			want:    []int64{1, 2},
+		},
+		{
+			name:       "failed record blocks later records of its key",
+			records:    []database.ReplicationQueue{queueRecord(1, "users", 1), queueRecord(2, "users", 2), queueRecord(3, "users", 1)},
+			errs:       []error{failed, nil, nil},
+			want:       []int64{2},
+			wantFailed: []int64{1},
+		},
+		{
+			name:       "earlier records of the key are kept",
+			records:    []database.ReplicationQueue{queueRecord(1, "users", 1), queueRecord(2, "users", 1)},
+			errs:       []error{nil, failed},
+			want:       []int64{1},
+			wantFailed: []int64{2},
+		},
+		{
+			name:       "same shard key of another table is not blocked",
+			records:    []database.ReplicationQueue{queueRecord(1, "users", 1), queueRecord(2, "orders", 1)},
+			errs:       []error{failed, nil},
+			want:       []int64{2},
+			wantFailed: []int64{1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, failures := publishableRecords(tt.records, tt.errs)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("published %v, want %v", got, tt.want)
+			}
+
+			var failedIDs []int64
+			for _, failure := range failures {
+				failedIDs = append(failedIDs, failure.record.ID)
+			}
+			if !reflect.DeepEqual(failedIDs, tt.wantFailed) {
+				t.Errorf("failed %v, want %v", failedIDs, tt.wantFailed)
+			}
+		})
+	}
+}
+
+func TestRecordOrderKeyWithoutShardKey(t *testing.T) {
+	// Записи без shard_key упорядочиваются в пределах таблицы
+	legacy := database.ReplicationQueue{ID: 1, Schema: "public", Table: "users"}
+	if got := recordOrderKey(legacy); got != "public.users" {
+		t.Errorf("got %s, want public.users", got)
+	}
+
+	keyed := queueRecord(2, "users", 42)
+	if got := recordOrderKey(keyed); got != "public.users/42" {
+		t.Errorf("got %s, want public.users/42", got)
+	}
+}
+
+func TestBuildBatchMessagesBlocksKeyAfterBuildFailure(t *testing.T) {
+	records := []database.ReplicationQueue{
+		queueRecord(1, "users", 7),
+		queueRecord(2, "users", 7), // Сообщение не строится
+		queueRecord(3, "users", 8),
+		queueRecord(4, "users", 7),
+	}
+	broken := errors.New("failed to decode record_data")
+
+	var built []int64
+	messages, messageRecords, errs := buildBatchMessages(records, func(record database.ReplicationQueue) (kafka.Message, error) {
+		built = append(built, record.ID)
+		if record.ID == 2 {
+			return kafka.Message{}, broken
+		}
+		return kafka.Message{Topic: record.Table}, nil
+	})
+
+	// Запись 4 того же ключа не ставится в очередь producer после ошибки записи 2
+	if !reflect.DeepEqual(built, []int64{1, 2, 3}) {
+		t.Errorf("built records %v, want [1 2 3]", built)
+	}
+	if len(messages) != 2 || !reflect.DeepEqual(messageRecords, []int{0, 2}) {
+		t.Errorf("messages for records %v, want [0 2]", messageRecords)
+	}
+
+	// Запись 4 не помечается опубликованной и не считается неудачной - ее возьмет следующий батч
+	published, failures := publishableRecords(records, errs)
+	if !reflect.DeepEqual(published, []int64{1, 3}) {
+		t.Errorf("published %v, want [1 3]", published)
+	}
+	if len(failures) != 1 || failures[0].record.ID != 2 || !errors.Is(failures[0].err, broken) {
+		t.Errorf("unexpected failures: %+v", failures)
+	}
+}
@@ -3,6 +3,7 @@ package publisher
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -13,11 +14,23 @@ type ReplicationEvent struct {
 	EventID   string                 `json:"event_id"`
 	Timestamp time.Time              `json:"timestamp"`
 	Source    SourceInfo             `json:"source"`
+	Schema    string                 `json:"schema,omitempty"`
 	Table     string                 `json:"table"`
 	Operation string                 `json:"operation"` // INSERT, UPDATE, DELETE
 	PrimaryKey map[string]interface{} `json:"primary_key"`
+	// PrimaryKeyColumns задает порядок колонок primary key (для составных ключей)
+	PrimaryKeyColumns []string         `json:"primary_key_columns,omitempty"`
 	Before    map[string]interface{} `json:"before,omitempty"`
 	After     map[string]interface{} `json:"after,omitempty"`
+	// Transaction - границы исходной транзакции (consumer применяет ее события атомарно)
+	Transaction *TransactionInfo `json:"transaction,omitempty"`
+}
+
+// TransactionInfo описывает место события в исходной транзакции
+type TransactionInfo struct {
+	ID    int64 `json:"id"`    // txid_current() на контуре-источнике
+	Index int   `json:"index"` // Номер события в транзакции (с 1)
+	Count int   `json:"count"` // Число событий в транзакции
 }
 
 // SourceInfo содержит информацию об источнике события
@@ -26,14 +39,15 @@ type SourceInfo struct {
 	Database string `json:"database"`
 }
 
-// NewReplicationEvent создает новое событие репликации
+// NewReplicationEvent создает новое событие репликации из образов строки
 func NewReplicationEvent(
 	contour string,
 	database string,
 	tableName string,
 	operation string,
-	recordData map[string]interface{},
-) *ReplicationEvent {
+	primaryKey []string,
+	images RowImages,
+) (*ReplicationEvent, error) {
 	event := &ReplicationEvent{
 		EventID:   uuid.New().String(),
 		Timestamp: time.Now().UTC(),
@@ -43,31 +57,48 @@ func NewReplicationEvent(
 		},
 		Table:     tableName,
 		Operation: operation,
-		PrimaryKey: make(map[string]interface{}),
-		Before:    make(map[string]interface{}),
-		After:     make(map[string]interface{}),
-	}
-
-	// Извлекаем primary key
-	if id, ok := recordData["id"]; ok {
-		event.PrimaryKey["id"] = id
+		PrimaryKey: make(map[string]interface{}, len(primaryKey)),
+		PrimaryKeyColumns: primaryKey,
 	}
 
 	// Заполняем Before/After в зависимости от операции
+	// и выбираем образ, по которому определяется primary key
+	var keyImage map[string]interface{}
 	switch operation {
 	case "INSERT":
-		event.After = recordData
-		event.Before = nil
+		if images.After == nil {
+			return nil, fmt.Errorf("INSERT event must have 'after' image")
+		}
+		event.After = images.After
+		keyImage = images.After
 	case "UPDATE":
-		event.After = recordData
-		// Для UPDATE в триггере нет OLD данных (это можно улучшить)
-		event.Before = nil
+		if images.Before == nil || images.After == nil {
+			return nil, fmt.Errorf("UPDATE event must have both 'before' and 'after' images")
+		}
+		event.Before = images.Before
+		event.After = images.After
+		// Строку на стороне получателя ищем по ключу до изменения
+		keyImage = images.Before
 	case "DELETE":
-		event.Before = recordData
-		event.After = nil
+		if images.Before == nil {
+			return nil, fmt.Errorf("DELETE event must have 'before' image")
+		}
+		event.Before = images.Before
+		keyImage = images.Before
+	default:
+		return nil, fmt.Errorf("unknown operation: %s", operation)
+	}
+
+	// Извлекаем все колонки primary key
+	for _, column := range primaryKey {
+		value, ok := keyImage[column]
+		if !ok {
+			return nil, fmt.Errorf("primary key column %s is missing in record data", column)
+		}
+		event.PrimaryKey[column] = value
 	}
 
-	return event
+	return event, nil
 }
 
 // ToJSON сериализует событие в JSON
@@ -75,14 +106,29 @@ func (e *ReplicationEvent) ToJSON() ([]byte, error) {
 	return json.Marshal(e)
 }
 
-// ExtractPartitionKey извлекает ключ для партиционирования Kafka
+// ExtractPartitionKey извлекает ключ для партиционирования Kafka.
+// Ключ строится из всех колонок primary key в порядке их объявления,
+// для одноколоночного ключа это просто его значение.
+// Значения берутся из образа после изменения (для DELETE - до него), как shard_key в триггере:
+// UPDATE, меняющий primary key, попадает в партицию и шард последующих изменений строки.
 func (e *ReplicationEvent) ExtractPartitionKey() []byte {
-	// Используем primary key как partition key
-	if id, ok := e.PrimaryKey["id"]; ok {
-		// Конвертируем id в строку
-		return []byte(fmt.Sprintf("%v", id))
+	if len(e.PrimaryKeyColumns) == 0 {
+		// Fallback на event_id
+		return []byte(e.EventID)
+	}
+
+	image := e.After
+	if e.Operation == "DELETE" {
+		image = e.Before
 	}
-	// Fallback на event_id
-	return []byte(e.EventID)
-}
 
+	parts := make([]string, 0, len(e.PrimaryKeyColumns))
+	for _, column := range e.PrimaryKeyColumns {
+		value, ok := image[column]
+		if !ok {
+			value = e.PrimaryKey[column]
+		}
+		parts = append(parts, fmt.Sprintf("%v", value))
+	}
+	return []byte(strings.Join(parts, "|"))
+}
@@ -0,0 +1,123 @@
+package publisher
+
+import "testing"
+
+func TestNewReplicationEventUpdateKeyFromBefore(t *testing.T) {
+	event, err := NewReplicationEvent("A", "db", "users", "UPDATE", []string{"id"}, RowImages{
+		Before: map[string]interface{}{"id": 1, "name": "old"},
+		After:  map[string]interface{}{"id": 2, "name": "new"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Строка получателя ищется по ключу до изменения
+	if event.PrimaryKey["id"] != 1 {
+		t.Errorf("primary key = %v, want 1", event.PrimaryKey["id"])
+	}
+	if event.Before["name"] != "old" || event.After["name"] != "new" {
+		t.Errorf("unexpected images: before %v, after %v", event.Before, event.After)
+	}
+}
+
+func TestNewReplicationEventMissingImage(t *testing.T) {
+	images := map[string]RowImages{
+		"INSERT": {Before: map[string]interface{}{"id": 1}},
+		"UPDATE": {After: map[string]interface{}{"id": 1}},
+		"DELETE": {After: map[string]interface{}{"id": 1}},
+	}
+	for operation, image := range images {
+		if _, err := NewReplicationEvent("A", "db", "users", operation, []string{"id"}, image); err == nil {
+			t.Errorf("%s: expected error for missing image", operation)
+		}
+	}
+}
+
+func TestNewReplicationEventCompositeKey(t *testing.T) {
+	event, err := NewReplicationEvent("A", "db", "order_items", "INSERT", []string{"order_id", "item_id"}, RowImages{
+		After: map[string]interface{}{"item_id": 3, "order_id": 10, "qty": 2},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(event.PrimaryKey) != 2 || event.PrimaryKey["order_id"] != 10 || event.PrimaryKey["item_id"] != 3 {
+		t.Errorf("primary key = %v", event.PrimaryKey)
+	}
+	// Ключ партиции - значения в порядке объявления колонок
+	if key := string(event.ExtractPartitionKey()); key != "10|3" {
+		t.Errorf("partition key = %q, want %q", key, "10|3")
+	}
+}
+
+func TestNewReplicationEventMissingKeyColumn(t *testing.T) {
+	_, err := NewReplicationEvent("A", "db", "users", "DELETE", []string{"uuid"}, RowImages{
+		Before: map[string]interface{}{"id": 1},
+	})
+	if err == nil {
+		t.Fatal("expected error for primary key column missing in record data")
+	}
+}
+
+func TestExtractPartitionKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		event ReplicationEvent
+		want  string
+	}{
+		{
+			name: "insert",
+			event: ReplicationEvent{
+				Operation:         "INSERT",
+				PrimaryKeyColumns: []string{"id"},
+				PrimaryKey:        map[string]interface{}{"id": 1},
+				After:             map[string]interface{}{"id": 1},
+			},
+			want: "1",
+		},
+		{
+			name: "update changing primary key uses after image",
+			event: ReplicationEvent{
+				Operation:         "UPDATE",
+				PrimaryKeyColumns: []string{"id"},
+				PrimaryKey:        map[string]interface{}{"id": 1},
+				Before:            map[string]interface{}{"id": 1},
+				After:             map[string]interface{}{"id": 2},
+			},
+			want: "2",
+		},
+		{
+			name: "delete uses before image",
+			event: ReplicationEvent{
+				Operation:         "DELETE",
+				PrimaryKeyColumns: []string{"id"},
+				PrimaryKey:        map[string]interface{}{"id": 2},
+				Before:            map[string]interface{}{"id": 2},
+			},
+			want: "2",
+		},
+		{
+			name: "composite key in declaration order",
+			event: ReplicationEvent{
+				Operation:         "INSERT",
+				PrimaryKeyColumns: []string{"order_id", "item_id"},
+				PrimaryKey:        map[string]interface{}{"order_id": 10, "item_id": 3},
+				After:             map[string]interface{}{"item_id": 3, "order_id": 10},
+			},
+			want: "10|3",
+		},
+		{
+			name:  "no primary key falls back to event id",
+			event: ReplicationEvent{EventID: "event-1", Operation: "INSERT"},
+			want:  "event-1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(tt.event.ExtractPartitionKey()); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
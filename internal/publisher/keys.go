@@ -0,0 +1,58 @@
+package publisher
+
+import (
+	"context"
+	"sync"
+
+	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// primaryKeyResolver определяет колонки primary key реплицируемых таблиц.
+// Колонки из конфигурации имеют приоритет, остальные читаются из pg_index и кэшируются.
+type primaryKeyResolver struct {
+	db        *gorm.DB
+	overrides map[string][]string // schema.table или имя таблицы (в любой схеме) -> колонки
+
+	mu    sync.RWMutex
+	cache map[string][]string // schema.table -> колонки
+}
+
+// newPrimaryKeyResolver создает resolver с колонками, заданными в конфигурации
+func newPrimaryKeyResolver(db *gorm.DB, overrides map[string][]string) *primaryKeyResolver {
+	return &primaryKeyResolver{
+		db:        db,
+		overrides: overrides,
+		cache:     make(map[string][]string),
+	}
+}
+
+// Resolve возвращает колонки primary key таблицы schema.tableName
+func (r *primaryKeyResolver) Resolve(ctx context.Context, schema, tableName string) ([]string, error) {
+	qualified := schema + "." + tableName
+	if columns, ok := r.overrides[qualified]; ok && len(columns) > 0 {
+		return columns, nil
+	}
+	if columns, ok := r.overrides[tableName]; ok && len(columns) > 0 {
+		return columns, nil
+	}
+
+	r.mu.RLock()
+	columns, ok := r.cache[qualified]
+	r.mu.RUnlock()
+	if ok {
+		return columns, nil
+	}
+
+	columns, err := database.LoadPrimaryKeyColumns(ctx, r.db, schema, tableName)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	r.cache[qualified] = columns
+	r.mu.Unlock()
+
+	return columns, nil
+}
@@ -0,0 +1,37 @@
+package publisher
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestPrimaryKeyResolverOverride(t *testing.T) {
+	// Колонки из конфигурации не требуют обращения к каталогу БД
+	resolver := newPrimaryKeyResolver(nil, map[string][]string{
+		"order_items":      {"order_id", "item_id"},
+		"billing.invoices": {"invoice_no"},
+		"invoices":         {"id"},
+	})
+
+	tests := []struct {
+		schema, table string
+		want          []string
+	}{
+		{schema: "public", table: "order_items", want: []string{"order_id", "item_id"}},
+		{schema: "sales", table: "order_items", want: []string{"order_id", "item_id"}},
+		// Имя со схемой важнее имени таблицы без схемы
+		{schema: "billing", table: "invoices", want: []string{"invoice_no"}},
+		{schema: "public", table: "invoices", want: []string{"id"}},
+	}
+
+	for _, tt := range tests {
+		columns, err := resolver.Resolve(context.Background(), tt.schema, tt.table)
+		if err != nil {
+			t.Fatalf("%s.%s: unexpected error: %v", tt.schema, tt.table, err)
+		}
+		if !reflect.DeepEqual(columns, tt.want) {
+			t.Errorf("%s.%s: got %v, want %v", tt.schema, tt.table, columns, tt.want)
+		}
+	}
+}
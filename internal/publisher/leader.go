@@ -0,0 +1,148 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// errLeadershipLost - экземпляр перестал быть ведущим, пока публиковал батч
+var errLeadershipLost = errors.New("publisher leadership lost before batch commit")
+
+// LeaderStatus - состояние выбора ведущего publisher
+type LeaderStatus struct {
+	Enabled        bool      // Включен режим одного активного publisher
+	Identity       string    // Идентификатор этого экземпляра
+	Leader         bool      // Этот экземпляр - ведущий и публикует события
+	LeaderIdentity string    // Идентификатор ведущего экземпляра ("" - блокировка свободна или неизвестна)
+	Since          time.Time // Когда состояние изменилось
+}
+
+// leaderState хранит состояние выбора ведущего (читается основным циклом и Status)
+type leaderState struct {
+	mu     sync.RWMutex
+	status LeaderStatus
+}
+
+// leading определяет, может ли экземпляр публиковать события.
+// Без выбора ведущего публикуют все экземпляры.
+func (p *Publisher) leading() bool {
+	if p.config.Leader == nil {
+		return true
+	}
+
+	p.leader.mu.RLock()
+	defer p.leader.mu.RUnlock()
+	return p.leader.status.Leader
+}
+
+// canCommit проверяет перед коммитом батча, что экземпляр все еще вправе пометить его записи:
+// остается ведущим и владеет шардом батча
+func (p *Publisher) canCommit(shard *shardFilter) error {
+	if !p.leading() {
+		return errLeadershipLost
+	}
+	if !p.ownsShard(shard) {
+		return errShardLost
+	}
+	return nil
+}
+
+// Status возвращает состояние выбора ведущего
+func (p *Publisher) Status() LeaderStatus {
+	p.leader.mu.RLock()
+	defer p.leader.mu.RUnlock()
+	return p.leader.status
+}
+
+// setLeaderStatus обновляет состояние. Возвращает true, если оно изменилось.
+func (p *Publisher) setLeaderStatus(leader bool, leaderIdentity string) bool {
+	p.leader.mu.Lock()
+	defer p.leader.mu.Unlock()
+
+	status := &p.leader.status
+	if status.Leader == leader && status.LeaderIdentity == leaderIdentity {
+		return false
+	}
+	status.Leader = leader
+	status.LeaderIdentity = leaderIdentity
+	status.Since = time.Now()
+	return true
+}
+
+// elect участвует в выборе ведущего: пытается захватить advisory lock и, пока удерживает его,
+// проверяет сессию блокировки. Резервные экземпляры перехватывают блокировку после ее освобождения
+// (сервер снимает ее при обрыве сессии ведущего).
+func (p *Publisher) elect(ctx context.Context, wakeups chan<- struct{}) {
+	lock := p.config.Leader
+	defer lock.Release(context.Background())
+
+	ticker := time.NewTicker(p.config.LeaderCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		p.checkLeadership(ctx, lock, wakeups)
+
+		select {
+		case <-ctx.Done():
+			if p.setLeaderStatus(false, "") {
+				p.logger.Info().
+					Str("identity", lock.Identity()).
+					Msg("Publisher leadership released")
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkLeadership проверяет удерживаемую блокировку или пытается ее захватить
+func (p *Publisher) checkLeadership(ctx context.Context, lock *database.LeaderLock, wakeups chan<- struct{}) {
+	if p.leading() {
+		if err := lock.Check(ctx); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			p.setLeaderStatus(false, "")
+			p.logger.Error().
+				Err(err).
+				Str("identity", lock.Identity()).
+				Msg("Publisher leadership lost, publishing stopped")
+		}
+		return
+	}
+
+	acquired, err := lock.TryAcquire(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logger.Warn().Err(err).Msg("Failed to take part in leader election")
+		}
+		return
+	}
+
+	if acquired {
+		p.setLeaderStatus(true, lock.Identity())
+		p.logger.Info().
+			Str("identity", lock.Identity()).
+			Msg("Publisher leadership acquired, publishing started")
+
+		// Сразу разбираем накопившуюся очередь
+		wakeUp(wakeups)
+		return
+	}
+
+	holder, err := lock.Holder(ctx)
+	if err != nil {
+		p.logger.Warn().Err(err).Msg("Failed to read current publisher leader")
+		return
+	}
+	if p.setLeaderStatus(false, holder) {
+		p.logger.Info().
+			Str("identity", lock.Identity()).
+			Str("leader", holder).
+			Msg("Publisher is standby, leadership held by another instance")
+	}
+}
@@ -0,0 +1,68 @@
+package publisher
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/rs/zerolog"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+func TestLeadingWithoutElection(t *testing.T) {
+	p := New(nil, nil, Config{}, zerolog.Nop())
+
+	if !p.leading() {
+		t.Error("without leader election every instance publishes")
+	}
+	if p.Status().Enabled {
+		t.Error("status must report election disabled")
+	}
+}
+
+func TestLeaderStatusTransitions(t *testing.T) {
+	lock := database.NewLeaderLock(database.Config{}, "publisher", "node-1", zerolog.Nop())
+	p := New(nil, nil, Config{Leader: lock}, zerolog.Nop())
+
+	status := p.Status()
+	if !status.Enabled || status.Identity != "node-1" || status.Leader {
+		t.Fatalf("unexpected initial status: %+v", status)
+	}
+	if p.leading() {
+		t.Error("instance must not publish before acquiring the lock")
+	}
+
+	if !p.setLeaderStatus(false, "node-2") {
+		t.Error("standby with known leader is a status change")
+	}
+	if p.setLeaderStatus(false, "node-2") {
+		t.Error("repeated status must not be reported as a change")
+	}
+
+	p.setLeaderStatus(true, "node-1")
+	if !p.leading() {
+		t.Error("leader must publish")
+	}
+
+	// Потеря блокировки сразу останавливает публикацию
+	p.setLeaderStatus(false, "")
+	if p.leading() {
+		t.Error("instance kept publishing after losing leadership")
+	}
+}
+
+func TestCanCommitAfterLeadershipLost(t *testing.T) {
+	lock := database.NewLeaderLock(database.Config{}, "publisher", "node-1", zerolog.Nop())
+	p := New(nil, nil, Config{Leader: lock}, zerolog.Nop())
+
+	p.setLeaderStatus(true, "node-1")
+	if err := p.canCommit(nil); err != nil {
+		t.Fatalf("leader must commit its batch: %v", err)
+	}
+
+	// Блокировку перехватили, пока батч публиковался: батч откатывается
+	p.setLeaderStatus(false, "node-2")
+	if err := p.canCommit(nil); !errors.Is(err, errLeadershipLost) {
+		t.Errorf("expected errLeadershipLost, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package publisher
+
+import (
+	"fmt"
+)
+
+// Версии формата record_data в replication_queue.
+// Версию проставляет триггер generic_replication_trigger в колонку payload_version.
+// При любом изменении формата в триггере нужно завести новую версию
+// и добавить её разбор в DecodeRecordData.
+const (
+	// PayloadVersionV1: INSERT/DELETE - образ строки целиком,
+	// UPDATE - {"before": OLD, "after": NEW}
+	PayloadVersionV1 = 1
+
+	// CurrentPayloadVersion - версия, которую пишет актуальный триггер
+	CurrentPayloadVersion = PayloadVersionV1
+)
+
+// RowImages содержит образы строки до и после изменения
+type RowImages struct {
+	Before map[string]interface{}
+	After  map[string]interface{}
+}
+
+// DecodeRecordData разбирает record_data из replication_queue в образы строки
+// с учетом версии формата и типа операции
+func DecodeRecordData(payloadVersion int, operation string, recordData map[string]interface{}) (RowImages, error) {
+	switch payloadVersion {
+	case PayloadVersionV1:
+		return decodeRecordDataV1(operation, recordData)
+	default:
+		return RowImages{}, fmt.Errorf("unsupported payload version: %d", payloadVersion)
+	}
+}
+
+// decodeRecordDataV1 разбирает record_data формата версии 1
+func decodeRecordDataV1(operation string, recordData map[string]interface{}) (RowImages, error) {
+	if recordData == nil {
+		return RowImages{}, fmt.Errorf("record_data is empty")
+	}
+
+	switch operation {
+	case "INSERT":
+		return RowImages{After: recordData}, nil
+
+	case "UPDATE":
+		before, err := extractImage(recordData, "before")
+		if err != nil {
+			return RowImages{}, err
+		}
+		after, err := extractImage(recordData, "after")
+		if err != nil {
+			return RowImages{}, err
+		}
+		return RowImages{Before: before, After: after}, nil
+
+	case "DELETE":
+		return RowImages{Before: recordData}, nil
+
+	default:
+		return RowImages{}, fmt.Errorf("unknown operation: %s", operation)
+	}
+}
+
+// extractImage извлекает вложенный образ строки ("before" или "after") из record_data
+func extractImage(recordData map[string]interface{}, key string) (map[string]interface{}, error) {
+	raw, ok := recordData[key]
+	if !ok {
+		return nil, fmt.Errorf("UPDATE record_data must have '%s' image", key)
+	}
+
+	image, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("UPDATE record_data '%s' image must be an object, got %T", key, raw)
+	}
+
+	return image, nil
+}
+
+// softDeleteAsDelete превращает INSERT/UPDATE строки, помеченной удаленной (column не NULL),
+// в логический DELETE с образом строки после изменения - в нем последняя версия строки.
+// Изменения уже удаленной строки тоже публикуются как DELETE, чтобы не воскрешать ее
+// на контуре с физическим удалением.
+func softDeleteAsDelete(operation string, images RowImages, column string) (string, RowImages) {
+	if operation == "DELETE" || images.After == nil || images.After[column] == nil {
+		return operation, images
+	}
+	return "DELETE", RowImages{Before: images.After}
+}
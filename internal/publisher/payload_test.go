@@ -0,0 +1,93 @@
+package publisher
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeRecordData(t *testing.T) {
+	row := map[string]interface{}{"id": 1, "name": "a"}
+	updated := map[string]interface{}{"id": 1, "name": "b"}
+
+	tests := []struct {
+		name       string
+		version    int
+		operation  string
+		recordData map[string]interface{}
+		want       RowImages
+		wantErr    bool
+	}{
+		{name: "insert", version: PayloadVersionV1, operation: "INSERT", recordData: row, want: RowImages{After: row}},
+		{name: "delete", version: PayloadVersionV1, operation: "DELETE", recordData: row, want: RowImages{Before: row}},
+		{
+			name:       "update",
+			version:    PayloadVersionV1,
+			operation:  "UPDATE",
+			recordData: map[string]interface{}{"before": row, "after": updated},
+			want:       RowImages{Before: row, After: updated},
+		},
+		{
+			name:       "update without before",
+			version:    PayloadVersionV1,
+			operation:  "UPDATE",
+			recordData: map[string]interface{}{"after": updated},
+			wantErr:    true,
+		},
+		{
+			name:       "update with non-object image",
+			version:    PayloadVersionV1,
+			operation:  "UPDATE",
+			recordData: map[string]interface{}{"before": "row", "after": updated},
+			wantErr:    true,
+		},
+		{name: "empty record data", version: PayloadVersionV1, operation: "INSERT", recordData: nil, wantErr: true},
+		{name: "unknown operation", version: PayloadVersionV1, operation: "TRUNCATE", recordData: row, wantErr: true},
+		{name: "unsupported version", version: 2, operation: "INSERT", recordData: row, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeRecordData(tt.version, tt.operation, tt.recordData)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSoftDeleteAsDelete(t *testing.T) {
+	live := map[string]interface{}{"id": 1, "deleted_at": nil}
+	deleted := map[string]interface{}{"id": 1, "deleted_at": "2024-01-01T00:00:00Z"}
+
+	// Живая строка публикуется как есть
+	operation, images := softDeleteAsDelete("UPDATE", RowImages{Before: live, After: live}, "deleted_at")
+	if operation != "UPDATE" || !reflect.DeepEqual(images.After, live) {
+		t.Errorf("live row: got %s %+v", operation, images)
+	}
+
+	// Пометка удаления превращается в DELETE с последним образом строки
+	operation, images = softDeleteAsDelete("UPDATE", RowImages{Before: live, After: deleted}, "deleted_at")
+	if operation != "DELETE" || images.After != nil || !reflect.DeepEqual(images.Before, deleted) {
+		t.Errorf("soft deleted row: got %s %+v", operation, images)
+	}
+
+	// INSERT уже удаленной строки тоже не должен ее воскрешать
+	if operation, _ = softDeleteAsDelete("INSERT", RowImages{After: deleted}, "deleted_at"); operation != "DELETE" {
+		t.Errorf("insert of deleted row: got %s", operation)
+	}
+
+	// Физический DELETE не меняется
+	operation, images = softDeleteAsDelete("DELETE", RowImages{Before: live}, "deleted_at")
+	if operation != "DELETE" || !reflect.DeepEqual(images.Before, live) {
+		t.Errorf("delete: got %s %+v", operation, images)
+	}
+}
@@ -2,7 +2,10 @@ package publisher
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -10,6 +13,7 @@ import (
 
 	"github.com/vahtykov/go-replicator-service/internal/database"
 	"github.com/vahtykov/go-replicator-service/internal/kafka"
+	"github.com/vahtykov/go-replicator-service/internal/retry"
 )
 
 // Publisher читает replication_queue и публикует в Kafka
@@ -18,10 +22,20 @@ type Publisher struct {
 	producer     *kafka.Producer
 	config       Config
 	logger       zerolog.Logger
-	
-	// Метрики
-	processedCount int64
-	failedCount    int64
+	primaryKeys  *primaryKeyResolver
+	router       *TopicRouter
+	leader       leaderState
+	leases       *shardLeases // nil - шарды не арендуются
+
+	// Шарды экземпляра (при Sharding)
+	shardsMu    sync.Mutex
+	shards      []int
+	shardsUntil time.Time
+
+	// Метрики (обновляются воркерами шардов конкурентно)
+	processedCount   int64
+	failedCount      int64
+	quarantinedCount int64
 }
 
 // Config представляет конфигурацию Publisher
@@ -30,16 +44,69 @@ type Config struct {
 	Database     string
 	PollInterval time.Duration
 	BatchSize    int
+	// PublishMode - режим публикации батча: sync (по одной записи) или batch (конвейерно)
+	PublishMode string
+	// DeliveryTimeout - сколько ждать delivery reports батча в режиме batch
+	DeliveryTimeout time.Duration
+	// PrimaryKeys переопределяет колонки primary key для таблиц (иначе читаются из pg_index)
+	PrimaryKeys map[string][]string
+	// SoftDeletes - таблицы, мягкое удаление в которых публикуется как DELETE (schema.table -> колонка)
+	SoftDeletes map[string]string
+	// Listener - LISTEN-соединение для пробуждения по NOTIFY (nil - только опрос по таймеру)
+	Listener *database.Listener
+	// IdlePollInterval - интервал страховочного опроса, пока LISTEN-соединение активно
+	IdlePollInterval time.Duration
+	// Routing - маршрутизация событий по топикам
+	Routing RoutingConfig
+	// Leader - advisory lock выбора ведущего (nil - публикуют все экземпляры)
+	Leader *database.LeaderLock
+	// LeaderCheckInterval - как часто проверять блокировку ведущего и пытаться ее захватить
+	LeaderCheckInterval time.Duration
+	// Sharding - параллельная публикация по шардам ключей (nil - один поток)
+	Sharding *ShardingConfig
+	// Retry - повторные попытки публикации записи. После MaxAttempts неудачных попыток запись
+	// переносится в replication_queue_failed (только при ошибке самой записи: недоступность Kafka
+	// записи в карантин не переносит)
+	Retry retry.Policy
 }
 
+// NotifyChannel - канал NOTIFY, в который триггер на replication_queue сообщает о новых записях
+const NotifyChannel = "replication_queue"
+
+// listenerReconnectDelay - пауза перед повторным подключением LISTEN-соединения
+const listenerReconnectDelay = 5 * time.Second
+
+// Режимы публикации батча
+const (
+	PublishModeSync  = "sync"
+	PublishModeBatch = "batch"
+)
+
 // New создает новый Publisher
 func New(db *gorm.DB, producer *kafka.Producer, cfg Config, logger zerolog.Logger) *Publisher {
-	return &Publisher{
+	p := &Publisher{
 		db:       db,
 		producer: producer,
 		config:   cfg,
 		logger:   logger.With().Str("component", "publisher").Logger(),
+		primaryKeys: newPrimaryKeyResolver(db, cfg.PrimaryKeys),
+		router:      NewTopicRouter(cfg.Routing, cfg.Contour, cfg.Database),
+	}
+	if cfg.Leader != nil {
+		p.leader.status = LeaderStatus{Enabled: true, Identity: cfg.Leader.Identity(), Since: time.Now()}
 	}
+	if cfg.Sharding != nil {
+		if cfg.Sharding.Lease {
+			p.leases = newShardLeases(db, *cfg.Sharding, p.logger)
+		} else if len(cfg.Sharding.Shards) > 0 {
+			p.shards = cfg.Sharding.Shards
+		} else {
+			for shard := 0; shard < cfg.Sharding.Count; shard++ {
+				p.shards = append(p.shards, shard)
+			}
+		}
+	}
+	return p
 }
 
 // Start запускает процесс публикации
@@ -48,11 +115,38 @@ func (p *Publisher) Start(ctx context.Context) error {
 		Str("contour", p.config.Contour).
 		Dur("poll_interval", p.config.PollInterval).
 		Int("batch_size", p.config.BatchSize).
+		Str("publish_mode", p.config.PublishMode).
+		Str("routing_mode", p.router.config.Mode).
+		Bool("leader_election", p.config.Leader != nil).
 		Msg("Publisher started")
 
 	ticker := time.NewTicker(p.config.PollInterval)
 	defer ticker.Stop()
 
+	// Пробуждение по NOTIFY; при потере LISTEN-соединения работаем по таймеру PollInterval
+	wakeups := make(chan struct{}, 1)
+	listening := make(chan bool)
+	if p.config.Listener != nil {
+		go p.listen(ctx, wakeups, listening)
+	}
+
+	// Один активный publisher: публикует только владелец advisory lock
+	if p.config.Leader != nil {
+		go p.elect(ctx, wakeups)
+	}
+
+	// Аренда шардов продлевается чаще срока аренды, независимо от публикации
+	if p.leases != nil {
+		p.refreshShards(ctx)
+
+		renewed := make(chan struct{})
+		go p.renewLeases(ctx, wakeups, renewed)
+		defer func() {
+			<-renewed
+			p.leases.releaseAll(context.Background())
+		}()
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
@@ -60,24 +154,133 @@ func (p *Publisher) Start(ctx context.Context) error {
 			return ctx.Err()
 			
 		case <-ticker.C:
-			if err := p.processBatch(ctx); err != nil {
-				p.logger.Error().
-					Err(err).
-					Msg("Failed to process batch")
-				p.failedCount++
+			p.drain(ctx)
+
+		case <-wakeups:
+			p.drain(ctx)
+
+		case active := <-listening:
+			// Пока LISTEN активен, таймер нужен только как страховка
+			if active {
+				ticker.Reset(p.config.IdlePollInterval)
+			} else {
+				ticker.Reset(p.config.PollInterval)
+			}
+		}
+	}
+}
+
+// drain обрабатывает батчи, пока они возвращаются полными.
+// Резервный экземпляр (не ведущий) очередь не читает.
+func (p *Publisher) drain(ctx context.Context) {
+	if p.config.Sharding != nil {
+		p.drainShards(ctx)
+		return
+	}
+	p.drainQueue(ctx, nil)
+}
+
+// drainQueue обрабатывает батчи очереди (или шарда), пока они возвращаются полными
+func (p *Publisher) drainQueue(ctx context.Context, shard *shardFilter) {
+	for ctx.Err() == nil && p.leading() && p.ownsShard(shard) {
+		count, err := p.processBatch(ctx, shard)
+		if errors.Is(err, errLeadershipLost) {
+			p.logger.Warn().Msg("Leadership lost during batch, batch rolled back")
+			return
+		}
+		if errors.Is(err, errShardLost) {
+			p.logger.Warn().Int("shard", shard.ID).Msg("Shard lease lost during batch, batch rolled back")
+			return
+		}
+		if err != nil {
+			logEvent := p.logger.Error().Err(err)
+			if shard != nil {
+				logEvent = logEvent.Int("shard", shard.ID)
+			}
+			logEvent.Msg("Failed to process batch")
+			atomic.AddInt64(&p.failedCount, 1)
+			return
+		}
+
+		if count < p.config.BatchSize {
+			return
+		}
+	}
+}
+
+// listen держит LISTEN-соединение и будит основной цикл при уведомлениях.
+// Состояние соединения сообщается в listening, при потере соединение переустанавливается.
+func (p *Publisher) listen(ctx context.Context, wakeups chan<- struct{}, listening chan<- bool) {
+	listener := p.config.Listener
+	defer listener.Close()
+
+	for ctx.Err() == nil {
+		if err := listener.Connect(ctx); err != nil {
+			p.logger.Warn().
+				Err(err).
+				Dur("retry_in", listenerReconnectDelay).
+				Msg("Failed to start LISTEN, polling by ticker")
+			sleepContext(ctx, listenerReconnectDelay)
+			continue
+		}
+
+		setListening(ctx, listening, true)
+		// Забираем записи, накопившиеся пока LISTEN не работал
+		wakeUp(wakeups)
+
+		for {
+			if err := listener.Wait(ctx); err != nil {
+				if ctx.Err() == nil {
+					p.logger.Warn().
+						Err(err).
+						Msg("LISTEN connection lost, falling back to polling by ticker")
+				}
+				break
 			}
+			wakeUp(wakeups)
 		}
+
+		setListening(ctx, listening, false)
+		sleepContext(ctx, listenerReconnectDelay)
+	}
+}
+
+// wakeUp неблокирующе сигнализирует о новых записях (повторные сигналы схлопываются)
+func wakeUp(wakeups chan<- struct{}) {
+	select {
+	case wakeups <- struct{}{}:
+	default:
 	}
 }
 
-// processBatch обрабатывает один батч записей из replication_queue
-func (p *Publisher) processBatch(ctx context.Context) error {
+// setListening передает состояние LISTEN-соединения основному циклу
+func setListening(ctx context.Context, listening chan<- bool, active bool) {
+	select {
+	case listening <- active:
+	case <-ctx.Done():
+	}
+}
+
+// sleepContext ждет d или отмены контекста
+func sleepContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// processBatch обрабатывает один батч записей из replication_queue (shard - только записи шарда).
+// Возвращает количество выбранных из очереди записей.
+func (p *Publisher) processBatch(ctx context.Context, shard *shardFilter) (int, error) {
 	startTime := time.Now()
 	
 	// Начинаем транзакцию
 	tx := p.db.WithContext(ctx).Begin()
 	if tx.Error != nil {
-		return fmt.Errorf("failed to begin transaction: %w", tx.Error)
+		return 0, fmt.Errorf("failed to begin transaction: %w", tx.Error)
 	}
 	defer func() {
 		if r := recover(); r != nil {
@@ -87,111 +290,148 @@ func (p *Publisher) processBatch(ctx context.Context) error {
 	}()
 
 	// Читаем непубликованные записи с блокировкой
-	// FOR UPDATE SKIP LOCKED позволяет избежать deadlocks и масштабировать publisher
+	// Записи в ожидании повторной попытки пропускаются вместе с более поздними записями
+	// того же ключа (таблица и shard_key), чтобы не нарушить порядок по ключу.
 	var records []database.ReplicationQueue
-	result := tx.
-		Clauses(ForUpdateSkipLocked()).
+	result := shard.apply(tx).
 		Where("published = ?", false).
+		Where("next_attempt_at IS NULL OR next_attempt_at <= NOW()").
+		Where(`NOT EXISTS (SELECT 1 FROM replication_queue AS blocked
+			WHERE NOT blocked.published AND blocked.next_attempt_at > NOW()
+			  AND blocked.schema_name = replication_queue.schema_name
+			  AND blocked.table_name = replication_queue.table_name
+			  AND blocked.shard_key IS NOT DISTINCT FROM replication_queue.shard_key
+			  AND blocked.id < replication_queue.id)`).
 		Order("id ASC").
 		Limit(p.config.BatchSize).
 		Find(&records)
 
 	if result.Error != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to fetch records: %w", result.Error)
+		return 0, fmt.Errorf("failed to fetch records: %w", result.Error)
 	}
 
 	// Если записей нет, завершаем
 	if len(records) == 0 {
 		tx.Rollback()
-		return nil
+		return 0, nil
 	}
 
 	p.logger.Debug().
 		Int("count", len(records)).
 		Msg("Processing batch")
 
-	// Публикуем записи в Kafka
-	publishedIDs := make([]int64, 0, len(records))
-	
-	for _, record := range records {
-		if err := p.publishRecord(ctx, record); err != nil {
-			// При ошибке публикации откатываем всю транзакцию
+	// Публикуем записи в Kafka. Неопубликованная запись не останавливает батч:
+	// она уходит на повторную попытку с задержкой, остальные ключи публикуются дальше
+	var publishedIDs []int64
+	var failures []recordFailure
+
+	if p.config.PublishMode == PublishModeBatch {
+		// Конвейерная публикация: помечаем только доставленные записи
+		publishedIDs, failures = p.publishRecordsBatch(ctx, records)
+	} else {
+		publishedIDs, failures = p.publishRecordsSync(ctx, records)
+	}
+
+	// Помечаем записи как опубликованные
+	if len(publishedIDs) > 0 {
+		now := time.Now()
+		result = tx.Model(&database.ReplicationQueue{}).
+			Where("id IN ?", publishedIDs).
+			Updates(map[string]interface{}{
+				"published":    true,
+				"published_at": now,
+			})
+
+		if result.Error != nil {
 			tx.Rollback()
-			return fmt.Errorf("failed to publish record %d: %w", record.ID, err)
+			return len(records), fmt.Errorf("failed to update published status: %w", result.Error)
 		}
-		publishedIDs = append(publishedIDs, record.ID)
 	}
 
-	// Помечаем записи как опубликованные
-	now := time.Now()
-	result = tx.Model(&database.ReplicationQueue{}).
-		Where("id IN ?", publishedIDs).
-		Updates(map[string]interface{}{
-			"published":    true,
-			"published_at": now,
-		})
+	// Откладываем неопубликованные записи или переносим их в карантин
+	if err := p.settleFailures(tx, failures); err != nil {
+		tx.Rollback()
+		return len(records), err
+	}
 
-	if result.Error != nil {
+	// Записи могли отдать другому экземпляру, пока батч публиковался: не помечаем их,
+	// новый ведущий (владелец шарда) переопубликует батч (at-least-once) в исходном порядке
+	if err := p.canCommit(shard); err != nil {
 		tx.Rollback()
-		return fmt.Errorf("failed to update published status: %w", result.Error)
+		return len(records), err
 	}
 
 	// Коммитим транзакцию
 	if err := tx.Commit().Error; err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return len(records), fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	// Обновляем метрики
-	p.processedCount += int64(len(records))
+	processed := atomic.AddInt64(&p.processedCount, int64(len(publishedIDs)))
 	
 	elapsed := time.Since(startTime)
-	p.logger.Info().
-		Int("count", len(records)).
+	logEvent := p.logger.Info().
+		Int("count", len(publishedIDs)).
+		Int("fetched", len(records)).
 		Dur("duration_ms", elapsed).
-		Int64("total_processed", p.processedCount).
-		Msg("Batch published successfully")
+		Int64("total_processed", processed)
+	if shard != nil {
+		logEvent = logEvent.Int("shard", shard.ID)
+	}
+	logEvent.Msg("Batch published successfully")
 
-	return nil
+	// После ошибок публикации не разбираем очередь дальше подряд: при недоступности Kafka
+	// следующие батчи тоже не будут доставлены. Очередь продолжится со следующего опроса.
+	if len(failures) > 0 {
+		return len(publishedIDs), nil
+	}
+	return len(records), nil
 }
 
-// publishRecord публикует одну запись в Kafka
-func (p *Publisher) publishRecord(ctx context.Context, record database.ReplicationQueue) error {
-	// Конвертируем JSONB в map
-	recordData := map[string]interface{}(record.RecordData)
+// publishRecordsSync публикует записи батча по одной с ожиданием доставки каждой.
+// После неудачной записи более поздние записи того же ключа не публикуются (порядок по ключу),
+// а при недоступности Kafka публикация батча прекращается: остальные записи возьмет следующий батч.
+func (p *Publisher) publishRecordsSync(ctx context.Context, records []database.ReplicationQueue) ([]int64, []recordFailure) {
+	publishedIDs := make([]int64, 0, len(records))
+	var failures []recordFailure
+	blockedKeys := make(map[string]bool)
 
-	// Создаем событие репликации
-	event := NewReplicationEvent(
-		p.config.Contour,
-		p.config.Database,
-		record.Table,
-		record.Operation,
-		recordData,
-	)
+	for _, record := range records {
+		key := recordOrderKey(record)
+		if blockedKeys[key] {
+			continue
+		}
 
-	// Сериализуем в JSON
-	eventJSON, err := event.ToJSON()
-	if err != nil {
-		return fmt.Errorf("failed to serialize event: %w", err)
+		if err := p.publishRecord(ctx, record); err != nil {
+			failures = append(failures, recordFailure{record: record, err: err})
+			if kafka.IsRetriable(err) {
+				break
+			}
+			blockedKeys[key] = true
+			continue
+		}
+		publishedIDs = append(publishedIDs, record.ID)
 	}
 
-	// Определяем топик (table_name + "_changes")
-	topic := record.Table + "_changes"
+	return publishedIDs, failures
+}
 
-	// Partition key - primary key записи (для сохранения порядка)
-	partitionKey := []byte(record.PrimaryKeyValue)
-	if record.PrimaryKeyValue == "" {
-		partitionKey = event.ExtractPartitionKey()
+// publishRecord публикует одну запись в Kafka
+func (p *Publisher) publishRecord(ctx context.Context, record database.ReplicationQueue) error {
+	message, event, err := p.buildMessage(ctx, record)
+	if err != nil {
+		return err
 	}
 
 	// Публикуем в Kafka (синхронно для гарантии доставки)
-	if err := p.producer.Produce(topic, partitionKey, eventJSON); err != nil {
+	if err := p.producer.Produce(message.Topic, message.Key, message.Value); err != nil {
 		return fmt.Errorf("failed to produce to kafka: %w", err)
 	}
 
 	p.logger.Debug().
 		Str("event_id", event.EventID).
-		Str("topic", topic).
+		Str("topic", message.Topic).
 		Str("table", record.Table).
 		Str("operation", record.Operation).
 		Msg("Event published")
@@ -199,8 +439,72 @@ func (p *Publisher) publishRecord(ctx context.Context, record database.Replicati
 	return nil
 }
 
+// buildMessage строит событие репликации и сообщение Kafka для записи replication_queue
+func (p *Publisher) buildMessage(ctx context.Context, record database.ReplicationQueue) (kafka.Message, *ReplicationEvent, error) {
+	// Конвертируем JSONB в map
+	recordData := map[string]interface{}(record.RecordData)
+
+	// Разбираем record_data согласно версии формата
+	images, err := DecodeRecordData(record.PayloadVersion, record.Operation, recordData)
+	if err != nil {
+		return kafka.Message{}, nil, fmt.Errorf("failed to decode record_data: %w", err)
+	}
+
+	// Мягкое удаление публикуем как логический DELETE
+	operation := record.Operation
+	if column, ok := p.config.SoftDeletes[record.Schema+"."+record.Table]; ok {
+		operation, images = softDeleteAsDelete(operation, images, column)
+	}
+
+	// Определяем колонки primary key таблицы
+	primaryKey, err := p.primaryKeys.Resolve(ctx, record.Schema, record.Table)
+	if err != nil {
+		return kafka.Message{}, nil, fmt.Errorf("failed to resolve primary key: %w", err)
+	}
+
+	// Создаем событие репликации
+	event, err := NewReplicationEvent(
+		p.config.Contour,
+		p.config.Database,
+		record.Table,
+		operation,
+		primaryKey,
+		images,
+	)
+	if err != nil {
+		return kafka.Message{}, nil, fmt.Errorf("failed to build event: %w", err)
+	}
+	event.Schema = record.Schema
+
+	// Границы исходной транзакции (записи, созданные до появления tx_id, без них)
+	if record.TxID != nil && record.TxSeq != nil && record.TxSize != nil {
+		event.Transaction = &TransactionInfo{
+			ID:    *record.TxID,
+			Index: *record.TxSeq,
+			Count: *record.TxSize,
+		}
+	}
+
+	// Сериализуем в JSON
+	eventJSON, err := event.ToJSON()
+	if err != nil {
+		return kafka.Message{}, nil, fmt.Errorf("failed to serialize event: %w", err)
+	}
+
+	// Определяем топик и partition key (все колонки primary key - для сохранения порядка)
+	topic, partitionKey := p.router.Route(record.Schema, event)
+
+	return kafka.Message{
+		Topic: topic,
+		Key:   partitionKey,
+		Value: eventJSON,
+	}, event, nil
+}
+
 // GetMetrics возвращает метрики publisher
-func (p *Publisher) GetMetrics() (processed int64, failed int64) {
-	return p.processedCount, p.failedCount
+func (p *Publisher) GetMetrics() (processed, failed, quarantined int64) {
+	return atomic.LoadInt64(&p.processedCount),
+		atomic.LoadInt64(&p.failedCount),
+		atomic.LoadInt64(&p.quarantinedCount)
 }
 
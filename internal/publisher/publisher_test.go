@@ -0,0 +1,57 @@
+package publisher
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWakeUpCoalescesSignals(t *testing.T) {
+	wakeups := make(chan struct{}, 1)
+
+	// Уведомления, пришедшие во время drain, схлопываются в одно пробуждение
+	// и не блокируют LISTEN-горутину
+	wakeUp(wakeups)
+	wakeUp(wakeups)
+	wakeUp(wakeups)
+
+	if len(wakeups) != 1 {
+		t.Fatalf("got %d pending wakeups, want 1", len(wakeups))
+	}
+	<-wakeups
+
+	select {
+	case <-wakeups:
+		t.Fatal("unexpected second wakeup")
+	default:
+	}
+}
+
+func TestSleepContextReturnsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	started := time.Now()
+	sleepContext(ctx, time.Minute)
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("sleepContext ignored cancellation, slept %v", elapsed)
+	}
+}
+
+func TestSetListeningDoesNotBlockAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	done := make(chan struct{})
+	go func() {
+		// Основной цикл уже остановлен и не читает состояние
+		setListening(ctx, make(chan bool), true)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("setListening blocked after context cancel")
+	}
+}
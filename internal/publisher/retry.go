@@ -0,0 +1,97 @@
+package publisher
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+	"github.com/vahtykov/go-replicator-service/internal/kafka"
+)
+
+// recordFailure - запись, которую не удалось опубликовать
+type recordFailure struct {
+	record database.ReplicationQueue
+	err    error
+}
+
+// recordOrderKey возвращает ключ порядка записи: таблица и shard_key (хеш primary key).
+// Записи без shard_key (созданные до его появления) упорядочиваются в пределах таблицы.
+func recordOrderKey(record database.ReplicationQueue) string {
+	if record.ShardKey == nil {
+		return record.Schema + "." + record.Table
+	}
+	return fmt.Sprintf("%s.%s/%d", record.Schema, record.Table, *record.ShardKey)
+}
+
+// settleFailures откладывает неопубликованные записи на следующую попытку,
+// а исчерпавшие попытки переносит в replication_queue_failed
+func (p *Publisher) settleFailures(tx *gorm.DB, failures []recordFailure) error {
+	for _, failure := range failures {
+		record := failure.record
+		attempts := record.Attempts + 1
+		lastError := failure.err.Error()
+		atomic.AddInt64(&p.failedCount, 1)
+
+		if attempts >= p.config.Retry.MaxAttempts && !kafka.IsRetriable(failure.err) {
+			if err := p.quarantine(tx, record, attempts, lastError); err != nil {
+				return err
+			}
+			continue
+		}
+
+		backoff := p.config.Retry.Backoff(attempts)
+		err := tx.Model(&database.ReplicationQueue{}).
+			Where("id = ?", record.ID).
+			Updates(map[string]interface{}{
+				"attempts":        attempts,
+				"last_error":      lastError,
+				"next_attempt_at": time.Now().Add(backoff),
+			}).Error
+		if err != nil {
+			return fmt.Errorf("failed to schedule retry of record %d: %w", record.ID, err)
+		}
+
+		p.logger.Error().
+			Err(failure.err).
+			Int64("record_id", record.ID).
+			Str("table", record.Table).
+			Str("operation", record.Operation).
+			Int("attempt", attempts).
+			Dur("retry_in", backoff).
+			Msg("Failed to publish record, retrying later")
+	}
+
+	return nil
+}
+
+// quarantine переносит запись в replication_queue_failed, чтобы она не задерживала очередь
+func (p *Publisher) quarantine(tx *gorm.DB, record database.ReplicationQueue, attempts int, lastError string) error {
+	err := tx.Exec(`INSERT INTO replication_queue_failed
+			(id, schema_name, table_name, operation, record_data, payload_version, tx_id, tx_seq, tx_size, shard_key, created_at, attempts, last_error)
+		SELECT id, schema_name, table_name, operation, record_data, payload_version, tx_id, tx_seq, tx_size, shard_key, created_at, ?, ?
+		FROM replication_queue WHERE id = ?
+		ON CONFLICT (id) DO UPDATE
+		SET attempts = EXCLUDED.attempts, last_error = EXCLUDED.last_error, failed_at = NOW()`,
+		attempts, lastError, record.ID).Error
+	if err != nil {
+		return fmt.Errorf("failed to quarantine record %d: %w", record.ID, err)
+	}
+
+	if err := tx.Delete(&database.ReplicationQueue{}, record.ID).Error; err != nil {
+		return fmt.Errorf("failed to remove quarantined record %d: %w", record.ID, err)
+	}
+
+	atomic.AddInt64(&p.quarantinedCount, 1)
+	p.logger.Error().
+		Int64("record_id", record.ID).
+		Str("table", record.Table).
+		Str("operation", record.Operation).
+		Int("attempts", attempts).
+		Str("last_error", lastError).
+		Msg("Record moved to replication_queue_failed after exhausting attempts")
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package publisher
+
+import (
+	"strings"
+)
+
+// Режимы маршрутизации событий по топикам
+const (
+	// RoutingModePerTable - свой топик на каждую таблицу (явный или по шаблону)
+	RoutingModePerTable = "per_table"
+	// RoutingModeShared - один общий топик, partition key содержит имя таблицы
+	RoutingModeShared = "shared"
+)
+
+// DefaultTopicTemplate - шаблон имени топика по умолчанию
+const DefaultTopicTemplate = "{table}_changes"
+
+// RoutingConfig представляет настройки маршрутизации событий по топикам
+type RoutingConfig struct {
+	Mode          string            // per_table, shared
+	TopicTemplate string            // Шаблон с плейсхолдерами {contour}, {database}, {schema}, {table}
+	SharedTopic   string            // Топик для режима shared
+	TableTopics   map[string]string // Явно заданные топики таблиц schema.table (приоритетнее шаблона)
+}
+
+// TopicRouter определяет топик Kafka и partition key для событий
+type TopicRouter struct {
+	config   RoutingConfig
+	contour  string
+	database string
+}
+
+// NewTopicRouter создает TopicRouter
+func NewTopicRouter(cfg RoutingConfig, contour string, database string) *TopicRouter {
+	if cfg.Mode == "" {
+		cfg.Mode = RoutingModePerTable
+	}
+	if cfg.TopicTemplate == "" {
+		cfg.TopicTemplate = DefaultTopicTemplate
+	}
+
+	return &TopicRouter{
+		config:   cfg,
+		contour:  contour,
+		database: database,
+	}
+}
+
+// Route возвращает топик и partition key для события таблицы schema.table
+func (r *TopicRouter) Route(schema string, event *ReplicationEvent) (string, []byte) {
+	if r.config.Mode == RoutingModeShared {
+		// В общем топике ключ должен различать одинаковые primary key разных таблиц
+		key := append([]byte(event.Table+"|"), event.ExtractPartitionKey()...)
+		return r.config.SharedTopic, key
+	}
+
+	return r.TopicFor(schema, event.Table), event.ExtractPartitionKey()
+}
+
+// TopicFor возвращает топик таблицы в режиме per_table
+func (r *TopicRouter) TopicFor(schema string, table string) string {
+	if schema == "" {
+		schema = "public"
+	}
+
+	if topic, ok := r.config.TableTopics[schema+"."+table]; ok && topic != "" {
+		return topic
+	}
+
+	replacer := strings.NewReplacer(
+		"{contour}", r.contour,
+		"{database}", r.database,
+		"{schema}", schema,
+		"{table}", table,
+	)
+	return replacer.Replace(r.config.TopicTemplate)
+}
@@ -0,0 +1,82 @@
+package publisher
+
+import "testing"
+
+func TestTopicRouterTopicFor(t *testing.T) {
+	tests := []struct {
+		name   string
+		config RoutingConfig
+		schema string
+		table  string
+		want   string
+	}{
+		{name: "default template", schema: "public", table: "users", want: "users_changes"},
+		{
+			name:   "all placeholders",
+			config: RoutingConfig{TopicTemplate: "{contour}.{database}.{schema}.{table}"},
+			schema: "billing",
+			table:  "invoices",
+			want:   "A.crm.billing.invoices",
+		},
+		{
+			name:   "empty schema is public",
+			config: RoutingConfig{TopicTemplate: "{schema}.{table}"},
+			table:  "users",
+			want:   "public.users",
+		},
+		{
+			name:   "explicit topic wins over template",
+			config: RoutingConfig{TableTopics: map[string]string{"public.users": "accounts_stream"}},
+			schema: "public",
+			table:  "users",
+			want:   "accounts_stream",
+		},
+		{
+			name:   "other tables still use template",
+			config: RoutingConfig{TableTopics: map[string]string{"public.users": "accounts_stream"}},
+			schema: "public",
+			table:  "orders",
+			want:   "orders_changes",
+		},
+		{
+			name:   "explicit topic is bound to its schema",
+			config: RoutingConfig{TableTopics: map[string]string{"public.users": "accounts_stream"}},
+			schema: "billing",
+			table:  "users",
+			want:   "users_changes",
+		},
+		{
+			name:   "explicit topic for empty schema",
+			config: RoutingConfig{TableTopics: map[string]string{"public.users": "accounts_stream"}},
+			table:  "users",
+			want:   "accounts_stream",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := NewTopicRouter(tt.config, "A", "crm")
+			if got := router.TopicFor(tt.schema, tt.table); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTopicRouterSharedKeyIncludesTable(t *testing.T) {
+	router := NewTopicRouter(RoutingConfig{Mode: RoutingModeShared, SharedTopic: "replication"}, "A", "crm")
+
+	users := &ReplicationEvent{Table: "users", PrimaryKeyColumns: []string{"id"}, PrimaryKey: map[string]interface{}{"id": 7}}
+	orders := &ReplicationEvent{Table: "orders", PrimaryKeyColumns: []string{"id"}, PrimaryKey: map[string]interface{}{"id": 7}}
+
+	topic, usersKey := router.Route("public", users)
+	_, ordersKey := router.Route("public", orders)
+
+	if topic != "replication" {
+		t.Errorf("topic = %q, want shared topic", topic)
+	}
+	// Одинаковый primary key разных таблиц не должен попадать в один ключ
+	if string(usersKey) != "users|7" || string(ordersKey) != "orders|7" {
+		t.Errorf("keys = %q, %q", usersKey, ordersKey)
+	}
+}
@@ -0,0 +1,257 @@
+package publisher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ShardingConfig - параллельная публикация по шардам: запись попадает в шард
+// shard_key mod Count, где shard_key - хеш таблицы и primary key (вычисляет триггер).
+// Изменения одной строки всегда в одном шарде и публикуются одним воркером по порядку id.
+type ShardingConfig struct {
+	Count  int   // Число шардов
+	Shards []int // Шарды этого экземпляра без аренды (пусто - все)
+
+	// Lease - шарды распределяются между экземплярами арендой в replication_shard_leases
+	Lease    bool
+	Identity string        // Идентификатор экземпляра (владелец аренды)
+	LeaseTTL time.Duration // Срок аренды; продлевается каждые LeaseTTL/3
+}
+
+// errShardLost - аренда шарда истекла или шард отдан другому экземпляру, пока публиковался батч
+var errShardLost = errors.New("shard lease lost before batch commit")
+
+// shardFilter - шард, который обрабатывает воркер
+type shardFilter struct {
+	ID    int
+	Count int
+}
+
+// apply ограничивает выборку записями шарда. Записи шарда блокируются FOR UPDATE без SKIP LOCKED:
+// если шард перешел к другому воркеру, тот дождется коммита предыдущего батча и не обгонит его.
+func (s *shardFilter) apply(query *gorm.DB) *gorm.DB {
+	if s == nil {
+		// FOR UPDATE SKIP LOCKED позволяет избежать deadlocks и масштабировать publisher
+		return query.Clauses(ForUpdateSkipLocked())
+	}
+	return query.
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("mod(COALESCE(shard_key, 0)::bigint + 2147483648, ?) = ?", s.Count, s.ID)
+}
+
+// ownedShards возвращает шарды, которые обрабатывает экземпляр
+func (p *Publisher) ownedShards() []shardFilter {
+	p.shardsMu.Lock()
+	defer p.shardsMu.Unlock()
+
+	shards := make([]shardFilter, len(p.shards))
+	for i, id := range p.shards {
+		shards[i] = shardFilter{ID: id, Count: p.config.Sharding.Count}
+	}
+	return shards
+}
+
+// ownsShard определяет, может ли воркер публиковать шард: шард по-прежнему за экземпляром
+// и его аренда не истекла. Без аренды шарды закреплены за экземпляром статически.
+func (p *Publisher) ownsShard(shard *shardFilter) bool {
+	if shard == nil || p.leases == nil {
+		return true
+	}
+
+	p.shardsMu.Lock()
+	defer p.shardsMu.Unlock()
+
+	if !time.Now().Before(p.shardsUntil) {
+		return false
+	}
+	for _, id := range p.shards {
+		if id == shard.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// drainShards публикует шарды экземпляра параллельно, по воркеру на шард
+func (p *Publisher) drainShards(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, shard := range p.ownedShards() {
+		shard := shard
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.drainQueue(ctx, &shard)
+		}()
+	}
+	wg.Wait()
+}
+
+// renewLeases продлевает аренду шардов в отдельной горутине: долгий drain не задерживает
+// продление, иначе аренда истекла бы посреди публикации шарда. При получении новых шардов
+// будит основной цикл. Закрывает done при остановке.
+func (p *Publisher) renewLeases(ctx context.Context, wakeups chan<- struct{}, done chan<- struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(p.config.Sharding.LeaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if p.refreshShards(ctx) {
+				wakeUp(wakeups)
+			}
+		}
+	}
+}
+
+// refreshShards продлевает аренду шардов и перераспределяет их между живыми экземплярами.
+// Возвращает true, если набор шардов изменился.
+func (p *Publisher) refreshShards(ctx context.Context) bool {
+	shards, until, err := p.leases.refresh(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			p.logger.Warn().Err(err).Msg("Failed to refresh shard leases")
+		}
+		return false
+	}
+
+	p.shardsMu.Lock()
+	changed := fmt.Sprint(shards) != fmt.Sprint(p.shards)
+	p.shards = shards
+	p.shardsUntil = until
+	p.shardsMu.Unlock()
+
+	if changed {
+		p.logger.Info().
+			Ints("shards", shards).
+			Int("shard_count", p.config.Sharding.Count).
+			Str("identity", p.config.Sharding.Identity).
+			Msg("Shard leases changed")
+	}
+	return changed
+}
+
+// shardLeases распределяет шарды между экземплярами через replication_shard_leases.
+// Каждый экземпляр держит не больше ceil(Count / число живых экземпляров) шардов,
+// лишние освобождает, свободные и просроченные забирает.
+type shardLeases struct {
+	db       *gorm.DB
+	count    int
+	identity string
+	ttl      time.Duration
+	logger   zerolog.Logger
+
+	initialized bool
+}
+
+// newShardLeases создает распределение шардов
+func newShardLeases(db *gorm.DB, cfg ShardingConfig, logger zerolog.Logger) *shardLeases {
+	return &shardLeases{
+		db:       db,
+		count:    cfg.Count,
+		identity: cfg.Identity,
+		ttl:      cfg.LeaseTTL,
+		logger:   logger,
+	}
+}
+
+// refresh продлевает аренду и возвращает шарды экземпляра и срок, до которого они арендованы.
+// Батч освобожденного шарда, публикуемый в этот момент, не будет закоммичен (ownsShard),
+// а новый владелец дождется его отката на FOR UPDATE.
+func (l *shardLeases) refresh(ctx context.Context) ([]int, time.Time, error) {
+	db := l.db.WithContext(ctx)
+	started := time.Now()
+	ttl := l.ttl.Milliseconds()
+
+	if !l.initialized {
+		err := db.Exec(`INSERT INTO replication_shard_leases (shard)
+			SELECT generate_series(0, ? - 1)
+			ON CONFLICT (shard) DO NOTHING`, l.count).Error
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to create shard leases: %w", err)
+		}
+		l.initialized = true
+	}
+
+	// 1. Продлеваем свою аренду
+	var owned []int
+	err := db.Raw(`UPDATE replication_shard_leases
+		SET lease_until = NOW() + ? * INTERVAL '1 millisecond', updated_at = NOW()
+		WHERE owner = ? AND shard < ? AND lease_until > NOW()
+		RETURNING shard`, ttl, l.identity, l.count).Scan(&owned).Error
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to renew shard leases: %w", err)
+	}
+
+	// 2. Доля экземпляра - поровну между живыми экземплярами
+	var others int64
+	err = db.Raw(`SELECT COUNT(DISTINCT owner) FROM replication_shard_leases
+		WHERE owner IS NOT NULL AND owner <> ? AND shard < ? AND lease_until > NOW()`,
+		l.identity, l.count).Scan(&others).Error
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("failed to count shard owners: %w", err)
+	}
+	target := leaseShare(l.count, int(others)+1)
+
+	switch {
+	case len(owned) < target:
+		// 3. Забираем свободные и просроченные шарды
+		var claimed []int
+		err = db.Raw(`UPDATE replication_shard_leases
+			SET owner = ?, lease_until = NOW() + ? * INTERVAL '1 millisecond', updated_at = NOW()
+			WHERE shard IN (
+				SELECT shard FROM replication_shard_leases
+				WHERE shard < ? AND (owner IS NULL OR lease_until IS NULL OR lease_until <= NOW())
+				ORDER BY shard
+				LIMIT ?
+				FOR UPDATE SKIP LOCKED
+			)
+			RETURNING shard`, l.identity, ttl, l.count, target-len(owned)).Scan(&claimed).Error
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to claim shard leases: %w", err)
+		}
+		owned = append(owned, claimed...)
+
+	case len(owned) > target:
+		// 4. Отдаем лишние шарды новым экземплярам
+		sort.Ints(owned)
+		released := owned[target:]
+		err = db.Exec(`UPDATE replication_shard_leases
+			SET owner = NULL, lease_until = NULL, updated_at = NOW()
+			WHERE owner = ? AND shard IN ?`, l.identity, released).Error
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("failed to release shard leases: %w", err)
+		}
+		owned = owned[:target]
+	}
+
+	sort.Ints(owned)
+	return owned, started.Add(l.ttl), nil
+}
+
+// leaseShare возвращает число шардов, которое арендует экземпляр: count делится поровну
+// между instances с округлением вверх, чтобы за экземплярами не оставалось свободных шардов
+func leaseShare(count, instances int) int {
+	return (count + instances - 1) / instances
+}
+
+// releaseAll освобождает аренду экземпляра (при остановке), чтобы шарды сразу забрали другие
+func (l *shardLeases) releaseAll(ctx context.Context) {
+	err := l.db.WithContext(ctx).Exec(`UPDATE replication_shard_leases
+		SET owner = NULL, lease_until = NULL, updated_at = NOW()
+		WHERE owner = ?`, l.identity).Error
+	if err != nil {
+		l.logger.Warn().Err(err).Msg("Failed to release shard leases")
+	}
+}
@@ -0,0 +1,106 @@
+package publisher
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+func TestShardFilterApply(t *testing.T) {
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+	})
+	if err != nil {
+		t.Fatalf("gorm.Open: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		shard    *shardFilter
+		wantSQL  string
+		wantVars []interface{}
+	}{
+		{
+			name:  "shard of hashed keys",
+			shard: &shardFilter{ID: 3, Count: 8},
+			// hashtext() возвращает int4 со знаком: сдвиг на 2^31 дает неотрицательный остаток
+			wantSQL:  `SELECT * FROM "replication_queue" WHERE mod(COALESCE(shard_key, 0)::bigint + 2147483648, $1) = $2 FOR UPDATE`,
+			wantVars: []interface{}{8, 3},
+		},
+		{
+			name:     "without sharding",
+			shard:    nil,
+			wantSQL:  `SELECT * FROM "replication_queue" FOR UPDATE SKIP LOCKED`,
+			wantVars: []interface{}{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []database.ReplicationQueue
+			stmt := tt.shard.apply(db.Model(&database.ReplicationQueue{})).Find(&records).Statement
+
+			if got := stmt.SQL.String(); got != tt.wantSQL {
+				t.Errorf("got SQL %s\nwant %s", got, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(stmt.Vars, tt.wantVars) {
+				t.Errorf("got vars %v, want %v", stmt.Vars, tt.wantVars)
+			}
+		})
+	}
+}
+
+func TestLeaseShare(t *testing.T) {
+	tests := []struct {
+		count, instances, want int
+	}{
+		{count: 16, instances: 1, want: 16},
+		{count: 16, instances: 2, want: 8},
+		{count: 16, instances: 3, want: 6},
+		{count: 3, instances: 5, want: 1},
+		{count: 1, instances: 1, want: 1},
+	}
+
+	for _, tt := range tests {
+		got := leaseShare(tt.count, tt.instances)
+		if got != tt.want {
+			t.Errorf("leaseShare(%d, %d) = %d, want %d", tt.count, tt.instances, got, tt.want)
+		}
+		// Доли всех экземпляров покрывают все шарды
+		if got*tt.instances < tt.count {
+			t.Errorf("leaseShare(%d, %d) = %d leaves shards unowned", tt.count, tt.instances, got)
+		}
+	}
+}
+
+func TestCanCommitAfterShardLost(t *testing.T) {
+	p := &Publisher{
+		leases:      &shardLeases{},
+		shards:      []int{1, 2},
+		shardsUntil: time.Now().Add(time.Minute),
+	}
+
+	if err := p.canCommit(&shardFilter{ID: 2, Count: 4}); err != nil {
+		t.Fatalf("owner must commit its batch: %v", err)
+	}
+
+	// Шард перешел к другому экземпляру, пока батч публиковался
+	p.shards = []int{1}
+	if err := p.canCommit(&shardFilter{ID: 2, Count: 4}); !errors.Is(err, errShardLost) {
+		t.Errorf("expected errShardLost for released shard, got %v", err)
+	}
+
+	// Аренда истекла, не успев продлиться
+	p.shards = []int{1, 2}
+	p.shardsUntil = time.Now().Add(-time.Second)
+	if err := p.canCommit(&shardFilter{ID: 2, Count: 4}); !errors.Is(err, errShardLost) {
+		t.Errorf("expected errShardLost for expired lease, got %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package retention
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-co-op/gocron"
+	"github.com/rs/zerolog"
+	"gorm.io/gorm"
+
+	"github.com/vahtykov/go-replicator-service/internal/database"
+)
+
+// Job - очистка одной таблицы: удаляются строки, у которых Column старше MaxAge.
+// Если задана Function, батч удаляет SQL-функция БД: правила отбора строк живут в одном месте
+// с функцией ручной очистки.
+type Job struct {
+	Table    string        // Таблица
+	Column   string        // Колонка времени, по которой считается возраст строки
+	Function string        // SQL-функция (cutoff, batch_size), удаляющая батч и возвращающая число строк
+	MaxAge   time.Duration // Срок хранения
+}
+
+// Config содержит настройки очистки
+type Config struct {
+	Schedule   string        // Cron-выражение запуска
+	Window     Window        // Время суток, в которое разрешено удалять строки
+	BatchSize  int           // Сколько строк удалять одной транзакцией
+	BatchPause time.Duration // Пауза между батчами
+	Jobs       []Job
+}
+
+// Cleaner по расписанию удаляет устаревшие строки служебных таблиц.
+// Батчи небольшие и коммитятся по отдельности, чтобы не держать долгие блокировки.
+// Очистку выполняет только экземпляр, захвативший advisory lock: остальные пропускают запуск.
+type Cleaner struct {
+	db     *gorm.DB
+	lock   *database.LeaderLock
+	config Config
+	window window
+	logger zerolog.Logger
+
+	removed map[string]*int64 // Таблица -> сколько строк удалено
+}
+
+// New создает очистку. lock - блокировка, общая для экземпляров сервиса на контуре.
+func New(db *gorm.DB, lock *database.LeaderLock, cfg Config, logger zerolog.Logger) (*Cleaner, error) {
+	w, err := cfg.Window.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make(map[string]*int64, len(cfg.Jobs))
+	for _, job := range cfg.Jobs {
+		removed[job.Table] = new(int64)
+	}
+
+	return &Cleaner{
+		db:      db,
+		lock:    lock,
+		config:  cfg,
+		window:  w,
+		logger:  logger.With().Str("component", "retention").Logger(),
+		removed: removed,
+	}, nil
+}
+
+// Start запускает очистку по расписанию и блокируется до отмены контекста
+func (c *Cleaner) Start(ctx context.Context) error {
+	scheduler := gocron.NewScheduler(time.Local)
+	scheduler.SingletonModeAll()
+
+	_, err := scheduler.Cron(c.config.Schedule).Name("retention").Do(c.run, ctx)
+	if err != nil {
+		return fmt.Errorf("failed to schedule retention: %w", err)
+	}
+
+	c.logger.Info().
+		Str("schedule", c.config.Schedule).
+		Str("window", c.config.Window.String()).
+		Int("batch_size", c.config.BatchSize).
+		Msg("Retention scheduled")
+
+	scheduler.StartAsync()
+	<-ctx.Done()
+	scheduler.Stop()
+
+	return ctx.Err()
+}
+
+// Removed возвращает число удаленных строк по таблицам
+func (c *Cleaner) Removed() map[string]int64 {
+	removed := make(map[string]int64, len(c.removed))
+	for table, count := range c.removed {
+		removed[table] = atomic.LoadInt64(count)
+	}
+	return removed
+}
+
+// run выполняет очистку всех таблиц, если открыто окно и блокировка свободна
+func (c *Cleaner) run(ctx context.Context) {
+	if !c.window.contains(time.Now()) {
+		c.logger.Debug().Msg("Outside retention window, skipping run")
+		return
+	}
+
+	acquired, err := c.lock.TryAcquire(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			c.logger.Warn().Err(err).Msg("Failed to acquire retention lock")
+		}
+		return
+	}
+	if !acquired {
+		c.logger.Debug().Msg("Retention is running on another instance, skipping run")
+		return
+	}
+	defer c.lock.Release(context.Background())
+
+	for _, job := range c.config.Jobs {
+		if err := c.cleanup(ctx, job); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			c.logger.Error().
+				Err(err).
+				Str("table", job.Table).
+				Msg("Failed to cleanup table")
+		}
+	}
+}
+
+// cleanup удаляет устаревшие строки таблицы батчами, пока они есть и открыто окно
+func (c *Cleaner) cleanup(ctx context.Context, job Job) error {
+	cutoff := time.Now().Add(-job.MaxAge)
+	started := time.Now()
+	var total int64
+
+	for {
+		if !c.window.contains(time.Now()) {
+			c.logger.Info().
+				Str("table", job.Table).
+				Int64("deleted", total).
+				Msg("Retention window closed, cleanup postponed")
+			break
+		}
+
+		deleted, err := c.deleteBatch(ctx, job, cutoff)
+		if err != nil {
+			return fmt.Errorf("failed to delete expired rows: %w", err)
+		}
+		total += deleted
+		atomic.AddInt64(c.removed[job.Table], deleted)
+
+		if deleted < int64(c.config.BatchSize) {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.config.BatchPause):
+		}
+	}
+
+	if total > 0 {
+		c.logger.Info().
+			Str("table", job.Table).
+			Int64("deleted", total).
+			Dur("retention", job.MaxAge).
+			Int64("duration_ms", time.Since(started).Milliseconds()).
+			Msg("Expired rows removed")
+	}
+	return nil
+}
+
+// deleteBatch удаляет один батч устаревших строк и возвращает их число
+func (c *Cleaner) deleteBatch(ctx context.Context, job Job, cutoff time.Time) (int64, error) {
+	if job.Function != "" {
+		var deleted int64
+		err := c.db.WithContext(ctx).Raw(batchQuery(job), cutoff, c.config.BatchSize).Scan(&deleted).Error
+		return deleted, err
+	}
+
+	result := c.db.WithContext(ctx).Exec(batchQuery(job), cutoff, c.config.BatchSize)
+	return result.RowsAffected, result.Error
+}
+
+// batchQuery возвращает запрос удаления батча с параметрами cutoff и batch_size
+func batchQuery(job Job) string {
+	if job.Function != "" {
+		return fmt.Sprintf("SELECT %s(?, ?)", job.Function)
+	}
+	// ctid позволяет удалять батчами без знания primary key таблицы
+	return fmt.Sprintf(`DELETE FROM %[1]s WHERE ctid = ANY(ARRAY(
+		SELECT ctid FROM %[1]s WHERE %[2]s < ? LIMIT ?))`, job.Table, job.Column)
+}
@@ -0,0 +1,21 @@
+package retention
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBatchQuery(t *testing.T) {
+	// Правила отбора записей replication_queue задает SQL-функция, а не запрос сервиса
+	query := batchQuery(Job{Table: "replication_queue", Function: "cleanup_replication_queue_batch"})
+	if query != "SELECT cleanup_replication_queue_batch(?, ?)" {
+		t.Errorf("function query = %q", query)
+	}
+
+	query = batchQuery(Job{Table: "processed_events", Column: "processed_at"})
+	for _, part := range []string{"DELETE FROM processed_events", "WHERE processed_at < ?", "LIMIT ?"} {
+		if !strings.Contains(query, part) {
+			t.Errorf("query %q does not contain %q", query, part)
+		}
+	}
+}
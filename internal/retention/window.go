@@ -0,0 +1,62 @@
+package retention
+
+import (
+	"fmt"
+	"time"
+)
+
+// Window - интервал времени суток в формате "HH:MM" (по локальному времени).
+// End меньше Start - интервал через полночь. Пустой Window - очистка в любое время.
+type Window struct {
+	Start string
+	End   string
+}
+
+// String возвращает интервал для логов
+func (w Window) String() string {
+	if w.Start == "" && w.End == "" {
+		return "any"
+	}
+	return w.Start + "-" + w.End
+}
+
+// window - разобранный интервал в минутах от начала суток
+type window struct {
+	enabled    bool
+	start, end int
+}
+
+// parse разбирает интервал
+func (w Window) parse() (window, error) {
+	if w.Start == "" && w.End == "" {
+		return window{}, nil
+	}
+
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return window{}, fmt.Errorf("invalid retention window start %q: %w", w.Start, err)
+	}
+	end, err := time.Parse("15:04", w.End)
+	if err != nil {
+		return window{}, fmt.Errorf("invalid retention window end %q: %w", w.End, err)
+	}
+
+	return window{
+		enabled: true,
+		start:   start.Hour()*60 + start.Minute(),
+		end:     end.Hour()*60 + end.Minute(),
+	}, nil
+}
+
+// contains определяет, попадает ли момент в интервал
+func (w window) contains(t time.Time) bool {
+	if !w.enabled {
+		return true
+	}
+
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
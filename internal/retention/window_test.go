@@ -0,0 +1,61 @@
+package retention
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWindowContains(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, err := time.Parse("15:04", clock)
+		if err != nil {
+			t.Fatalf("invalid time %q: %v", clock, err)
+		}
+		return time.Date(2024, 3, 1, parsed.Hour(), parsed.Minute(), 0, 0, time.Local)
+	}
+
+	tests := []struct {
+		name   string
+		window Window
+		now    string
+		want   bool
+	}{
+		{name: "any time", window: Window{}, now: "13:37", want: true},
+
+		{name: "inside daytime window", window: Window{Start: "01:00", End: "05:00"}, now: "03:00", want: true},
+		{name: "start is inclusive", window: Window{Start: "01:00", End: "05:00"}, now: "01:00", want: true},
+		{name: "end is exclusive", window: Window{Start: "01:00", End: "05:00"}, now: "05:00", want: false},
+		{name: "before daytime window", window: Window{Start: "01:00", End: "05:00"}, now: "00:59", want: false},
+
+		{name: "across midnight before midnight", window: Window{Start: "22:00", End: "03:00"}, now: "23:30", want: true},
+		{name: "across midnight at midnight", window: Window{Start: "22:00", End: "03:00"}, now: "00:00", want: true},
+		{name: "across midnight after midnight", window: Window{Start: "22:00", End: "03:00"}, now: "02:59", want: true},
+		{name: "across midnight end is exclusive", window: Window{Start: "22:00", End: "03:00"}, now: "03:00", want: false},
+		{name: "across midnight outside", window: Window{Start: "22:00", End: "03:00"}, now: "12:00", want: false},
+		{name: "across midnight start is inclusive", window: Window{Start: "22:00", End: "03:00"}, now: "22:00", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w, err := tt.window.parse()
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if got := w.contains(at(tt.now)); got != tt.want {
+				t.Errorf("%s contains %s = %v, want %v", tt.window, tt.now, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindowParseInvalid(t *testing.T) {
+	for _, w := range []Window{
+		{Start: "25:00", End: "03:00"},
+		{Start: "01:00", End: ""},
+		{Start: "1am", End: "03:00"},
+	} {
+		if _, err := w.parse(); err == nil {
+			t.Errorf("%s: expected error", w)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+// Policy описывает повторные попытки с экспоненциальной задержкой
+// (применение события в consumer, публикация записи в publisher)
+type Policy struct {
+	MaxAttempts     int
+	InitialInterval time.Duration
+	MaxInterval     time.Duration // 0 - без ограничения
+	Multiplier      float64
+}
+
+// Backoff возвращает задержку перед попыткой attempt+1 (attempt считается с 1)
+func (p Policy) Backoff(attempt int) time.Duration {
+	interval := float64(p.InitialInterval)
+	for i := 1; i < attempt; i++ {
+		interval *= p.Multiplier
+		if p.MaxInterval > 0 && interval >= float64(p.MaxInterval) {
+			return p.MaxInterval
+		}
+	}
+	return time.Duration(interval)
+}
+
+// Wait ждет задержку перед следующей попыткой; возвращает false при отмене контекста
+func (p Policy) Wait(ctx context.Context, attempt int) bool {
+	timer := time.NewTimer(p.Backoff(attempt))
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,54 @@
+package retry
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPolicyBackoff(t *testing.T) {
+	policy := Policy{InitialInterval: time.Second, MaxInterval: 10 * time.Second, Multiplier: 2}
+
+	tests := []struct {
+		name    string
+		policy  Policy
+		attempt int
+		want    time.Duration
+	}{
+		{name: "first attempt", policy: policy, attempt: 1, want: time.Second},
+		{name: "second attempt", policy: policy, attempt: 2, want: 2 * time.Second},
+		{name: "fourth attempt", policy: policy, attempt: 4, want: 8 * time.Second},
+		{name: "capped by max interval", policy: policy, attempt: 5, want: 10 * time.Second},
+		{name: "stays capped", policy: policy, attempt: 50, want: 10 * time.Second},
+		{
+			name:    "no max interval",
+			policy:  Policy{InitialInterval: 100 * time.Millisecond, Multiplier: 3},
+			attempt: 3,
+			want:    900 * time.Millisecond,
+		},
+		{
+			name:    "fractional multiplier",
+			policy:  Policy{InitialInterval: time.Second, MaxInterval: time.Minute, Multiplier: 1.5},
+			attempt: 3,
+			want:    2250 * time.Millisecond,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.policy.Backoff(tt.attempt); got != tt.want {
+				t.Errorf("Backoff(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicyWaitCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	policy := Policy{InitialInterval: time.Hour, Multiplier: 2}
+	if policy.Wait(ctx, 1) {
+		t.Fatal("Wait must report cancellation instead of sleeping")
+	}
+}
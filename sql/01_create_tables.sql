@@ -5,9 +5,18 @@
 -- Таблица для очереди репликации (outbox pattern)
 CREATE TABLE IF NOT EXISTS replication_queue (
     id BIGSERIAL PRIMARY KEY,
+    schema_name VARCHAR(255) NOT NULL DEFAULT 'public',
     table_name VARCHAR(255) NOT NULL,
     operation VARCHAR(10) NOT NULL,      -- INSERT, UPDATE, DELETE
     record_data JSONB NOT NULL,          -- Полные данные записи
+    payload_version SMALLINT NOT NULL DEFAULT 1, -- Версия формата record_data
+    tx_id BIGINT,                        -- txid_current() исходной транзакции
+    tx_seq INT,                          -- Порядковый номер события в транзакции (с 1)
+    tx_size INT,                         -- Число событий транзакции (заполняется при коммите)
+    shard_key INT,                       -- Хеш (таблица, primary key) для шардов publisher
+    attempts INT NOT NULL DEFAULT 0,     -- Неудачные попытки публикации
+    last_error TEXT,                     -- Ошибка последней попытки
+    next_attempt_at TIMESTAMPTZ,         -- Не публиковать раньше (экспоненциальная задержка)
     created_at TIMESTAMPTZ DEFAULT NOW(),
     published BOOLEAN DEFAULT FALSE,
     published_at TIMESTAMPTZ,
@@ -15,6 +24,18 @@ CREATE TABLE IF NOT EXISTS replication_queue (
     CONSTRAINT chk_operation CHECK (operation IN ('INSERT', 'UPDATE', 'DELETE'))
 );
 
+-- Миграция существующих установок
+ALTER TABLE replication_queue
+    ADD COLUMN IF NOT EXISTS payload_version SMALLINT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS schema_name VARCHAR(255) NOT NULL DEFAULT 'public',
+    ADD COLUMN IF NOT EXISTS tx_id BIGINT,
+    ADD COLUMN IF NOT EXISTS tx_seq INT,
+    ADD COLUMN IF NOT EXISTS tx_size INT,
+    ADD COLUMN IF NOT EXISTS shard_key INT,
+    ADD COLUMN IF NOT EXISTS attempts INT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_error TEXT,
+    ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
+
 -- Индексы для производительности
 CREATE INDEX IF NOT EXISTS idx_repl_queue_unpublished 
     ON replication_queue(created_at) 
@@ -23,13 +44,77 @@ CREATE INDEX IF NOT EXISTS idx_repl_queue_unpublished
 CREATE INDEX IF NOT EXISTS idx_repl_queue_table 
     ON replication_queue(table_name, published);
 
+-- Очистка опубликованных записей (retention publisher и cleanup_replication_queue)
+CREATE INDEX IF NOT EXISTS idx_repl_queue_published 
+    ON replication_queue(created_at) 
+    WHERE published;
+
+-- Записи, ожидающие повторной публикации (их ключи не публикуются в обход)
+CREATE INDEX IF NOT EXISTS idx_repl_queue_backoff 
+    ON replication_queue(table_name, shard_key, id) 
+    WHERE NOT published AND next_attempt_at IS NOT NULL;
+
+-- Подсчет событий транзакции при публикации
+CREATE INDEX IF NOT EXISTS idx_repl_queue_tx 
+    ON replication_queue(tx_id);
+
 -- Комментарии
 COMMENT ON TABLE replication_queue IS 'Очередь событий для репликации между контурами';
+COMMENT ON COLUMN replication_queue.schema_name IS 'Схема таблицы (используется в шаблоне имени топика)';
 COMMENT ON COLUMN replication_queue.table_name IS 'Имя таблицы, в которой произошло изменение';
 COMMENT ON COLUMN replication_queue.operation IS 'Тип операции: INSERT, UPDATE, DELETE';
-COMMENT ON COLUMN replication_queue.record_data IS 'JSON с полными данными записи после изменения';
+COMMENT ON COLUMN replication_queue.record_data IS 'JSON с данными записи: INSERT - NEW, DELETE - OLD, UPDATE - {"before": OLD, "after": NEW}';
+COMMENT ON COLUMN replication_queue.payload_version IS 'Версия формата record_data (контракт между триггером и ReplicatorPublisher)';
+COMMENT ON COLUMN replication_queue.tx_id IS 'Идентификатор исходной транзакции: события одной транзакции применяются consumer атомарно';
+COMMENT ON COLUMN replication_queue.tx_seq IS 'Номер события внутри транзакции (порядок применения)';
+COMMENT ON COLUMN replication_queue.tx_size IS 'Число событий транзакции: записывается при ее коммите и не зависит от очистки и карантина записей';
+COMMENT ON COLUMN replication_queue.shard_key IS 'Хеш таблицы и primary key: изменения одной строки публикует один воркер (NULL - шард 0)';
+COMMENT ON COLUMN replication_queue.attempts IS 'Число неудачных попыток публикации: после service.retry.max_attempts запись переносится в replication_queue_failed';
+COMMENT ON COLUMN replication_queue.next_attempt_at IS 'Время следующей попытки публикации (NULL - без задержки)';
 COMMENT ON COLUMN replication_queue.published IS 'Флаг, опубликовано ли событие в Kafka';
 
+-- ===================================================================
+-- Записи, которые не удалось опубликовать (карантин)
+-- ===================================================================
+
+CREATE TABLE IF NOT EXISTS replication_queue_failed (
+    id BIGINT PRIMARY KEY,               -- id записи в replication_queue
+    schema_name VARCHAR(255) NOT NULL DEFAULT 'public',
+    table_name VARCHAR(255) NOT NULL,
+    operation VARCHAR(10) NOT NULL,
+    record_data JSONB NOT NULL,
+    payload_version SMALLINT NOT NULL DEFAULT 1,
+    tx_id BIGINT,
+    tx_seq INT,
+    tx_size INT,
+    shard_key INT,
+    created_at TIMESTAMPTZ,
+    attempts INT NOT NULL,
+    last_error TEXT,
+    failed_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+ALTER TABLE replication_queue_failed
+    ADD COLUMN IF NOT EXISTS tx_size INT;
+
+CREATE INDEX IF NOT EXISTS idx_replication_queue_failed_table 
+    ON replication_queue_failed(table_name, failed_at);
+
+COMMENT ON TABLE replication_queue_failed IS 'Записи replication_queue, не опубликованные за service.retry.max_attempts попыток. Возврат в очередь: INSERT INTO replication_queue ... SELECT ... FROM replication_queue_failed';
+
+-- ===================================================================
+-- Аренда шардов replication_queue воркерами publisher
+-- ===================================================================
+
+CREATE TABLE IF NOT EXISTS replication_shard_leases (
+    shard INT PRIMARY KEY,
+    owner VARCHAR(255),                  -- Идентификатор экземпляра publisher (NULL - шард свободен)
+    lease_until TIMESTAMPTZ,             -- Аренда действует до (не продлена - шард может забрать другой)
+    updated_at TIMESTAMPTZ DEFAULT NOW()
+);
+
+COMMENT ON TABLE replication_shard_leases IS 'Распределение шардов replication_queue между экземплярами ReplicatorPublisher (sharding.mode: lease)';
+
 -- ===================================================================
 -- Таблица для идемпотентности (отслеживание обработанных событий)
 -- ===================================================================
@@ -46,28 +131,147 @@ CREATE INDEX IF NOT EXISTS idx_processed_events_timestamp
 
 COMMENT ON TABLE processed_events IS 'Отслеживание обработанных событий для идемпотентности';
 
+-- ===================================================================
+-- Dead Letter Queue: события, которые ReplicatorConsumer не смог применить
+-- ===================================================================
+
+CREATE TABLE IF NOT EXISTS replication_dlq (
+    id BIGSERIAL PRIMARY KEY,
+    event_id VARCHAR(255),
+    table_name VARCHAR(255),
+    operation VARCHAR(10),
+    source_contour VARCHAR(50),
+    source_topic VARCHAR(255) NOT NULL,
+    source_partition INT NOT NULL,
+    source_offset BIGINT NOT NULL,
+    message_key TEXT,
+    payload TEXT NOT NULL,               -- Исходное сообщение Kafka без изменений
+    error TEXT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    status VARCHAR(20) NOT NULL DEFAULT 'failed',
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+    redriven_at TIMESTAMPTZ,
+
+    CONSTRAINT uq_replication_dlq_source UNIQUE (source_topic, source_partition, source_offset),
+    CONSTRAINT chk_dlq_status CHECK (status IN ('failed', 'redrive', 'redriven'))
+);
+
+CREATE INDEX IF NOT EXISTS idx_replication_dlq_status 
+    ON replication_dlq(status) 
+    WHERE status = 'redrive';
+
+CREATE INDEX IF NOT EXISTS idx_replication_dlq_table 
+    ON replication_dlq(table_name, created_at);
+
+COMMENT ON TABLE replication_dlq IS 'Копия Dead Letter Queue: события, не примененные после всех попыток';
+COMMENT ON COLUMN replication_dlq.status IS 'failed - не применено, redrive - запрошено повторное применение, redriven - применено повторно';
+
+-- Повторное применение события (ReplicatorConsumer подхватит его в течение dlq.redrive_interval):
+-- UPDATE replication_dlq SET status = 'redrive' WHERE id = 42;
+
+-- ===================================================================
+-- Таблица replication_conflicts (журнал конфликтов репликации)
+-- ===================================================================
+
+CREATE TABLE IF NOT EXISTS replication_conflicts (
+    id BIGSERIAL PRIMARY KEY,
+    event_id VARCHAR(255) NOT NULL,
+    schema_name VARCHAR(255) NOT NULL,
+    table_name VARCHAR(255) NOT NULL,
+    operation VARCHAR(10) NOT NULL,
+    primary_key JSONB NOT NULL,
+    strategy VARCHAR(50) NOT NULL,
+    resolution VARCHAR(20) NOT NULL,
+    winning_version BIGINT,
+    losing_version BIGINT,
+    existing_row JSONB,                  -- Локальная строка до разрешения конфликта
+    incoming_row JSONB,                  -- Образ строки из события (before для DELETE)
+    source_contour VARCHAR(50) NOT NULL,
+    created_at TIMESTAMPTZ DEFAULT NOW(),
+
+    CONSTRAINT uq_replication_conflicts_event UNIQUE (event_id),
+    CONSTRAINT chk_conflict_resolution CHECK (resolution IN ('incoming_applied', 'existing_kept', 'rejected', 'merged'))
+);
+
+-- Обновление ограничения для уже созданной таблицы (добавлен результат merged)
+ALTER TABLE replication_conflicts DROP CONSTRAINT IF EXISTS chk_conflict_resolution;
+ALTER TABLE replication_conflicts ADD CONSTRAINT chk_conflict_resolution
+    CHECK (resolution IN ('incoming_applied', 'existing_kept', 'rejected', 'merged'));
+
+CREATE INDEX IF NOT EXISTS idx_replication_conflicts_table 
+    ON replication_conflicts(schema_name, table_name, created_at);
+
+COMMENT ON TABLE replication_conflicts IS 'Журнал конфликтов, разрешенных ReplicatorConsumer, для ручной сверки расходящихся строк';
+COMMENT ON COLUMN replication_conflicts.resolution IS 'incoming_applied - применено входящее изменение, existing_kept - оставлена локальная строка, rejected - ошибка по политике error или resolver, merged - записана строка, сведенная resolver';
+
+-- Строки, по которым контуры разошлись за последние сутки:
+-- SELECT table_name, primary_key, strategy, resolution, existing_row, incoming_row
+-- FROM replication_conflicts WHERE created_at > NOW() - INTERVAL '1 day' ORDER BY created_at;
+
+-- ===================================================================
+-- Таблица replication_tombstones (ключи удаленных строк)
+-- ===================================================================
+-- Удаление оставляет tombstone с последней версией строки. Опоздавшие INSERT/UPDATE
+-- с версией не новее tombstone не воскрешают строку. Значения ключа хранятся текстом.
+
+CREATE TABLE IF NOT EXISTS replication_tombstones (
+    schema_name VARCHAR(255) NOT NULL,
+    table_name VARCHAR(255) NOT NULL,
+    primary_key JSONB NOT NULL,
+    version BIGINT NOT NULL,             -- Версия удаленной строки
+    source_contour VARCHAR(50),          -- NULL - строка удалена на этом контуре
+    event_id VARCHAR(255),
+    deleted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+
+    PRIMARY KEY (schema_name, table_name, primary_key)
+);
+
+CREATE INDEX IF NOT EXISTS idx_replication_tombstones_deleted_at 
+    ON replication_tombstones(deleted_at);
+
+COMMENT ON TABLE replication_tombstones IS 'Ключи удаленных строк с версией на момент удаления: защита от воскрешения строки опоздавшим UPDATE';
+
 -- ===================================================================
 -- Функция для периодической очистки старых записей
 -- ===================================================================
 
-CREATE OR REPLACE FUNCTION cleanup_replication_queue(retention_days INT DEFAULT 7)
-RETURNS TABLE(deleted_count BIGINT) AS $$
+CREATE OR REPLACE FUNCTION cleanup_replication_queue_batch(cutoff TIMESTAMPTZ, batch_size INT DEFAULT NULL)
+RETURNS BIGINT AS $$
 DECLARE
     v_deleted_count BIGINT;
 BEGIN
-    -- Удаляем опубликованные записи старше retention_days
+    -- Удаляем опубликованные записи старше cutoff, не более batch_size (NULL - без ограничения)
+    -- (кроме транзакций, часть событий которых еще ждет публикации)
     DELETE FROM replication_queue
-    WHERE published = TRUE 
-      AND created_at < NOW() - (retention_days || ' days')::INTERVAL;
+    WHERE ctid = ANY(ARRAY(
+        SELECT ctid FROM replication_queue
+        WHERE published = TRUE 
+          AND created_at < cutoff
+          AND NOT (tx_id IS NOT NULL AND EXISTS (
+              SELECT 1 FROM replication_queue pending
+              WHERE pending.tx_id = replication_queue.tx_id AND NOT pending.published
+          ))
+        LIMIT batch_size
+    ));
     
     GET DIAGNOSTICS v_deleted_count = ROW_COUNT;
     
-    RETURN QUERY SELECT v_deleted_count;
+    RETURN v_deleted_count;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON FUNCTION cleanup_replication_queue_batch IS 
+    'Удаление батча опубликованных записей replication_queue старше cutoff. Вызывается retention ReplicatorPublisher и cleanup_replication_queue.';
+
+CREATE OR REPLACE FUNCTION cleanup_replication_queue(retention_days INT DEFAULT 7)
+RETURNS TABLE(deleted_count BIGINT) AS $$
+BEGIN
+    RETURN QUERY SELECT cleanup_replication_queue_batch(NOW() - (retention_days || ' days')::INTERVAL);
 END;
 $$ LANGUAGE plpgsql;
 
 COMMENT ON FUNCTION cleanup_replication_queue IS 
-    'Очистка старых опубликованных записей из replication_queue. Запускать периодически (например, раз в день).';
+    'Очистка старых опубликованных записей из replication_queue. ReplicatorPublisher выполняет очистку сам (retention); функция - для ручного запуска.';
 
 -- Пример использования:
 -- SELECT cleanup_replication_queue(7);  -- Удалить записи старше 7 дней
@@ -91,5 +295,42 @@ END;
 $$ LANGUAGE plpgsql;
 
 COMMENT ON FUNCTION cleanup_processed_events IS 
-    'Очистка старых записей из processed_events. Запускать периодически.';
+    'Очистка старых записей из processed_events. ReplicatorConsumer выполняет очистку сам (retention); функция - для ручного запуска.';
+
+-- ===================================================================
+-- Функция для очистки replication_conflicts
+-- ===================================================================
+
+CREATE OR REPLACE FUNCTION cleanup_replication_conflicts(retention_days INT DEFAULT 90)
+RETURNS TABLE(deleted_count BIGINT) AS $$
+DECLARE
+    v_deleted_count BIGINT;
+BEGIN
+    DELETE FROM replication_conflicts
+    WHERE created_at < NOW() - (retention_days || ' days')::INTERVAL;
+    
+    GET DIAGNOSTICS v_deleted_count = ROW_COUNT;
+    
+    RETURN QUERY SELECT v_deleted_count;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON FUNCTION cleanup_replication_conflicts IS 
+    'Очистка старых записей из replication_conflicts после сверки. Запускать периодически.';
+
+CREATE OR REPLACE FUNCTION cleanup_replication_tombstones(retention_days INT DEFAULT 7)
+RETURNS TABLE(deleted_count BIGINT) AS $$
+DECLARE
+    v_deleted_count BIGINT;
+BEGIN
+    DELETE FROM replication_tombstones
+    WHERE deleted_at < NOW() - (retention_days || ' days')::INTERVAL;
+    
+    GET DIAGNOSTICS v_deleted_count = ROW_COUNT;
+    
+    RETURN QUERY SELECT v_deleted_count;
+END;
+$$ LANGUAGE plpgsql;
 
+COMMENT ON FUNCTION cleanup_replication_tombstones IS 
+    'Очистка старых tombstones. Срок хранения должен превышать максимальную задержку репликации. ReplicatorConsumer выполняет очистку сам (replication.tombstones).';
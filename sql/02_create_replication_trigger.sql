@@ -1,9 +1,91 @@
+-- ===================================================================
+-- Ключ строки для replication_tombstones
+-- ===================================================================
+
+CREATE OR REPLACE FUNCTION replication_tombstone_key(p_relid OID, p_row JSONB)
+RETURNS JSONB AS $$
+DECLARE
+    v_key JSONB := '{}';
+    v_column RECORD;
+    v_value TEXT;
+BEGIN
+    -- Значение каждой колонки primary key приводится к ее типу и обратно к тексту:
+    -- ключ не зависит от того, как значение записано в JSON (1.5 и 1.50, смещение
+    -- часового пояса timestamptz, регистр uuid). Триггер передает to_jsonb(строки),
+    -- ReplicatorConsumer - значения из события, и оба получают один и тот же ключ.
+    FOR v_column IN
+        SELECT a.attname::TEXT AS name, format_type(a.atttypid, a.atttypmod) AS type_name
+        FROM pg_index i
+        JOIN pg_attribute a ON a.attrelid = i.indrelid AND a.attnum = ANY(i.indkey)
+        WHERE i.indrelid = p_relid AND i.indisprimary
+    LOOP
+        IF p_row ->> v_column.name IS NULL THEN
+            RETURN NULL;
+        END IF;
+        EXECUTE format('SELECT ($1 ->> %L)::%s::TEXT', v_column.name, v_column.type_name)
+            INTO v_value
+            USING p_row;
+        v_key := v_key || jsonb_build_object(v_column.name, v_value);
+    END LOOP;
+
+    -- Таблица без primary key
+    IF v_key = '{}' THEN
+        RETURN NULL;
+    END IF;
+    RETURN v_key;
+END;
+$$ LANGUAGE plpgsql STABLE
+SET TimeZone = 'UTC'
+SET DateStyle = 'ISO, YMD'
+SET IntervalStyle = 'postgres'
+SET extra_float_digits = 1;
+
+COMMENT ON FUNCTION replication_tombstone_key IS 
+'Возвращает ключ строки для replication_tombstones: {"колонка pk": "значение в каноническом текстовом виде"}.
+NULL, если у таблицы нет primary key или значение колонки primary key отсутствует.';
+
+-- ===================================================================
+-- Шард строки для параллельной публикации
+-- ===================================================================
+
+CREATE OR REPLACE FUNCTION replication_shard_key(p_relid OID, p_schema TEXT, p_table TEXT, p_row JSONB)
+RETURNS INT AS $$
+    -- Хеш таблицы и значения primary key: все изменения одной строки попадают в один шард
+    -- (таблица без primary key целиком попадает в один шард)
+    SELECT hashtext(p_schema || '.' || p_table || ':' || COALESCE(replication_tombstone_key(p_relid, p_row)::TEXT, ''));
+$$ LANGUAGE sql STABLE;
+
+COMMENT ON FUNCTION replication_shard_key IS 
+'Возвращает хеш (таблица, primary key) для распределения replication_queue по шардам publisher.';
+
+-- ===================================================================
+-- Порядковый номер события в транзакции
+-- ===================================================================
+
+CREATE OR REPLACE FUNCTION replication_tx_seq()
+RETURNS INT AS $$
+DECLARE
+    v_seq INT;
+BEGIN
+    -- Счетчик живет в настройке уровня транзакции (set_config(..., true)) и
+    -- откатывается вместе с точкой сохранения, поэтому номера событий идут подряд
+    v_seq := COALESCE(NULLIF(current_setting('replicator.tx_seq', true), '')::INT, 0) + 1;
+    PERFORM set_config('replicator.tx_seq', v_seq::TEXT, true);
+    RETURN v_seq;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON FUNCTION replication_tx_seq IS 
+'Возвращает следующий номер события репликации в текущей транзакции (1, 2, ...).';
+
 -- ===================================================================
 -- Универсальная функция триггера для репликации
 -- ===================================================================
 
 CREATE OR REPLACE FUNCTION generic_replication_trigger()
 RETURNS TRIGGER AS $$
+DECLARE
+    v_key JSONB;
 BEGIN
     -- ============================================================
     -- ЗАЩИТА ОТ ПЕТЛИ РЕПЛИКАЦИИ
@@ -19,11 +101,16 @@ BEGIN
     -- Обработка INSERT
     -- ============================================================
     IF TG_OP = 'INSERT' THEN
-        INSERT INTO replication_queue (table_name, operation, record_data)
+        INSERT INTO replication_queue (schema_name, table_name, operation, record_data, payload_version, tx_id, tx_seq, shard_key)
         VALUES (
+            TG_TABLE_SCHEMA::VARCHAR,
             TG_TABLE_NAME::VARCHAR,
             'INSERT',
-            row_to_json(NEW)::JSONB
+            row_to_json(NEW)::JSONB,
+            1,  -- payload_version
+            txid_current(),
+            replication_tx_seq(),
+            replication_shard_key(TG_RELID, TG_TABLE_SCHEMA, TG_TABLE_NAME, to_jsonb(NEW))
         );
         
         RETURN NEW;
@@ -32,14 +119,21 @@ BEGIN
     -- Обработка UPDATE
     -- ============================================================
     ELSIF TG_OP = 'UPDATE' THEN
-        INSERT INTO replication_queue (table_name, operation, record_data)
+        INSERT INTO replication_queue (schema_name, table_name, operation, record_data, payload_version, tx_id, tx_seq, shard_key)
         VALUES (
+            TG_TABLE_SCHEMA::VARCHAR,
             TG_TABLE_NAME::VARCHAR,
             'UPDATE',
             jsonb_build_object(
                 'before', row_to_json(OLD)::JSONB,
                 'after', row_to_json(NEW)::JSONB
-            )
+            ),
+            1,  -- payload_version
+            txid_current(),
+            replication_tx_seq(),
+            -- Шард по ключу после изменения, как ключ партиции Kafka в ReplicatorPublisher:
+            -- при смене primary key событие идет вместе с последующими изменениями строки
+            replication_shard_key(TG_RELID, TG_TABLE_SCHEMA, TG_TABLE_NAME, to_jsonb(NEW))
         );
         
         RETURN NEW;
@@ -48,12 +142,34 @@ BEGIN
     -- Обработка DELETE
     -- ============================================================
     ELSIF TG_OP = 'DELETE' THEN
-        INSERT INTO replication_queue (table_name, operation, record_data)
+        INSERT INTO replication_queue (schema_name, table_name, operation, record_data, payload_version, tx_id, tx_seq, shard_key)
         VALUES (
+            TG_TABLE_SCHEMA::VARCHAR,
             TG_TABLE_NAME::VARCHAR,
             'DELETE',
-            row_to_json(OLD)::JSONB
+            row_to_json(OLD)::JSONB,
+            1,  -- payload_version
+            txid_current(),
+            replication_tx_seq(),
+            replication_shard_key(TG_RELID, TG_TABLE_SCHEMA, TG_TABLE_NAME, to_jsonb(OLD))
         );
+
+        -- Tombstone: опоздавший UPDATE с другого контура не воскресит строку
+        v_key := replication_tombstone_key(TG_RELID, to_jsonb(OLD));
+        IF v_key IS NOT NULL THEN
+            INSERT INTO replication_tombstones (schema_name, table_name, primary_key, version)
+            VALUES (
+                TG_TABLE_SCHEMA::VARCHAR,
+                TG_TABLE_NAME::VARCHAR,
+                v_key,
+                COALESCE((to_jsonb(OLD) ->> 'version')::BIGINT, 0)
+            )
+            ON CONFLICT (schema_name, table_name, primary_key) DO UPDATE
+            SET version = GREATEST(replication_tombstones.version, EXCLUDED.version),
+                source_contour = NULL,
+                event_id = NULL,
+                deleted_at = NOW();
+        END IF;
         
         RETURN OLD;
     END IF;
@@ -68,20 +184,87 @@ COMMENT ON FUNCTION generic_replication_trigger IS
 Если application_name = ''replicator_consumer'', триггер не срабатывает.
 Используется для AFTER INSERT OR UPDATE OR DELETE триггеров.';
 
+-- ===================================================================
+-- Уведомление ReplicatorPublisher о новых записях в replication_queue
+-- ===================================================================
+
+CREATE OR REPLACE FUNCTION notify_replication_queue()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- NOTIFY доставляется после COMMIT, одинаковые уведомления в транзакции схлопываются
+    PERFORM pg_notify('replication_queue', '');
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS replication_queue_notify_trigger ON replication_queue;
+CREATE TRIGGER replication_queue_notify_trigger
+    AFTER INSERT ON replication_queue
+    FOR EACH STATEMENT
+    EXECUTE FUNCTION notify_replication_queue();
+
+COMMENT ON FUNCTION notify_replication_queue IS 
+'Отправляет NOTIFY в канал replication_queue при вставке событий.
+ReplicatorPublisher держит LISTEN-соединение и забирает события без ожидания poll_interval.';
+
+-- ===================================================================
+-- Число событий транзакции в replication_queue
+-- ===================================================================
+
+CREATE OR REPLACE FUNCTION replication_tx_size()
+RETURNS TRIGGER AS $$
+BEGIN
+    -- Отложенный триггер срабатывает при коммите на первом событии транзакции:
+    -- к этому моменту счетчик replication_tx_seq равен числу ее событий
+    UPDATE replication_queue
+    SET tx_size = COALESCE(NULLIF(current_setting('replicator.tx_seq', true), '')::INT, NEW.tx_seq)
+    WHERE tx_id = NEW.tx_id AND tx_seq IS NOT NULL;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS replication_queue_tx_size_trigger ON replication_queue;
+CREATE CONSTRAINT TRIGGER replication_queue_tx_size_trigger
+    AFTER INSERT ON replication_queue
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW
+    WHEN (NEW.tx_seq = 1)
+    EXECUTE FUNCTION replication_tx_size();
+
+COMMENT ON FUNCTION replication_tx_size IS 
+'Записывает tx_size всем событиям транзакции при ее коммите.
+Число событий фиксируется один раз и не меняется при удалении записей очисткой или карантином.';
+
 -- ===================================================================
 -- Вспомогательная функция для инкремента версии при UPDATE
 -- ===================================================================
 
 CREATE OR REPLACE FUNCTION increment_version_on_update()
 RETURNS TRIGGER AS $$
+DECLARE
+    v_tombstone_version BIGINT;
 BEGIN
+    -- ReplicatorConsumer применяет version и updated_at источника как есть:
+    -- по ним разрешаются конфликты (last_write_wins, timestamp)
+    IF current_setting('application_name', true) = 'replicator_consumer' THEN
+        RETURN NEW;
+    END IF;
+
     -- Автоматически инкрементируем version при UPDATE
     IF TG_OP = 'UPDATE' THEN
         NEW.version = OLD.version + 1;
         NEW.updated_at = NOW();
     ELSIF TG_OP = 'INSERT' THEN
+        -- Повторная вставка удаленного ключа продолжает его версию после tombstone,
+        -- иначе другой контур отбросит ее как устаревшую
+        DELETE FROM replication_tombstones
+        WHERE schema_name = TG_TABLE_SCHEMA
+          AND table_name = TG_TABLE_NAME
+          AND primary_key = replication_tombstone_key(TG_RELID, to_jsonb(NEW))
+        RETURNING version INTO v_tombstone_version;
+
         -- При INSERT устанавливаем version=1 если не задано
-        NEW.version = COALESCE(NEW.version, 1);
+        NEW.version = GREATEST(COALESCE(NEW.version, 1), COALESCE(v_tombstone_version + 1, 1));
         NEW.updated_at = COALESCE(NEW.updated_at, NOW());
     END IF;
     
@@ -93,6 +276,86 @@ COMMENT ON FUNCTION increment_version_on_update IS
 'Автоматически инкрементирует поле version при UPDATE.
 Используется в BEFORE триггере.';
 
+-- ===================================================================
+-- Hybrid Logical Clock (HLC) для разрешения конфликтов (стратегия hlc)
+-- ===================================================================
+-- HLC хранится в BIGINT: старшие биты - физическое время в мс, младшие 16 бит - логический счетчик.
+-- Новое значение всегда больше предыдущего значения строки, даже если часы контура отстают,
+-- поэтому изменение строки после применения чужого события упорядочено после него.
+
+CREATE OR REPLACE FUNCTION stamp_hlc()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_physical BIGINT;
+BEGIN
+    -- ReplicatorConsumer сохраняет HLC источника
+    IF current_setting('application_name', true) = 'replicator_consumer' THEN
+        RETURN NEW;
+    END IF;
+
+    v_physical := (EXTRACT(EPOCH FROM clock_timestamp()) * 1000)::BIGINT << 16;
+
+    IF TG_OP = 'UPDATE' THEN
+        NEW.hlc := GREATEST(v_physical, COALESCE(OLD.hlc, 0) + 1);
+    ELSE
+        NEW.hlc := GREATEST(v_physical, COALESCE(NEW.hlc, 0) + 1);
+    END IF;
+
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON FUNCTION stamp_hlc IS 
+'Проставляет HLC (hybrid logical clock) в колонку hlc при INSERT/UPDATE.
+Используется в BEFORE триггере таблиц со стратегией конфликтов hlc.';
+
+CREATE OR REPLACE FUNCTION enable_hlc_for_table(
+    p_table_name VARCHAR,
+    p_schema_name VARCHAR DEFAULT 'public'
+)
+RETURNS TEXT AS $$
+DECLARE
+    v_trigger_name VARCHAR;
+BEGIN
+    v_trigger_name := p_table_name || '_hlc_trigger';
+
+    EXECUTE format(
+        'ALTER TABLE %I.%I ADD COLUMN IF NOT EXISTS hlc BIGINT',
+        p_schema_name,
+        p_table_name
+    );
+
+    EXECUTE format(
+        'DROP TRIGGER IF EXISTS %I ON %I.%I',
+        v_trigger_name,
+        p_schema_name,
+        p_table_name
+    );
+
+    EXECUTE format(
+        'CREATE TRIGGER %I
+         BEFORE INSERT OR UPDATE ON %I.%I
+         FOR EACH ROW
+         EXECUTE FUNCTION stamp_hlc()',
+        v_trigger_name,
+        p_schema_name,
+        p_table_name
+    );
+
+    RETURN format(
+        'HLC enabled for table %I.%I: column hlc, trigger %I',
+        p_schema_name,
+        p_table_name,
+        v_trigger_name
+    );
+END;
+$$ LANGUAGE plpgsql;
+
+COMMENT ON FUNCTION enable_hlc_for_table IS 
+'Добавляет колонку hlc и BEFORE триггер stamp_hlc() для стратегии конфликтов hlc.
+Выполняется на обоих контурах.
+Использование: SELECT enable_hlc_for_table(''users'');';
+
 -- ===================================================================
 -- Функция для создания триггеров на таблице (helper)
 -- ===================================================================
@@ -209,3 +209,33 @@ WHERE published = TRUE
 SELECT cleanup_replication_queue(7);  -- Удалить записи старше 7 дней
 SELECT cleanup_processed_events(30);  -- Удалить записи старше 30 дней
 
+
+-- ===================================================================
+-- Пример resolver конфликтов (conflict_resolution: custom в config.consumer.yaml)
+-- ===================================================================
+-- Функция получает локальную строку, входящий образ и метаданные конфликта (jsonb)
+-- и возвращает: NULL или 'skip' - оставить локальную строку, 'apply' - применить входящее
+-- изменение, jsonb объект - колонки, которые нужно записать. RAISE отклоняет событие.
+-- Функция должна быть симметричной: на обоих контурах для одной пары строк - одинаковый результат.
+
+CREATE OR REPLACE FUNCTION resolve_account_conflict(local JSONB, incoming JSONB, meta JSONB)
+RETURNS JSONB AS $$
+BEGIN
+    IF meta->>'operation' = 'DELETE' THEN
+        RETURN to_jsonb('skip'::TEXT);  -- Счета с остатком не удаляем
+    END IF;
+
+    -- Больший остаток и объединение тегов
+    RETURN jsonb_build_object(
+        'balance', GREATEST((local->>'balance')::NUMERIC, (incoming->>'balance')::NUMERIC),
+        'tags', (
+            SELECT COALESCE(jsonb_agg(DISTINCT tag ORDER BY tag), '[]'::JSONB)
+            FROM (
+                SELECT jsonb_array_elements_text(COALESCE(local->'tags', '[]'::JSONB)) AS tag
+                UNION
+                SELECT jsonb_array_elements_text(COALESCE(incoming->'tags', '[]'::JSONB))
+            ) AS tags
+        )
+    );
+END;
+$$ LANGUAGE plpgsql IMMUTABLE;
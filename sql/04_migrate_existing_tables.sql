@@ -213,7 +213,7 @@ SELECT
 FROM information_schema.tables t
 WHERE t.table_schema = 'public'
   AND t.table_type = 'BASE TABLE'
-  AND t.table_name NOT IN ('replication_queue', 'processed_events')
+  AND t.table_name NOT IN ('replication_queue', 'processed_events', 'replication_dlq', 'replication_conflicts', 'replication_tombstones', 'replication_shard_leases', 'replication_queue_failed')
 ORDER BY t.table_name;
 
 -- Проверить, какие таблицы имеют триггеры репликации
@@ -226,7 +226,7 @@ JOIN pg_namespace n ON c.relnamespace = n.oid
 LEFT JOIN pg_trigger t ON t.tgrelid = c.oid
 WHERE n.nspname = 'public'
   AND c.relkind = 'r'
-  AND c.relname NOT IN ('replication_queue', 'processed_events')
+  AND c.relname NOT IN ('replication_queue', 'processed_events', 'replication_dlq', 'replication_conflicts', 'replication_tombstones', 'replication_shard_leases', 'replication_queue_failed')
 GROUP BY c.relname
 ORDER BY c.relname;
 
@@ -251,7 +251,7 @@ BEGIN
         FROM information_schema.tables
         WHERE table_schema = p_schema_name
           AND table_type = 'BASE TABLE'
-          AND table_name NOT IN ('replication_queue', 'processed_events');
+          AND table_name NOT IN ('replication_queue', 'processed_events', 'replication_dlq', 'replication_conflicts', 'replication_tombstones', 'replication_shard_leases', 'replication_queue_failed');
     ELSE
         v_tables := p_tables;
     END IF;